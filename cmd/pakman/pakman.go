@@ -1,16 +1,22 @@
 package main
 
 import (
+	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/user"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/WithoutPants/pakman/pkg/executor"
 	"github.com/WithoutPants/pakman/pkg/pak"
 	"github.com/WithoutPants/pakman/pkg/repository/fs"
 	"github.com/WithoutPants/pakman/pkg/repository/http"
+	"github.com/WithoutPants/pakman/pkg/repository/iofs"
 	"gopkg.in/yaml.v3"
 )
 
@@ -23,7 +29,8 @@ var (
 type logger struct{}
 
 func (l logger) Debugf(format string, args ...interface{}) {
-	if cfg.Debug {
+	// repository commands may be run without a configuration
+	if cfg != nil && cfg.Debug {
 		l.Infof(format, args...)
 	}
 }
@@ -40,6 +47,22 @@ func main() {
 
 	cmd := os.Args[1]
 
+	// repository maintenance commands do not require the configuration
+	switch cmd {
+	case "repo":
+		repoCommand()
+		return
+	case "publish":
+		publishCommand()
+		return
+	case "serve":
+		serveCommand()
+		return
+	case "mirror":
+		mirrorCommand()
+		return
+	}
+
 	if err := loadConfig(); err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
 		os.Exit(1)
@@ -63,6 +86,30 @@ func main() {
 		installed()
 	case "search":
 		search()
+	case "info":
+		info()
+	case "enable":
+		enable()
+	case "disable":
+		disable()
+	case "hold":
+		hold()
+	case "unhold":
+		unhold()
+	case "downgrade":
+		downgrade()
+	case "rollback":
+		rollback()
+	case "history":
+		history()
+	case "autoremove":
+		autoremove()
+	case "verify":
+		verify()
+	case "repair":
+		repair()
+	case "owns":
+		owns()
 	default:
 		fmt.Printf("Unknown command: %s\n", cmd)
 		usage()
@@ -71,28 +118,64 @@ func main() {
 }
 
 func initManager() {
-	var remote pak.SourceRepository
-	if strings.HasPrefix(cfg.RemotePath, "http://") || strings.HasPrefix(cfg.RemotePath, "https://") {
-		u, err := url.Parse(cfg.RemotePath)
+	remote := sourceRepository(cfg.RemotePath)
+
+	keepVersions := 1
+	if cfg.KeepVersions != nil {
+		keepVersions = *cfg.KeepVersions
+	}
+
+	manager = pak.NewManager(pak.ManagerOptions{
+		Local: &fs.Repository{
+			BaseDir:    cfg.LocalPath,
+			SharedRoot: cfg.SharedRoot,
+		},
+		Remote:         remote,
+		KeepVersions:   keepVersions,
+		Actor:          actor(),
+		Executor:       &executor.Restricted{},
+		AllowScripts:   cfg.AllowScripts,
+		DefaultChannel: cfg.Channel,
+		Logger:         logger{},
+	})
+}
+
+// sourceRepository returns the repository at the given path or URL.
+// Paths ending in .zip are read from the zip file.
+func sourceRepository(remotePath string) pak.SourceRepository {
+	if strings.HasPrefix(remotePath, "http://") || strings.HasPrefix(remotePath, "https://") {
+		u, err := url.Parse(remotePath)
 		if err != nil {
 			fmt.Printf("Error parsing remote URL: %v\n", err)
 			os.Exit(1)
 		}
 
-		remote = http.New(*u, nil)
-	} else {
-		remote = &fs.Repository{
-			BaseDir: cfg.RemotePath,
+		return http.New(*u, nil)
+	}
+
+	if strings.HasSuffix(strings.ToLower(remotePath), ".zip") {
+		// the zip file remains open until pakman exits
+		z, err := zip.OpenReader(remotePath)
+		if err != nil {
+			fmt.Printf("Error opening remote zip file: %v\n", err)
+			os.Exit(1)
 		}
+
+		return &iofs.Repository{FS: z, Name: remotePath}
 	}
 
-	manager = pak.NewManager(pak.ManagerOptions{
-		Local: &fs.Repository{
-			BaseDir: cfg.LocalPath,
-		},
-		Remote: remote,
-		Logger: logger{},
-	})
+	return &fs.Repository{
+		BaseDir: remotePath,
+	}
+}
+
+func actor() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+
+	return u.Username
 }
 
 func usage() {
@@ -106,20 +189,51 @@ The format of pakman.yml is as follows:
 local: /path/to/local/repository
 remote: /path/to/remote/repository
 debug: true|false (optional)
+allowScripts: true|false (optional)
+keepVersions: <number> (optional)
+sharedRoot: true|false (optional)
+channel: <name> (optional)
 
 local must be a path to a directory where packages will be installed to.
-remote must be a path to a directory where packages will be downloaded from, or a URL to a remote repository. If it is a URL, it must be a valid HTTP or HTTPS URL.
+remote must be a path to a directory or zip file where packages will be downloaded from, or a URL to a remote repository. If it is a URL, it must be a valid HTTP or HTTPS URL.
 
 debug is optional. If set to true, pakman will output debug messages.
 
+allowScripts is optional. If set to true, pakman will run the post-install and pre-uninstall commands declared by packages. Commands are not run by default.
+
+keepVersions is optional. It is the number of previously installed versions of each package to keep for rollback. Defaults to 1.
+
+sharedRoot is optional. If set to true, the files of all packages are installed directly into the local directory, rather than a directory per package. Packages with conflicting files are not installed.
+
+channel is optional. It is the release channel, such as beta, that packages are installed and upgraded from by default. Packages without the channel are installed from their current version.
+
 Commands:
-  install <package ID>...	Install one or more packages
-  uninstall <package ID>...	Uninstall one or more packages
+  install [--channel <name>] <package ID>[@<version>]...	Install one or more packages, optionally pinned to a version or from a release channel. Installed packages follow the channel when upgraded.
+  uninstall [--force] <package ID>...	Uninstall one or more packages. Packages required by other installed packages are only uninstalled if --force is specified.
   upgrade <package ID>...	Upgrade one or more packages. If no package ID is specified, all eligible packages will be upgraded.
   upgradable			    List upgradable packages
   list				        List all packages
   installed			        List installed packages
-  search <query>			Search for packages
+  search <query>			Search for packages by ID or tag
+  info <package ID>		Show details of a package
+  enable <package ID>...	Enable one or more disabled packages
+  disable <package ID>...	Disable one or more packages without uninstalling them
+  hold <package ID>...		Prevent one or more packages from being upgraded when upgrading all packages
+  unhold <package ID>...	Allow one or more held packages to be upgraded
+  downgrade [-y] <package ID> <version>	Install an older version of a package. Asks for confirmation unless -y is specified.
+  rollback <package ID>		Restore the previously installed version of a package
+  history [package ID]		Show the history of operations, optionally for a single package
+  autoremove			Uninstall packages installed as dependencies that are no longer required
+  verify [package ID]...	Check installed packages for missing, modified and extraneous files
+  repair [package ID]...	Download missing and modified files of installed packages
+  owns <path>			Show the packages that installed the file at the given path, relative to the local repository
+
+Repository commands do not require pakman.yml:
+  serve [--addr <address>] [<dir or URL>]	Serve a repository over HTTP, by default on :8080. The repository defaults to the remote in pakman.yml.
+  publish [--repo <dir>] [--version <version>] <package dir>	Publish a version of the package defined by pak.yml in the package directory to a repository directory. The repository defaults to the remote in pakman.yml.
+  repo build [--sharded] [--json] [--compress <encoding>]... [--keep-changes <n>] <dir>	Generate the manifests and index of a source repository from the package files in it
+  mirror [--latest] [--verify] [build options] <source dir or URL> <dir> [<package ID>[@<version>]...]	Copy packages from a repository into a repository directory, optionally only the given packages and versions, or the latest versions. Already mirrored versions are skipped. Accepts the options of repo build, which otherwise default to those of the existing directory.
+  repo lint <dir or URL>	Check a source repository for problems, such as missing manifests and files
 	`)
 }
 
@@ -130,10 +244,27 @@ func install() {
 		os.Exit(1)
 	}
 
+	args := os.Args[2:]
+	channel := ""
+	if len(args) > 1 && args[0] == "--channel" {
+		channel = args[1]
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		fmt.Println("Missing package IDs")
+		usage()
+		os.Exit(1)
+	}
+
 	var specs []pak.InstallSpec
-	for _, id := range os.Args[2:] {
+	for _, arg := range args {
+		// a version may be pinned with <id>@<version>
+		id, version, _ := strings.Cut(arg, "@")
 		specs = append(specs, pak.InstallSpec{
-			ID: id,
+			ID:      id,
+			Version: version,
+			Channel: channel,
 		})
 	}
 
@@ -145,13 +276,26 @@ func install() {
 }
 
 func uninstall() {
-	if len(os.Args[1:]) < 2 {
+	ids := os.Args[2:]
+	force := false
+	if len(ids) > 0 && ids[0] == "--force" {
+		force = true
+		ids = ids[1:]
+	}
+
+	if len(ids) == 0 {
 		fmt.Println("Missing package IDs")
 		usage()
 		os.Exit(1)
 	}
 
-	err := manager.Uninstall(ctx, os.Args[2:]...)
+	var err error
+	if force {
+		err = manager.ForceUninstall(ctx, ids...)
+	} else {
+		err = manager.Uninstall(ctx, ids...)
+	}
+
 	if err != nil {
 		fmt.Printf("Error uninstalling packages: %v\n", err)
 		os.Exit(1)
@@ -182,7 +326,14 @@ func upgradable() {
 	}
 
 	for _, v := range u {
-		fmt.Printf("%s %s -> %s\n", v.ID, v.CurrentVersion, v.LatestVersion)
+		status := ""
+		if v.Channel != "" {
+			status += " [" + v.Channel + "]"
+		}
+		if v.Held {
+			status += " (held)"
+		}
+		fmt.Printf("%s %s -> %s%s\n", v.ID, v.CurrentVersion, v.LatestVersion, status)
 	}
 }
 
@@ -196,13 +347,44 @@ func list() {
 	keys := sortedKeys(index)
 
 	for _, k := range keys {
-		v := index[k]
-		for _, vv := range v.Versions {
-			fmt.Printf("%s %s %s\n", v.ID, vv, v.Description)
+		printVersions(index[k])
+	}
+}
+
+func printVersions(spec pak.Spec) {
+	versions := spec.Versions
+	if len(versions) == 0 {
+		// the versions are not included in summaries
+		versions = []pak.VersionInfo{{Version: spec.CurrentVersion}}
+	}
+
+	for _, v := range versions {
+		var status []string
+		if v.Yanked {
+			status = append(status, "yanked")
+		}
+		if v.Deprecated != "" {
+			status = append(status, "deprecated")
+		}
+
+		if len(status) > 0 {
+			fmt.Printf("%s %s (%s) %s\n", spec.ID, v, strings.Join(status, ", "), spec.Description)
+		} else {
+			fmt.Printf("%s %s %s\n", spec.ID, v, spec.Description)
 		}
 	}
 }
 
+func sortedChannels(channels map[string]string) []string {
+	var ret []string
+	for c := range channels {
+		ret = append(ret, c)
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
 func sortedKeys(index pak.SpecIndex) []string {
 	// sort keys
 	var keys []string
@@ -229,9 +411,28 @@ func installed() {
 	}
 
 	for _, v := range installed {
-		fmt.Printf("%s %s\n", v.ID, v.Version)
+		var status []string
+		if v.Channel != "" {
+			status = append(status, v.Channel)
+		}
+		if v.Disabled {
+			status = append(status, "disabled")
+		}
+		if v.Held {
+			status = append(status, "held")
+		}
+		if v.AutoInstalled {
+			status = append(status, "dependency")
+		}
+
+		if len(status) > 0 {
+			fmt.Printf("%s %s (%s)\n", v.ID, v.Version, strings.Join(status, ", "))
+		} else {
+			fmt.Printf("%s %s\n", v.ID, v.Version)
+		}
 	}
 }
+
 func search() {
 	if len(os.Args[1:]) < 2 {
 		fmt.Println("Missing search term")
@@ -239,7 +440,7 @@ func search() {
 		os.Exit(1)
 	}
 
-	index, err := manager.List(ctx)
+	index, err := manager.ListSummaries(ctx)
 	if err != nil {
 		fmt.Printf("Error listing packages: %v\n", err)
 		os.Exit(1)
@@ -247,12 +448,302 @@ func search() {
 
 	keys := sortedKeys(index)
 	for _, k := range keys {
-		if strings.Contains(strings.ToLower(k), strings.ToLower(os.Args[2])) {
-			v := index[k]
-			for _, vv := range v.Versions {
-				fmt.Printf("%s %s %s\n", v.ID, vv, v.Description)
-			}
+		v := index[k]
+		if strings.Contains(strings.ToLower(k), strings.ToLower(os.Args[2])) || v.HasTag(os.Args[2]) {
+			printVersions(v)
+		}
+	}
+}
+
+func info() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing package ID")
+		usage()
+		os.Exit(1)
+	}
+
+	i, err := manager.Info(ctx, os.Args[2])
+	if err != nil {
+		fmt.Printf("Error getting package info: %v\n", err)
+		os.Exit(1)
+	}
+
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Printf("%-13s %s\n", name+":", value)
+		}
+	}
+
+	field("ID", i.ID)
+	field("Name", i.Name)
+	field("Description", i.Description)
+	field("Version", i.CurrentVersion)
+	var channels []string
+	for _, c := range sortedChannels(i.Channels) {
+		channels = append(channels, c+" "+i.Channels[c])
+	}
+	field("Channels", strings.Join(channels, ", "))
+	if i.Installed != nil {
+		field("Installed", i.Installed.Version)
+	}
+	field("Authors", strings.Join(i.Authors, ", "))
+	field("License", i.License)
+	field("Homepage", i.Homepage)
+	field("Source", i.Source)
+	field("Tags", strings.Join(i.Tags, ", "))
+	field("Icon", i.Icon)
+	field("Screenshots", strings.Join(i.Screenshots, ", "))
+	if !i.Updated.IsZero() {
+		field("Updated", i.Updated.Format(time.RFC3339))
+	}
+
+	if i.Latest.Changelog != "" {
+		fmt.Printf("\nChangelog for %s:\n%s\n", i.Latest.Version, strings.TrimRight(i.Latest.Changelog, "\n"))
+	}
+}
+
+func enable() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing package IDs")
+		usage()
+		os.Exit(1)
+	}
+
+	err := manager.Enable(ctx, os.Args[2:]...)
+	if err != nil {
+		fmt.Printf("Error enabling packages: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func disable() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing package IDs")
+		usage()
+		os.Exit(1)
+	}
+
+	err := manager.Disable(ctx, os.Args[2:]...)
+	if err != nil {
+		fmt.Printf("Error disabling packages: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func hold() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing package IDs")
+		usage()
+		os.Exit(1)
+	}
+
+	err := manager.Hold(ctx, os.Args[2:]...)
+	if err != nil {
+		fmt.Printf("Error holding packages: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func unhold() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing package IDs")
+		usage()
+		os.Exit(1)
+	}
+
+	err := manager.Unhold(ctx, os.Args[2:]...)
+	if err != nil {
+		fmt.Printf("Error unholding packages: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func downgrade() {
+	args := os.Args[2:]
+	yes := false
+	if len(args) > 0 && args[0] == "-y" {
+		yes = true
+		args = args[1:]
+	}
+
+	if len(args) != 2 {
+		fmt.Println("Missing package ID and version")
+		usage()
+		os.Exit(1)
+	}
+
+	id, version := args[0], args[1]
+
+	existing, err := manager.GetInstalled(ctx, id)
+	var notInstalled pak.PakNotInstalledError
+	if errors.As(err, &notInstalled) {
+		fmt.Printf("Package %s is not installed\n", id)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Printf("Error getting installed package: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !yes && !confirm(fmt.Sprintf("Downgrade %s from %s to %s?", id, existing.Version, version)) {
+		fmt.Println("Aborted")
+		os.Exit(1)
+	}
+
+	err = manager.Downgrade(ctx, pak.InstallSpec{ID: id, Version: version})
+	if err != nil {
+		fmt.Printf("Error downgrading package: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func rollback() {
+	if len(os.Args[1:]) != 2 {
+		fmt.Println("Missing package ID")
+		usage()
+		os.Exit(1)
+	}
+
+	_, err := manager.Rollback(ctx, os.Args[2])
+	if err != nil {
+		fmt.Printf("Error rolling back package: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+
+	var answer string
+	// an empty line returns an error, which is treated as no
+	_, _ = fmt.Scanln(&answer)
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func history() {
+	id := ""
+	if len(os.Args[1:]) > 1 {
+		id = os.Args[2]
+	}
+
+	entries, err := manager.History(ctx, id)
+	if err != nil {
+		fmt.Printf("Error reading history: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, e := range entries {
+		var versions string
+		switch {
+		case e.OldVersion != "" && e.NewVersion != "":
+			versions = e.OldVersion + " -> " + e.NewVersion
+		case e.OldVersion != "":
+			versions = e.OldVersion
+		default:
+			versions = e.NewVersion
+		}
+
+		fmt.Printf("%s %s %s %s %s", e.Time.Format(time.RFC3339), e.Action, e.ID, versions, e.Result)
+		if e.Actor != "" {
+			fmt.Printf(" by %s", e.Actor)
 		}
+		if e.Source != "" {
+			fmt.Printf(" from %s", e.Source)
+		}
+		if e.Reason != "" {
+			fmt.Printf(" (%s)", e.Reason)
+		}
+		if e.Error != "" {
+			fmt.Printf(": %s", e.Error)
+		}
+		fmt.Println()
+	}
+}
+
+func autoremove() {
+	removed, err := manager.Autoremove(ctx)
+	if err != nil {
+		fmt.Printf("Error removing packages: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(removed) == 0 {
+		fmt.Println("No packages to remove")
+	}
+}
+
+func verify() {
+	results, err := manager.Verify(ctx, os.Args[2:]...)
+	if err != nil {
+		fmt.Printf("Error verifying packages: %v\n", err)
+		os.Exit(1)
+	}
+
+	ok := printVerifyResults(results)
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func printVerifyResults(results []pak.VerifyResult) bool {
+	ok := true
+	for _, r := range results {
+		if r.OK() && len(r.Extraneous) == 0 {
+			fmt.Printf("%s %s OK\n", r.ID, r.Version)
+			continue
+		}
+
+		if !r.OK() {
+			ok = false
+		}
+
+		fmt.Printf("%s %s:\n", r.ID, r.Version)
+		for _, f := range r.Missing {
+			fmt.Printf("  missing: %s\n", f)
+		}
+		for _, f := range r.Modified {
+			fmt.Printf("  modified: %s\n", f)
+		}
+		for _, f := range r.Extraneous {
+			fmt.Printf("  extraneous: %s\n", f)
+		}
+	}
+
+	return ok
+}
+
+func repair() {
+	results, err := manager.Repair(ctx, os.Args[2:]...)
+	printVerifyResults(results)
+	if err != nil {
+		fmt.Printf("Error repairing packages: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func owns() {
+	if len(os.Args[1:]) != 2 {
+		fmt.Println("Missing path")
+		usage()
+		os.Exit(1)
+	}
+
+	owners, err := manager.Owns(ctx, os.Args[2])
+	if err != nil {
+		fmt.Printf("Error finding owners: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(owners) == 0 {
+		fmt.Printf("%s is not owned by any package\n", os.Args[2])
+		os.Exit(1)
+	}
+
+	for _, o := range owners {
+		fmt.Println(o)
 	}
 }
 
@@ -260,6 +751,11 @@ type config struct {
 	LocalPath  string `yaml:"localPath"`
 	RemotePath string `yaml:"remotePath"`
 	Debug      bool   `yaml:"debug"`
+
+	AllowScripts bool   `yaml:"allowScripts"`
+	KeepVersions *int   `yaml:"keepVersions"`
+	SharedRoot   bool   `yaml:"sharedRoot"`
+	Channel      string `yaml:"channel"`
 }
 
 func loadConfig() error {
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repo/build"
+	"github.com/WithoutPants/pakman/pkg/repo/lint"
+	"github.com/WithoutPants/pakman/pkg/repo/mirror"
+	"github.com/WithoutPants/pakman/pkg/repo/publish"
+	"github.com/WithoutPants/pakman/pkg/repo/serve"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
+)
+
+func repoCommand() {
+	if len(os.Args[1:]) < 2 {
+		fmt.Println("Missing repo command")
+		usage()
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "build":
+		repoBuild()
+	case "lint":
+		repoLint()
+	default:
+		fmt.Printf("Unknown repo command: %s\n", os.Args[2])
+		usage()
+		os.Exit(1)
+	}
+}
+
+func repoBuild() {
+	args := os.Args[3:]
+	var options build.Options
+
+	for len(args) > 1 && strings.HasPrefix(args[0], "--") {
+		var ok bool
+		if args, ok = parseBuildOption(args, &options); !ok {
+			fmt.Printf("Unknown option: %s\n", args[0])
+			usage()
+			os.Exit(1)
+		}
+	}
+
+	if len(args) != 1 {
+		fmt.Println("Missing repository directory")
+		usage()
+		os.Exit(1)
+	}
+
+	index, err := build.Build(ctx, args[0], options)
+	if err != nil {
+		fmt.Printf("Error building repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Built index revision %d with %d packages\n", index.Revision, len(index.Paks))
+}
+
+// parseBuildOption parses the build option at the start of args, returning the
+// remaining arguments. It returns false if the option is not a build option.
+func parseBuildOption(args []string, options *build.Options) ([]string, bool) {
+	switch args[0] {
+	case "--sharded":
+		options.Sharded = true
+	case "--json":
+		options.Codec = codec.JSON
+	case "--compress":
+		if len(args) < 2 {
+			return args, false
+		}
+		e := compress.ForName(args[1])
+		if e == nil {
+			fmt.Printf("Unknown compression encoding: %s\n", args[1])
+			os.Exit(1)
+		}
+		options.Compress = append(options.Compress, *e)
+		return args[2:], true
+	case "--keep-changes":
+		if len(args) < 2 {
+			return args, false
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			fmt.Printf("Invalid number of changes: %s\n", args[1])
+			os.Exit(1)
+		}
+		options.KeepChanges = n
+		return args[2:], true
+	default:
+		return args, false
+	}
+
+	return args[1:], true
+}
+
+func repoLint() {
+	if len(os.Args[3:]) != 1 {
+		fmt.Println("Missing repository directory or URL")
+		usage()
+		os.Exit(1)
+	}
+
+	problems, err := lint.Lint(ctx, sourceRepository(os.Args[3]))
+	if err != nil {
+		fmt.Printf("Error checking repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("%d problems found\n", len(problems))
+		os.Exit(1)
+	}
+}
+
+func publishCommand() {
+	var repoDir, version string
+	var args []string
+
+	for i := 2; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case (arg == "--repo" || arg == "--version") && i+1 < len(os.Args):
+			i++
+			if arg == "--repo" {
+				repoDir = os.Args[i]
+			} else {
+				version = os.Args[i]
+			}
+		case strings.HasPrefix(arg, "--"):
+			fmt.Printf("Unknown option: %s\n", arg)
+			usage()
+			os.Exit(1)
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	if len(args) != 1 {
+		fmt.Println("Missing package directory")
+		usage()
+		os.Exit(1)
+	}
+
+	if repoDir == "" {
+		if err := loadConfig(); err != nil {
+			fmt.Printf("No repository given and error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		repoDir = cfg.RemotePath
+	}
+
+	if strings.HasPrefix(repoDir, "http://") || strings.HasPrefix(repoDir, "https://") {
+		fmt.Println("Only repositories on the file system can be published to")
+		os.Exit(1)
+	}
+
+	pkg, err := publish.Load(args[0], version)
+	if err != nil {
+		fmt.Printf("Error loading package: %v\n", err)
+		os.Exit(1)
+	}
+
+	var publisher publish.Publisher = &publish.FS{Dir: repoDir}
+	if err := publisher.Publish(ctx, *pkg); err != nil {
+		fmt.Printf("Error publishing package: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Published %s@%s to %s\n", pkg.Manifest.ID, pkg.Manifest.Version, repoDir)
+}
+
+func serveCommand() {
+	addr := ":8080"
+	args := os.Args[2:]
+	if len(args) > 1 && args[0] == "--addr" {
+		addr = args[1]
+		args = args[2:]
+	}
+
+	var remotePath string
+	switch len(args) {
+	case 0:
+		if err := loadConfig(); err != nil {
+			fmt.Printf("No repository given and error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		remotePath = cfg.RemotePath
+	case 1:
+		remotePath = args[0]
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Serving %s on %s\n", remotePath, addr)
+	if err := http.ListenAndServe(addr, serve.New(sourceRepository(remotePath))); err != nil {
+		fmt.Printf("Error serving repository: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func mirrorCommand() {
+	args := os.Args[2:]
+	var options mirror.Options
+	var buildOptions build.Options
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--latest":
+			options.Latest = true
+			args = args[1:]
+		case "--verify":
+			options.Verify = true
+			args = args[1:]
+		default:
+			var ok bool
+			if args, ok = parseBuildOption(args, &buildOptions); !ok {
+				fmt.Printf("Unknown option: %s\n", args[0])
+				usage()
+				os.Exit(1)
+			}
+		}
+	}
+
+	if len(args) < 2 {
+		fmt.Println("Missing source and destination repositories")
+		usage()
+		os.Exit(1)
+	}
+
+	src, dst := args[0], args[1]
+	for _, arg := range args[2:] {
+		// versions may be selected with <id>@<version>
+		id, version, _ := strings.Cut(arg, "@")
+		if options.Paks == nil {
+			options.Paks = make(map[string][]string)
+		}
+		if _, ok := options.Paks[id]; !ok {
+			options.Paks[id] = nil
+		}
+		if version != "" {
+			options.Paks[id] = append(options.Paks[id], version)
+		}
+	}
+
+	// keep the layout of an existing repository, unless overridden
+	detected, err := build.Detect(ctx, dst)
+	if err != nil {
+		fmt.Printf("Error reading destination repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	if buildOptions.Sharded {
+		detected.Sharded = true
+	}
+	if buildOptions.Codec != nil {
+		detected.Codec = buildOptions.Codec
+	}
+	detected.Compress = append(detected.Compress, buildOptions.Compress...)
+	if buildOptions.KeepChanges != 0 {
+		detected.KeepChanges = buildOptions.KeepChanges
+	}
+
+	options.Build = detected
+	options.Logger = logger{}
+
+	result, err := mirror.Mirror(ctx, sourceRepository(src), dst, options)
+	if result != nil {
+		fmt.Printf("Mirrored %d versions (%d files, %d bytes), %d already mirrored\n", result.Versions, result.Files, result.Bytes, result.Skipped)
+	}
+	if err != nil {
+		fmt.Printf("Error mirroring repository: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -2,4 +2,6 @@ module github.com/WithoutPants/pakman
 
 go 1.19
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/klauspost/compress v1.17.6
@@ -0,0 +1,108 @@
+// Package executor provides a restricted pak.Executor that runs commands as child processes.
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+// DefaultMaxOutput is the default maximum number of bytes of output captured from a command.
+const DefaultMaxOutput = 1 << 20
+
+// Restricted runs pak commands as child processes.
+// Commands are not run through a shell, are run with a minimal environment and
+// are killed if they exceed their timeout.
+type Restricted struct {
+	// Env is the environment of the command, in the form "key=value".
+	// If nil, only PATH is passed from the current environment.
+	Env []string
+
+	// MaxOutput is the maximum number of bytes of output captured from a command.
+	// Output beyond this limit is discarded. If zero, DefaultMaxOutput is used.
+	MaxOutput int
+}
+
+// Execute runs the command in the requested directory, returning its captured output.
+func (r *Restricted) Execute(ctx context.Context, req pak.ExecRequest) (*pak.ExecResult, error) {
+	if len(req.Args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	maxOutput := r.MaxOutput
+	if maxOutput == 0 {
+		maxOutput = DefaultMaxOutput
+	}
+
+	out := &limitedBuffer{limit: maxOutput}
+
+	cmd := exec.CommandContext(ctx, req.Args[0], req.Args[1:]...)
+	cmd.Dir = req.Dir
+	cmd.Env = r.env()
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+
+	result := &pak.ExecResult{
+		Output:   out.Bytes(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+	}
+
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("command did not complete: %w", ctx.Err())
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (r *Restricted) env() []string {
+	if r.Env != nil {
+		return r.Env
+	}
+
+	return []string{"PATH=" + os.Getenv("PATH")}
+}
+
+// limitedBuffer is a goroutine-safe buffer that discards writes beyond its limit.
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if remaining := b.limit - b.buf.Len(); remaining < len(p) {
+		p = p[:remaining]
+	}
+
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Bytes()
+}
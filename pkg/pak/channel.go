@@ -0,0 +1,28 @@
+package pak
+
+// ChannelVersion returns the version that the given release channel points to.
+// If the channel is empty or is not in Channels, or if CurrentVersion is newer
+// than the channel version, CurrentVersion is returned.
+func (s Spec) ChannelVersion(channel string) string {
+	v, ok := s.Channels[channel]
+	if !ok || CompareVersions(v, s.CurrentVersion) < 0 {
+		return s.CurrentVersion
+	}
+
+	return v
+}
+
+// channel returns the release channel to install the pak from. This is the
+// channel in the install spec if set, otherwise the channel followed by the
+// existing installed pak, otherwise the default channel.
+func (m *Manager) channel(toInstall InstallSpec, existing *Manifest) string {
+	if toInstall.Channel != "" {
+		return toInstall.Channel
+	}
+
+	if existing != nil && existing.Channel != "" {
+		return existing.Channel
+	}
+
+	return m.defaultChannel
+}
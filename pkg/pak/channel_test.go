@@ -0,0 +1,113 @@
+package pak_test
+
+import (
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestChannelVersion(t *testing.T) {
+	spec := pak.Spec{
+		CurrentVersion: "1.1.0",
+		Channels:       map[string]string{"beta": "1.2.0-beta", "old": "1.0.0"},
+	}
+
+	tests := map[string]string{
+		"":        "1.1.0",
+		"beta":    "1.2.0-beta",
+		"missing": "1.1.0",
+		// channels that are behind the current version follow it
+		"old": "1.1.0",
+	}
+
+	for channel, want := range tests {
+		if got := spec.ChannelVersion(channel); got != want {
+			t.Errorf("ChannelVersion(%q) = %q, want %q", channel, got, want)
+		}
+	}
+}
+
+// addChannels adds versions 1.0.0, 1.1.0-beta and 1.1.0-beta.2 of the pak,
+// with the beta channel pointing at the given version.
+func (e *testEnv) addChannels(id string, beta string) {
+	e.t.Helper()
+
+	for _, v := range []string{"1.0.0", "1.1.0-beta", "1.1.0-beta.2"} {
+		e.addFiles(id, v, map[string]string{"plugin.txt": v})
+	}
+
+	spec := e.remote.Index[id]
+	spec.CurrentVersion = "1.0.0"
+	spec.Channels = map[string]string{"beta": beta}
+	e.remote.Index[id] = spec
+}
+
+func TestInstallChannel(t *testing.T) {
+	e := newTestEnv(t)
+	e.addChannels("a", "1.1.0-beta")
+	e.addChannels("b", "1.1.0-beta")
+
+	m := e.manager(pak.ManagerOptions{})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Channel: "beta"}, pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.1.0-beta", "b": "1.0.0"})
+
+	if got := e.installed("a").Channel; got != "beta" {
+		t.Errorf("a channel = %q, want beta", got)
+	}
+	if got := e.installed("b").Channel; got != "" {
+		t.Errorf("b channel = %q, want the default channel", got)
+	}
+
+	// upgrades follow the installed channel
+	for _, id := range []string{"a", "b"} {
+		spec := e.remote.Index[id]
+		spec.Channels["beta"] = "1.1.0-beta.2"
+		e.remote.Index[id] = spec
+	}
+
+	upgradable, err := m.Upgradable(e.ctx)
+	if err != nil {
+		t.Fatalf("Upgradable() error = %v", err)
+	}
+	if len(upgradable) != 1 || upgradable[0].ID != "a" || upgradable[0].LatestVersion != "1.1.0-beta.2" || upgradable[0].Channel != "beta" {
+		t.Errorf("Upgradable() = %+v, want a upgradable to 1.1.0-beta.2 in the beta channel", upgradable)
+	}
+
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.1.0-beta.2", "b": "1.0.0"})
+	if got := e.installed("a").Channel; got != "beta" {
+		t.Errorf("a channel after upgrade = %q, want beta", got)
+	}
+
+	// switching to the channel of the installed version only records the channel
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "b", Version: "1.0.0", Channel: "stable"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.1.0-beta.2", "b": "1.0.0"})
+	if got := e.installed("b").Channel; got != "stable" {
+		t.Errorf("b channel = %q, want stable", got)
+	}
+}
+
+func TestDefaultChannel(t *testing.T) {
+	e := newTestEnv(t)
+	e.addChannels("a", "1.1.0-beta")
+	e.addFiles("b", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+
+	m := e.manager(pak.ManagerOptions{DefaultChannel: "beta"})
+
+	// paks without the channel install their current version
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}, pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.1.0-beta", "b": "1.0.0"})
+
+	if got := e.installed("a").Channel; got != "beta" {
+		t.Errorf("a channel = %q, want the default channel", got)
+	}
+}
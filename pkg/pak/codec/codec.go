@@ -0,0 +1,123 @@
+// Package codec provides selection of the encoding used to read and write pakman files.
+package codec
+
+import (
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/json"
+	"github.com/WithoutPants/pakman/pkg/pak/yaml"
+)
+
+// Codec reads and writes pakman files in a particular encoding.
+type Codec interface {
+	// Extensions returns the file extensions of the encoding, including the leading dot.
+	// The first is the preferred extension.
+	Extensions() []string
+	// ContentTypes returns the media types of the encoding. The first is the preferred media type.
+	ContentTypes() []string
+
+	ReadSpec(r io.Reader) (*pak.Spec, error)
+	WriteSpec(w io.Writer, spec pak.Spec) error
+	ReadManifest(r io.Reader) (*pak.Manifest, error)
+	WriteManifest(w io.Writer, manifest pak.Manifest) error
+	ReadSpecIndex(r io.Reader) (*pak.SpecIndex, error)
+	WriteSpecIndex(w io.Writer, index pak.SpecIndex) error
+	ReadIndex(r io.Reader) (*pak.Index, error)
+	WriteIndex(w io.Writer, index pak.Index) error
+	ReadIndexDelta(r io.Reader) (*pak.IndexDelta, error)
+	WriteIndexDelta(w io.Writer, delta pak.IndexDelta) error
+}
+
+var (
+	YAML Codec = yaml.Codec{}
+	JSON Codec = json.Codec{}
+)
+
+var (
+	mutex  sync.RWMutex
+	codecs = []Codec{YAML, JSON}
+)
+
+// Register adds a codec to the list of registered codecs.
+// Registered codecs are tried in the order they were registered, after the
+// yaml and json codecs.
+func Register(c Codec) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	codecs = append(codecs, c)
+}
+
+// All returns the registered codecs. The yaml codec is first.
+func All() []Codec {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return append([]Codec{}, codecs...)
+}
+
+// Extension returns the preferred file extension of the codec.
+func Extension(c Codec) string {
+	return c.Extensions()[0]
+}
+
+// ContentType returns the preferred media type of the codec.
+func ContentType(c Codec) string {
+	return c.ContentTypes()[0]
+}
+
+// ForPath returns the codec for the extension of the given file name or path,
+// or nil if the extension is not recognised.
+func ForPath(name string) Codec {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return nil
+	}
+
+	for _, c := range All() {
+		for _, e := range c.Extensions() {
+			if e == ext {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// ForContentType returns the codec for the given Content-Type header value,
+// or nil if the media type is not recognised.
+func ForContentType(contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	for _, c := range All() {
+		for _, ct := range c.ContentTypes() {
+			if ct == mediaType {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// Names returns the given base name with each extension of the registered codecs appended.
+// For example, Names("index") returns "index.yml", "index.yaml" and "index.json".
+func Names(base string) []string {
+	var ret []string
+	for _, c := range All() {
+		for _, e := range c.Extensions() {
+			ret = append(ret, base+e)
+		}
+	}
+
+	return ret
+}
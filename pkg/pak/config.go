@@ -0,0 +1,218 @@
+package pak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// NewConfigSuffix is appended to the path of a config file to write the new
+// version of the file, when the installed file has been modified.
+const NewConfigSuffix = ".new"
+
+// ConfigConflict describes a config file that was modified locally, and was
+// changed or removed by a new version of the pak. The modified file is kept,
+// and the new version of the file is written to NewPath.
+//
+// If the new version of the pak no longer installs the file, NewPath is
+// empty. The modified file is kept, but is no longer part of the pak.
+type ConfigConflict struct {
+	ID      string
+	Version string
+	// Path is the path of the locally modified config file.
+	Path string
+	// NewPath is the path that the new version of the file was written to.
+	NewPath string
+}
+
+// modifiedConfigFiles returns the paths of the installed config files of the
+// pak that have been modified since they were installed.
+// Files are only detected as modified if their digest was recorded when installed.
+func (m *Manager) modifiedConfigFiles(ctx context.Context, existing *Manifest) ([]string, error) {
+	getter, ok := m.local.(InstalledFileGetter)
+	if !ok {
+		return nil, nil
+	}
+
+	installed := make(map[string]struct{})
+	for _, path := range existing.LocalFiles() {
+		installed[path] = struct{}{}
+	}
+
+	var ret []string
+	for _, file := range existing.Files {
+		if _, ok := installed[file.Path]; !ok || !file.Config || file.Digest == "" {
+			continue
+		}
+
+		status, err := m.checkInstalledFile(ctx, getter, existing.ID, file)
+		if err != nil {
+			return nil, err
+		}
+
+		if status == fileModified {
+			ret = append(ret, file.Path)
+		}
+	}
+
+	return ret, nil
+}
+
+// keepConfigFiles removes the modified config files from the installed files
+// of the existing manifest, so that they are left on disk when the existing
+// version is uninstalled. This ensures that they are not lost if the upgrade
+// fails. If the pak is disabled, it is enabled first, so that the files are
+// kept in the directory that the new version is installed to.
+// It returns a function that restores the existing manifest, in case the
+// existing version cannot be uninstalled.
+func (m *Manager) keepConfigFiles(ctx context.Context, existing *Manifest, modified []string) (func(), error) {
+	if len(modified) == 0 {
+		return func() {}, nil
+	}
+
+	if existing.Disabled {
+		if err := m.setEnabled(ctx, existing.ID, true); err != nil {
+			return nil, err
+		}
+	}
+
+	keep := make(map[string]struct{}, len(modified))
+	for _, path := range modified {
+		keep[path] = struct{}{}
+	}
+
+	installed := []string{}
+	for _, path := range existing.LocalFiles() {
+		if _, ok := keep[path]; !ok {
+			installed = append(installed, path)
+		}
+	}
+
+	kept := *existing
+	kept.InstalledFiles = installed
+
+	if err := m.local.WriteManifest(ctx, kept); err != nil {
+		return nil, fmt.Errorf("writing local pak manifest: %w", err)
+	}
+
+	return func() {
+		if err := m.local.WriteManifest(ctx, *existing); err != nil {
+			m.logger.Infof("Error restoring manifest of %s: %v", existing.ID, err)
+		}
+		if existing.Disabled {
+			if err := m.setEnabled(ctx, existing.ID, false); err != nil {
+				m.logger.Infof("Error disabling %s: %v", existing.ID, err)
+			}
+		}
+	}, nil
+}
+
+// localConfigFile returns the contents of the config file in the local
+// repository, or nil if it does not exist or cannot be read.
+func (m *Manager) localConfigFile(ctx context.Context, id string, path string) ([]byte, error) {
+	getter, ok := m.local.(InstalledFileGetter)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := readInstalledFile(ctx, getter, id, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// keptConfigConflict reports a modified config file that is kept, but is not
+// installed by the new version of the pak.
+func (m *Manager) keptConfigConflict(manifest *Manifest, path string) {
+	m.logger.Infof("Config file %s of %s was modified and is no longer part of the pak: keeping modified file", path, manifest.ID)
+	if m.onConfigConflict != nil {
+		m.onConfigConflict(ConfigConflict{
+			ID:      manifest.ID,
+			Version: manifest.Version,
+			Path:    path,
+		})
+	}
+}
+
+func readInstalledFile(ctx context.Context, getter InstalledFileGetter, id string, path string) ([]byte, error) {
+	rc, err := getter.GetInstalledFile(ctx, id, path)
+	if err != nil {
+		return nil, fmt.Errorf("opening installed file %q: %w", path, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("reading installed file %q: %w", path, err)
+	}
+
+	return data, nil
+}
+
+// installConfigFile installs a config file that was modified locally.
+// The locally modified contents are kept. If the new version of the file differs
+// from the originally installed version, it is written alongside with NewConfigSuffix
+// appended to the path, and the conflict is reported.
+// It returns the file with the size and digest of the new version set, and
+// marked as modified if the kept contents differ from them, and the paths
+// that were written.
+func (m *Manager) installConfigFile(ctx context.Context, manifest *Manifest, file File, previous File, modified []byte) (File, []string, error) {
+	rc, err := m.remote.GetFile(ctx, manifest.ID, manifest.Version, file.Path)
+	if err != nil {
+		return file, nil, fmt.Errorf("getting remote pak file: %w", err)
+	}
+	defer rc.Close()
+
+	upstream, err := io.ReadAll(rc)
+	if err != nil {
+		return file, nil, fmt.Errorf("reading remote pak file: %w", err)
+	}
+
+	digest, size, _ := ComputeDigest(bytes.NewReader(upstream))
+	if err := file.Check(digest, size); err != nil {
+		return file, nil, err
+	}
+
+	file.Size = size
+	file.Digest = digest
+
+	paths := []string{file.Path}
+
+	// there is no conflict if the file is unchanged upstream, or was changed
+	// locally to match the new version
+	modifiedDigest, _, _ := ComputeDigest(bytes.NewReader(modified))
+	file.Modified = digest != modifiedDigest
+
+	if digest != previous.Digest && digest != modifiedDigest {
+		newPath := file.Path + NewConfigSuffix
+		if err := m.local.Write(ctx, manifest.ID, manifest.Version, newPath, bytes.NewReader(upstream)); err != nil {
+			return file, nil, fmt.Errorf("writing local pak file: %w", err)
+		}
+
+		paths = append(paths, newPath)
+
+		conflict := ConfigConflict{
+			ID:      manifest.ID,
+			Version: manifest.Version,
+			Path:    file.Path,
+			NewPath: newPath,
+		}
+
+		m.logger.Infof("Config file %s of %s was modified: keeping modified file and writing new version to %s", file.Path, manifest.ID, newPath)
+		if m.onConfigConflict != nil {
+			m.onConfigConflict(conflict)
+		}
+	}
+
+	// restore the modified file
+	if err := m.local.Write(ctx, manifest.ID, manifest.Version, file.Path, bytes.NewReader(modified)); err != nil {
+		return file, nil, fmt.Errorf("writing local pak file: %w", err)
+	}
+
+	return file, paths, nil
+}
@@ -0,0 +1,177 @@
+package pak_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+// addConfig adds a version of the pak with the given config files, keyed by path.
+func (e *testEnv) addConfig(id string, version string, files map[string]string) {
+	e.t.Helper()
+
+	manifest := pak.Manifest{ID: id, Version: version}
+	for _, path := range []string{"config.yml", "other.yml"} {
+		if _, ok := files[path]; ok {
+			manifest.Files = append(manifest.Files, pak.File{Path: path, Config: true})
+		}
+	}
+
+	e.add(manifest, files)
+}
+
+// installedFile returns the file of the installed manifest of the pak.
+func (e *testEnv) installedFile(id string, path string) pak.File {
+	e.t.Helper()
+
+	for _, f := range e.installed(id).Files {
+		if f.Path == path {
+			return f
+		}
+	}
+
+	e.t.Fatalf("%s is not in the installed manifest of %s", path, id)
+	return pak.File{}
+}
+
+func TestConfigConflict(t *testing.T) {
+	e := newTestEnv(t)
+	e.addConfig("a", "1.0.0", map[string]string{"config.yml": "v1", "other.yml": "other v1"})
+	e.addConfig("a", "2.0.0", map[string]string{"config.yml": "v2", "other.yml": "other v2"})
+	e.addConfig("a", "3.0.0", map[string]string{"config.yml": "v2"})
+
+	var conflicts []pak.ConfigConflict
+	m := e.manager(pak.ManagerOptions{
+		OnConfigConflict: func(c pak.ConfigConflict) {
+			conflicts = append(conflicts, c)
+		},
+	})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	e.write("a", "config.yml", "edited")
+	e.write("a", "other.yml", "other edited")
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if got := e.read("a", "config.yml"); got != "edited" {
+		t.Errorf("config.yml = %q, want the edited file", got)
+	}
+	if got := e.read("a", "config.yml"+pak.NewConfigSuffix); got != "v2" {
+		t.Errorf("new config.yml = %q, want the new version", got)
+	}
+
+	want := []pak.ConfigConflict{
+		{ID: "a", Version: "2.0.0", Path: "config.yml", NewPath: "config.yml" + pak.NewConfigSuffix},
+		{ID: "a", Version: "2.0.0", Path: "other.yml", NewPath: "other.yml" + pak.NewConfigSuffix},
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts = %+v, want %+v", conflicts, want)
+	}
+
+	// the digest of the new version is recorded, and the file is marked as modified
+	file := e.installedFile("a", "config.yml")
+	digest, _, _ := pak.ComputeDigest(strings.NewReader("v2"))
+	if file.Digest != digest || !file.Modified {
+		t.Errorf("installed config.yml = %+v, want the digest of the new version and marked as modified", file)
+	}
+
+	// the edited file is still kept when the new version does not change it
+	e.write("a", "other.yml", "other v2")
+	conflicts = nil
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "3.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if got := e.read("a", "config.yml"); got != "edited" {
+		t.Errorf("config.yml = %q, want the edited file", got)
+	}
+
+	// other.yml is no longer in the pak, and matches the previous version, so it is removed
+	if _, err := os.Stat(e.path("a", "other.yml")); !os.IsNotExist(err) {
+		t.Errorf("other.yml stat error = %v, want removed", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("conflicts = %+v, want none", conflicts)
+	}
+}
+
+// TestConfigRemoved checks that a modified config file that the new version
+// does not install is kept, and reported.
+func TestConfigRemoved(t *testing.T) {
+	e := newTestEnv(t)
+	e.addConfig("a", "1.0.0", map[string]string{"config.yml": "v1", "other.yml": "other"})
+	e.addConfig("a", "2.0.0", map[string]string{"config.yml": "v1"})
+
+	var conflicts []pak.ConfigConflict
+	m := e.manager(pak.ManagerOptions{
+		OnConfigConflict: func(c pak.ConfigConflict) {
+			conflicts = append(conflicts, c)
+		},
+	})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	e.write("a", "other.yml", "edited")
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	if got := e.read("a", "other.yml"); got != "edited" {
+		t.Errorf("other.yml = %q, want the edited file", got)
+	}
+	if want := []pak.ConfigConflict{{ID: "a", Version: "2.0.0", Path: "other.yml"}}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts = %+v, want %+v", conflicts, want)
+	}
+
+	// the unchanged config file is not marked as modified
+	if file := e.installedFile("a", "config.yml"); file.Modified {
+		t.Errorf("installed config.yml = %+v, want not modified", file)
+	}
+}
+
+// TestConfigNotInstalled checks that config files that exist before a pak is
+// installed are replaced, since they are not the files of an installed version.
+func TestConfigNotInstalled(t *testing.T) {
+	e := newTestEnv(t)
+	e.local.SharedRoot = true
+	e.addConfig("a", "1.0.0", map[string]string{"config.yml": "v1"})
+	e.addConfig("a", "2.0.0", map[string]string{"config.yml": "v2"})
+
+	if err := os.WriteFile(filepath.Join(e.local.BaseDir, "config.yml"), []byte("leftover"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if got := e.read("a", "config.yml"); got != "v1" {
+		t.Errorf("config.yml = %q, want the installed version", got)
+	}
+	if got := e.read("a", "config.yml"+pak.NewConfigSuffix); got != "" {
+		t.Errorf("new config.yml = %q, want not written", got)
+	}
+	if file := e.installedFile("a", "config.yml"); file.Modified {
+		t.Errorf("installed config.yml = %+v, want not modified", file)
+	}
+
+	// the unmodified file is replaced by an upgrade
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	if got := e.read("a", "config.yml"); got != "v2" {
+		t.Errorf("config.yml = %q, want the new version", got)
+	}
+}
@@ -0,0 +1,396 @@
+package pak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dependency is a pak required by another pak.
+// A dependency that is only an ID may be written in a manifest as a plain string.
+type Dependency struct {
+	ID string `yaml:"id" json:"id"`
+	// Version is a version constraint that the required pak must satisfy.
+	// See MatchesConstraint for the constraint format. If empty, any version is accepted.
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+func (d *Dependency) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var id string
+	if err := unmarshal(&id); err == nil {
+		*d = Dependency{ID: id}
+		return nil
+	}
+
+	// alias the type to avoid recursing into this method
+	type dependency Dependency
+	var dd dependency
+	if err := unmarshal(&dd); err != nil {
+		return err
+	}
+
+	*d = Dependency(dd)
+	return nil
+}
+
+func (d Dependency) MarshalYAML() (interface{}, error) {
+	if d.Version == "" {
+		return d.ID, nil
+	}
+
+	type dependency Dependency
+	return dependency(d), nil
+}
+
+func (d *Dependency) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*d = Dependency{ID: id}
+		return nil
+	}
+
+	type dependency Dependency
+	var dd dependency
+	if err := json.Unmarshal(data, &dd); err != nil {
+		return err
+	}
+
+	*d = Dependency(dd)
+	return nil
+}
+
+func (d Dependency) MarshalJSON() ([]byte, error) {
+	if d.Version == "" {
+		return json.Marshal(d.ID)
+	}
+
+	type dependency Dependency
+	return json.Marshal(dependency(d))
+}
+
+// ConstraintError is returned when a version does not satisfy a required version constraint.
+type ConstraintError struct {
+	ID         string
+	Version    string
+	Constraint string
+	// RequiredBy is the ID of the installed pak with the constraint, if any.
+	RequiredBy string
+}
+
+func (e ConstraintError) Error() string {
+	if e.RequiredBy != "" {
+		return fmt.Sprintf("%s@%s does not satisfy %s required by %s", e.ID, e.Version, e.Constraint, e.RequiredBy)
+	}
+
+	return fmt.Sprintf("%s@%s does not satisfy %s", e.ID, e.Version, e.Constraint)
+}
+
+func checkConstraint(id string, version string, constraint string) error {
+	ok, err := MatchesConstraint(version, constraint)
+	if err != nil {
+		return fmt.Errorf("checking version constraint for %s: %w", id, err)
+	}
+
+	if !ok {
+		return ConstraintError{ID: id, Version: version, Constraint: constraint}
+	}
+
+	return nil
+}
+
+// requirement is a version constraint that a pak must satisfy.
+type requirement struct {
+	constraint string
+	// requiredBy is the ID of the installed pak with the constraint, or empty
+	// if the constraint is not from an installed pak.
+	requiredBy string
+}
+
+// checkRequirements returns a ConstraintError if the version does not satisfy
+// all of the requirements.
+func checkRequirements(id string, version string, reqs []requirement) error {
+	for _, r := range reqs {
+		err := checkConstraint(id, version, r.constraint)
+
+		var constraintErr ConstraintError
+		if errors.As(err, &constraintErr) {
+			constraintErr.RequiredBy = r.requiredBy
+			return constraintErr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dependentRequirements returns the version constraints that the installed
+// paks place on the pak with the given ID. Paks in skip are ignored, since
+// their requirements are replaced when they are installed.
+func dependentRequirements(installed []Manifest, id string, skip map[string]struct{}) []requirement {
+	var ret []requirement
+	for _, p := range installed {
+		if _, found := skip[p.ID]; found || p.ID == id {
+			continue
+		}
+
+		for _, dep := range p.Requires {
+			if dep.ID == id && dep.Version != "" {
+				ret = append(ret, requirement{constraint: dep.Version, requiredBy: p.ID})
+			}
+		}
+	}
+
+	return ret
+}
+
+// requirements returns the version constraints that the pak being installed
+// must satisfy: the constraint in the options and the constraints of the
+// installed paks that require it.
+func (m *Manager) requirements(ctx context.Context, id string, opts installOptions) ([]requirement, error) {
+	installed, err := m.local.ListInstalled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing local paks: %w", err)
+	}
+
+	var ret []requirement
+	if opts.constraint != "" {
+		ret = append(ret, requirement{constraint: opts.constraint})
+	}
+
+	return append(ret, dependentRequirements(installed, id, opts.installing)...), nil
+}
+
+// DependentsError is returned when uninstalling a pak that other installed paks depend on.
+type DependentsError struct {
+	ID         string
+	Dependents []string
+}
+
+func (e DependentsError) Error() string {
+	return fmt.Sprintf("pak %s is required by: %s", e.ID, strings.Join(e.Dependents, ", "))
+}
+
+// installDependencies installs the paks required by the manifest that are not
+// installed, or are installed with a version that does not satisfy the requirement.
+// It returns the IDs of the paks that were not installed before, including
+// those required by the dependencies, so that they can be removed if the pak
+// fails to install. They are returned even if an error is returned.
+func (m *Manager) installDependencies(ctx context.Context, manifest *Manifest, opts installOptions) ([]string, error) {
+	if len(manifest.Requires) == 0 {
+		return nil, nil
+	}
+
+	before, err := m.local.ListInstalled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing local paks: %w", err)
+	}
+
+	err = m.installRequired(ctx, manifest, opts)
+
+	after, listErr := m.local.ListInstalled(ctx)
+	if listErr != nil {
+		if err == nil {
+			err = fmt.Errorf("listing local paks: %w", listErr)
+		}
+		return nil, err
+	}
+
+	wasInstalled := make(map[string]struct{}, len(before))
+	for _, p := range before {
+		wasInstalled[p.ID] = struct{}{}
+	}
+
+	var added []string
+	for _, p := range after {
+		if _, found := wasInstalled[p.ID]; !found && p.ID != manifest.ID {
+			added = append(added, p.ID)
+		}
+	}
+
+	return added, err
+}
+
+// installRequired installs the paks required by the manifest, as described by installDependencies.
+func (m *Manager) installRequired(ctx context.Context, manifest *Manifest, opts installOptions) error {
+
+	installing := make(map[string]struct{}, len(opts.installing)+1)
+	for id := range opts.installing {
+		installing[id] = struct{}{}
+	}
+	installing[manifest.ID] = struct{}{}
+
+	if reasonFromContext(ctx) == "" {
+		ctx = WithReason(ctx, fmt.Sprintf("required by %s@%s", manifest.ID, manifest.Version))
+	}
+
+	for _, dep := range manifest.Requires {
+		if _, found := installing[dep.ID]; found {
+			// cyclic dependency - already being installed
+			continue
+		}
+
+		existing, err := m.local.GetInstalledManifest(ctx, dep.ID)
+		if err != nil {
+			return fmt.Errorf("getting local pak manifest: %w", err)
+		}
+
+		if existing != nil {
+			if err := checkConstraint(dep.ID, existing.Version, dep.Version); err == nil {
+				continue
+			}
+		}
+
+		m.logger.Infof("Installing %s required by %s", dep.ID, manifest.ID)
+		if err := m.install(ctx, InstallSpec{ID: dep.ID}, installOptions{
+			dependency: true,
+			constraint: dep.Version,
+			installing: installing,
+		}); err != nil {
+			return fmt.Errorf("installing required pak %s: %w", dep.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// removeDependencies uninstalls the paks that were installed as dependencies
+// of the pak with the given id, when it failed to install. Errors are logged
+// rather than returned, so that they do not mask the installation error.
+func (m *Manager) removeDependencies(ctx context.Context, id string, ids []string) {
+	for _, dep := range ids {
+		m.logger.Infof("Removing %s installed for %s", dep, id)
+		if err := m.removePak(ctx, dep); err != nil {
+			m.logger.Infof("Warning: failed to remove %s: %v", dep, err)
+		}
+	}
+}
+
+// markExplicit marks an installed pak as explicitly installed if required.
+func (m *Manager) markExplicit(ctx context.Context, existing *Manifest, opts installOptions) error {
+	if !opts.explicit || !existing.AutoInstalled {
+		return nil
+	}
+
+	existing.AutoInstalled = false
+	if err := m.local.WriteManifest(ctx, *existing); err != nil {
+		return fmt.Errorf("writing local pak manifest: %w", err)
+	}
+
+	return nil
+}
+
+// dependents returns a map of pak ID to the sorted IDs of the installed paks that require it.
+func dependents(installed []Manifest) map[string][]string {
+	ret := make(map[string][]string)
+	for _, p := range installed {
+		for _, dep := range p.Requires {
+			ret[dep.ID] = append(ret[dep.ID], p.ID)
+		}
+	}
+
+	for _, v := range ret {
+		sort.Strings(v)
+	}
+
+	return ret
+}
+
+// checkDependents returns a DependentsError if any of the given paks are
+// required by installed paks that are not also being removed.
+func (m *Manager) checkDependents(ctx context.Context, ids []string) error {
+	installed, err := m.local.ListInstalled(ctx)
+	if err != nil {
+		return fmt.Errorf("listing local paks: %w", err)
+	}
+
+	removing := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		removing[id] = struct{}{}
+	}
+
+	deps := dependents(installed)
+	for _, id := range ids {
+		var remaining []string
+		for _, d := range deps[id] {
+			if _, found := removing[d]; !found {
+				remaining = append(remaining, d)
+			}
+		}
+
+		if len(remaining) > 0 {
+			return DependentsError{ID: id, Dependents: remaining}
+		}
+	}
+
+	return nil
+}
+
+// unneeded returns the IDs of the auto-installed paks that are not required,
+// directly or through other paks, by an explicitly installed pak. Auto-installed
+// paks that only require each other are unneeded.
+func unneeded(installed []Manifest) []string {
+	byID := make(map[string]Manifest, len(installed))
+	for _, p := range installed {
+		byID[p.ID] = p
+	}
+
+	needed := make(map[string]struct{})
+	var queue []string
+	for _, p := range installed {
+		if !p.AutoInstalled {
+			needed[p.ID] = struct{}{}
+			queue = append(queue, p.ID)
+		}
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range byID[id].Requires {
+			if _, found := needed[dep.ID]; found {
+				continue
+			}
+
+			needed[dep.ID] = struct{}{}
+			queue = append(queue, dep.ID)
+		}
+	}
+
+	var ret []string
+	for _, p := range installed {
+		if _, found := needed[p.ID]; !found {
+			ret = append(ret, p.ID)
+		}
+	}
+
+	return ret
+}
+
+// Autoremove uninstalls paks that were installed as dependencies and are no
+// longer required by any explicitly installed pak, including paks that only
+// require each other. It returns the IDs of the removed paks.
+func (m *Manager) Autoremove(ctx context.Context) ([]string, error) {
+	installed, err := m.local.ListInstalled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing local paks: %w", err)
+	}
+
+	var removed []string
+	for _, id := range unneeded(installed) {
+		m.logger.Infof("Removing unneeded pak %s", id)
+		if err := m.removePak(ctx, id); err != nil {
+			return removed, fmt.Errorf("uninstalling pak %s: %w", id, err)
+		}
+
+		removed = append(removed, id)
+	}
+
+	return removed, nil
+}
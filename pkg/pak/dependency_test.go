@@ -0,0 +1,147 @@
+package pak_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repository/memory"
+)
+
+// addRequiring adds a version of the pak with a single file, requiring the given paks.
+func (e *testEnv) addRequiring(id string, version string, requires ...pak.Dependency) {
+	e.t.Helper()
+
+	e.add(pak.Manifest{
+		ID:       id,
+		Version:  version,
+		Requires: requires,
+		Files:    []pak.File{{Path: "plugin.txt"}},
+	}, map[string]string{"plugin.txt": id + " " + version})
+}
+
+func TestInstallDependencies(t *testing.T) {
+	e := newTestEnv(t)
+	e.addRequiring("a", "1.0.0", pak.Dependency{ID: "b", Version: "<2"}, pak.Dependency{ID: "c"})
+	e.addRequiring("b", "1.0.0")
+	e.addRequiring("b", "1.5.0", pak.Dependency{ID: "d"})
+	e.addRequiring("b", "2.0.0")
+	e.addRequiring("c", "1.0.0", pak.Dependency{ID: "a"})
+	e.addRequiring("d", "1.0.0")
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	// the newest version satisfying the constraint is installed, with its
+	// dependencies, and the cycle between a and c is not followed
+	e.checkVersions(map[string]string{"a": "1.0.0", "b": "1.5.0", "c": "1.0.0", "d": "1.0.0"})
+
+	for id, auto := range map[string]bool{"a": false, "b": true, "c": true, "d": true} {
+		if got := e.installed(id).AutoInstalled; got != auto {
+			t.Errorf("%s auto installed = %v, want %v", id, got, auto)
+		}
+	}
+
+	// the installed paks constrain the version of their dependencies
+	err := m.Install(e.ctx, pak.InstallSpec{ID: "b", Version: "2.0.0"})
+	var constraintErr pak.ConstraintError
+	if !errors.As(err, &constraintErr) || constraintErr.RequiredBy != "a" {
+		t.Errorf("Install(b@2.0.0) error = %v, want ConstraintError required by a", err)
+	}
+
+	if err := m.Uninstall(e.ctx, "b"); err == nil {
+		t.Errorf("Uninstall(b) succeeded, want a DependentsError")
+	}
+
+	// installing a dependency explicitly keeps it when it is no longer required
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "d"}); err != nil {
+		t.Fatalf("Install(d) error = %v", err)
+	}
+	if e.installed("d").AutoInstalled {
+		t.Errorf("d is auto installed after installing it explicitly")
+	}
+
+	// a is required by c
+	if err := m.Uninstall(e.ctx, "a"); err == nil {
+		t.Errorf("Uninstall(a) succeeded, want a DependentsError")
+	}
+	if err := m.ForceUninstall(e.ctx, "a"); err != nil {
+		t.Fatalf("ForceUninstall(a) error = %v", err)
+	}
+
+	// b and c are no longer required by an explicitly installed pak
+	removed, err := m.Autoremove(e.ctx)
+	if err != nil {
+		t.Fatalf("Autoremove() error = %v", err)
+	}
+
+	sort.Strings(removed)
+	if want := []string{"b", "c"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Autoremove() = %v, want %v", removed, want)
+	}
+	e.checkVersions(map[string]string{"d": "1.0.0"})
+}
+
+// TestAutoremoveCycle checks that auto-installed paks that only require each other are removed.
+func TestAutoremoveCycle(t *testing.T) {
+	e := newTestEnv(t)
+	e.addRequiring("a", "1.0.0", pak.Dependency{ID: "b"})
+	e.addRequiring("b", "1.0.0", pak.Dependency{ID: "c"})
+	e.addRequiring("c", "1.0.0", pak.Dependency{ID: "b"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if removed, err := m.Autoremove(e.ctx); err != nil || len(removed) != 0 {
+		t.Errorf("Autoremove() = %v, %v, want nothing removed while a is installed", removed, err)
+	}
+
+	if err := m.ForceUninstall(e.ctx, "a"); err != nil {
+		t.Fatalf("ForceUninstall() error = %v", err)
+	}
+
+	removed, err := m.Autoremove(e.ctx)
+	if err != nil {
+		t.Fatalf("Autoremove() error = %v", err)
+	}
+
+	sort.Strings(removed)
+	if want := []string{"b", "c"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Autoremove() = %v, want %v", removed, want)
+	}
+	e.checkVersions(map[string]string{})
+}
+
+// TestInstallDependenciesFailure checks that the dependencies installed for a
+// pak are removed if the pak fails to install, and that dependencies that were
+// already installed are kept.
+func TestInstallDependenciesFailure(t *testing.T) {
+	e := newTestEnv(t)
+	e.addRequiring("a", "1.0.0", pak.Dependency{ID: "b"}, pak.Dependency{ID: "d"})
+	e.addRequiring("b", "1.0.0", pak.Dependency{ID: "c"})
+	e.addRequiring("c", "1.0.0")
+	e.addRequiring("d", "1.0.0")
+
+	// the file of a does not match its manifest
+	e.remote.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: "a", Version: "1.0.0"}, File: "plugin.txt"}] = []byte("corrupt")
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "d"}); err != nil {
+		t.Fatalf("Install(d) error = %v", err)
+	}
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err == nil {
+		t.Fatalf("Install(a) succeeded with a corrupt file")
+	}
+
+	e.checkVersions(map[string]string{"d": "1.0.0"})
+	if !e.logger.logged("Removing b installed for a") {
+		t.Errorf("removal of the dependencies of a was not logged")
+	}
+}
@@ -0,0 +1,120 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultCommandTimeout is the timeout used for commands that do not declare one.
+const DefaultCommandTimeout = time.Minute
+
+// ExecRequest is a request to run a single pak command.
+type ExecRequest struct {
+	// ID is the ID of the pak that declared the command.
+	ID string
+	// Dir is the working directory of the command. It is set to the pak directory.
+	Dir string
+	// Args is the program followed by its arguments.
+	Args []string
+	// Timeout is the maximum duration of the command.
+	Timeout time.Duration
+}
+
+// ExecResult is the result of running a pak command.
+type ExecResult struct {
+	// Output is the combined standard output and standard error of the command.
+	Output []byte
+	// ExitCode is the exit code of the command.
+	ExitCode int
+}
+
+// Executor runs commands declared by paks.
+type Executor interface {
+	// Execute runs the command. It returns an error if the command could not be run,
+	// did not complete within the timeout or exited with a non-zero exit code.
+	Execute(ctx context.Context, req ExecRequest) (*ExecResult, error)
+}
+
+// CommandError is returned when a pak command fails.
+type CommandError struct {
+	Args   []string
+	Output []byte
+	Err    error
+}
+
+func (e CommandError) Error() string {
+	return fmt.Sprintf("command %q failed: %v", e.Args, e.Err)
+}
+
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (m *Manager) runCommands(ctx context.Context, id string, stage string, commands []Command) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	if !m.allowScripts {
+		m.logger.Infof("Skipping %d %s command(s) for %s: scripts are not enabled", len(commands), stage, id)
+		return nil
+	}
+
+	if m.executor == nil {
+		m.logger.Infof("Warning: skipping %d %s command(s) for %s: scripts are allowed, but no executor is configured", len(commands), stage, id)
+		return nil
+	}
+
+	dir, err := m.installDir(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range commands {
+		if len(cmd.Run) == 0 {
+			continue
+		}
+
+		timeout := cmd.Timeout
+		if timeout == 0 {
+			timeout = DefaultCommandTimeout
+		}
+
+		m.logger.Infof("Running %s command for %s: %v", stage, id, cmd.Run)
+		result, err := m.executor.Execute(ctx, ExecRequest{
+			ID:      id,
+			Dir:     dir,
+			Args:    cmd.Run,
+			Timeout: timeout,
+		})
+
+		if result != nil && len(result.Output) > 0 {
+			m.logger.Debugf("%s", result.Output)
+		}
+
+		if err != nil {
+			ret := CommandError{Args: cmd.Run, Err: err}
+			if result != nil {
+				ret.Output = result.Output
+			}
+			return ret
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) installDir(ctx context.Context, id string) (string, error) {
+	g, ok := m.local.(InstallDirGetter)
+	if !ok {
+		return "", fmt.Errorf("local repository does not support running commands")
+	}
+
+	dir, err := g.InstallDir(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("getting install directory: %w", err)
+	}
+
+	return dir, nil
+}
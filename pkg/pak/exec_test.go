@@ -0,0 +1,120 @@
+package pak_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+// addScripted adds a version of a pak with a post-install command and a
+// pre-uninstall command, named after the version.
+func (e *testEnv) addScripted(id string, version string) {
+	e.t.Helper()
+
+	e.add(pak.Manifest{
+		ID:      id,
+		Version: version,
+		Files:   []pak.File{{Path: "plugin.txt"}},
+		Scripts: &pak.Scripts{
+			PostInstall:  []pak.Command{{Run: []string{"post-install-" + version}}},
+			PreUninstall: []pak.Command{{Run: []string{"pre-uninstall-" + version}}},
+		},
+	}, map[string]string{"plugin.txt": version})
+}
+
+func TestScripts(t *testing.T) {
+	e := newTestEnv(t)
+	e.addScripted("a", "1.0.0")
+	e.addScripted("a", "2.0.0")
+
+	executor := &recordingExecutor{}
+	m := e.manager(pak.ManagerOptions{Executor: executor, AllowScripts: true})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if len(executor.requests) != 1 {
+		t.Fatalf("Install() ran %v, want post-install-1.0.0", executor.commands())
+	}
+
+	req := executor.requests[0]
+	dir, _ := filepath.Abs(e.path("a", ""))
+	if req.ID != "a" || req.Dir != dir || req.Timeout != pak.DefaultCommandTimeout {
+		t.Errorf("Install() ran %+v, want the pak directory %s and the default timeout", req, dir)
+	}
+
+	// the old version is not being uninstalled, so its pre-uninstall command is not run
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	if err := m.Uninstall(e.ctx, "a"); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+
+	want := []string{"post-install-1.0.0", "post-install-2.0.0", "pre-uninstall-2.0.0"}
+	if got := executor.commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands run = %v, want %v", got, want)
+	}
+}
+
+func TestScriptsNotAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor *recordingExecutor
+		allow    bool
+		warning  bool
+	}{
+		{name: "not allowed", executor: &recordingExecutor{}},
+		{name: "no executor", allow: true, warning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			e.addScripted("a", "1.0.0")
+
+			options := pak.ManagerOptions{AllowScripts: tt.allow}
+			if tt.executor != nil {
+				options.Executor = tt.executor
+			}
+			m := e.manager(options)
+
+			if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+				t.Fatalf("Install() error = %v", err)
+			}
+			if err := m.Uninstall(e.ctx, "a"); err != nil {
+				t.Fatalf("Uninstall() error = %v", err)
+			}
+
+			if tt.executor != nil && len(tt.executor.requests) > 0 {
+				t.Errorf("commands run = %v, want none", tt.executor.commands())
+			}
+			if got := e.logger.logged("Warning: skipping"); got != tt.warning {
+				t.Errorf("warning logged = %v, want %v", got, tt.warning)
+			}
+		})
+	}
+}
+
+func TestScriptFailure(t *testing.T) {
+	e := newTestEnv(t)
+	e.addScripted("a", "1.0.0")
+
+	failed := errors.New("exit status 1")
+	m := e.manager(pak.ManagerOptions{Executor: &recordingExecutor{err: failed}, AllowScripts: true})
+
+	err := m.Install(e.ctx, pak.InstallSpec{ID: "a"})
+
+	var cmdErr pak.CommandError
+	if !errors.As(err, &cmdErr) || !errors.Is(err, failed) {
+		t.Fatalf("Install() error = %v, want CommandError", err)
+	}
+	if !reflect.DeepEqual(cmdErr.Args, []string{"post-install-1.0.0"}) {
+		t.Errorf("CommandError.Args = %v, want the post-install command", cmdErr.Args)
+	}
+}
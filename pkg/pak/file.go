@@ -0,0 +1,228 @@
+package pak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// DigestAlgorithm is the algorithm used by ComputeDigest.
+const DigestAlgorithm = "sha256"
+
+// ComputeDigest reads r to the end, returning the digest of its contents in the
+// form sha256:<hex> and the number of bytes read.
+func ComputeDigest(r io.Reader) (digest string, size int64, err error) {
+	d := newDigester()
+	if _, err := io.Copy(d, r); err != nil {
+		return "", d.size, err
+	}
+
+	return d.digest(), d.size, nil
+}
+
+// digester computes the digest and size of the data written to it.
+type digester struct {
+	h    hash.Hash
+	size int64
+}
+
+func newDigester() *digester {
+	return &digester{h: sha256.New()}
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.h.Write(p)
+}
+
+// digest returns the digest of the data written in the form sha256:<hex>.
+func (d *digester) digest() string {
+	return DigestAlgorithm + ":" + hex.EncodeToString(d.h.Sum(nil))
+}
+
+// DigestMismatchError is returned when the contents of a file do not match its manifest entry.
+type DigestMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e DigestMismatchError) Error() string {
+	return fmt.Sprintf("%s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// Check returns a DigestMismatchError if the size or digest do not match the
+// size or digest of the file, where they are known.
+func (f File) Check(digest string, size int64) error {
+	if f.Size != 0 && f.Size != size {
+		return DigestMismatchError{Path: f.Path, Expected: fmt.Sprintf("%d bytes", f.Size), Actual: fmt.Sprintf("%d bytes", size)}
+	}
+
+	if f.Digest != "" && f.Digest != digest {
+		return DigestMismatchError{Path: f.Path, Expected: f.Digest, Actual: digest}
+	}
+
+	return nil
+}
+
+// File is a file in a pak manifest.
+// A file that is only a path may be written in a manifest as a plain string.
+type File struct {
+	// Path is the path of the file, relative to the pak directory.
+	Path string `yaml:"path" json:"path"`
+
+	// Platforms restricts the file to the given platforms. Each platform is
+	// either a GOOS value or a GOOS/GOARCH pair, for example "linux" or
+	// "windows/amd64". If empty, the file is installed on all platforms.
+	Platforms []string `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+
+	// Tags restricts the file to hosts that have selected all of the given tags.
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+
+	// Size is the size of the file in bytes, if known.
+	Size int64 `yaml:"size,omitempty" json:"size,omitempty"`
+	// Digest is the digest of the file contents in the form <algorithm>:<hex>, if known.
+	// See ComputeDigest.
+	Digest string `yaml:"digest,omitempty" json:"digest,omitempty"`
+
+	// Config is true if the file is a configuration file that may be modified by the user.
+	// Locally modified config files are kept when the pak is upgraded.
+	Config bool `yaml:"config,omitempty" json:"config,omitempty"`
+
+	// Modified is true if the file is a config file that was modified locally
+	// and kept when the pak was installed. Size and Digest are those of the
+	// file in the pak, not of the kept file. It is only set in installed manifests.
+	Modified bool `yaml:"modified,omitempty" json:"modified,omitempty"`
+}
+
+// isPlain returns true if the file has no fields other than the path.
+func (f File) isPlain() bool {
+	return len(f.Platforms) == 0 && len(f.Tags) == 0 && f.Size == 0 && f.Digest == "" && !f.Config && !f.Modified
+}
+
+func (f *File) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*f = File{Path: path}
+		return nil
+	}
+
+	// alias the type to avoid recursing into this method
+	type file File
+	var ff file
+	if err := unmarshal(&ff); err != nil {
+		return err
+	}
+
+	*f = File(ff)
+	return nil
+}
+
+func (f File) MarshalYAML() (interface{}, error) {
+	if f.isPlain() {
+		return f.Path, nil
+	}
+
+	type file File
+	return file(f), nil
+}
+
+func (f *File) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = File{Path: path}
+		return nil
+	}
+
+	type file File
+	var ff file
+	if err := json.Unmarshal(data, &ff); err != nil {
+		return err
+	}
+
+	*f = File(ff)
+	return nil
+}
+
+func (f File) MarshalJSON() ([]byte, error) {
+	if f.isPlain() {
+		return json.Marshal(f.Path)
+	}
+
+	type file File
+	return json.Marshal(file(f))
+}
+
+// DefaultPlatform returns the platform of the running program, in the form GOOS/GOARCH.
+func DefaultPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// FileSelector selects the files of a pak to install.
+type FileSelector struct {
+	// Platform is the platform to select files for, in the form GOOS/GOARCH.
+	Platform string
+	// Tags are the selected tags.
+	Tags []string
+}
+
+// Matches returns true if the file should be installed.
+func (s FileSelector) Matches(f File) bool {
+	return s.matchesPlatform(f.Platforms) && s.matchesTags(f.Tags)
+}
+
+func (s FileSelector) matchesPlatform(platforms []string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+
+	goos, goarch, _ := strings.Cut(s.Platform, "/")
+
+	for _, p := range platforms {
+		pOS, pArch, hasArch := strings.Cut(p, "/")
+		if pOS != goos {
+			continue
+		}
+
+		if !hasArch || pArch == goarch {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s FileSelector) matchesTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, st := range s.Tags {
+			if st == t {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Select returns the files that should be installed.
+func (s FileSelector) Select(files []File) []File {
+	var ret []File
+	for _, f := range files {
+		if s.Matches(f) {
+			ret = append(ret, f)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,99 @@
+package pak_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestFileSelector(t *testing.T) {
+	selector := pak.FileSelector{Platform: "linux/amd64", Tags: []string{"hd", "extra"}}
+
+	tests := []struct {
+		file pak.File
+		want bool
+	}{
+		{file: pak.File{Path: "all"}, want: true},
+		{file: pak.File{Path: "os", Platforms: []string{"linux"}}, want: true},
+		{file: pak.File{Path: "arch", Platforms: []string{"linux/amd64"}}, want: true},
+		{file: pak.File{Path: "other arch", Platforms: []string{"linux/arm64"}}, want: false},
+		{file: pak.File{Path: "other os", Platforms: []string{"windows", "darwin/amd64"}}, want: false},
+		{file: pak.File{Path: "any of", Platforms: []string{"windows", "linux/amd64"}}, want: true},
+		{file: pak.File{Path: "tag", Tags: []string{"hd"}}, want: true},
+		{file: pak.File{Path: "all tags", Tags: []string{"hd", "extra"}}, want: true},
+		{file: pak.File{Path: "missing tag", Tags: []string{"hd", "4k"}}, want: false},
+		{file: pak.File{Path: "tag and platform", Platforms: []string{"windows"}, Tags: []string{"hd"}}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := selector.Matches(tt.file); got != tt.want {
+			t.Errorf("Matches(%s) = %v, want %v", tt.file.Path, got, tt.want)
+		}
+	}
+}
+
+func TestInstallPlatformFiles(t *testing.T) {
+	e := newTestEnv(t)
+	e.add(pak.Manifest{
+		ID:      "a",
+		Version: "1.0.0",
+		Files: []pak.File{
+			{Path: "common.txt"},
+			{Path: "linux.so", Platforms: []string{"linux"}},
+			{Path: "windows.dll", Platforms: []string{"windows/amd64"}},
+			{Path: "hd.png", Tags: []string{"hd"}},
+		},
+	}, map[string]string{"common.txt": "common", "linux.so": "linux", "windows.dll": "windows", "hd.png": "hd"})
+	e.add(pak.Manifest{
+		ID:      "b",
+		Version: "1.0.0",
+		Files:   []pak.File{{Path: "windows.dll", Platforms: []string{"windows"}}},
+	}, map[string]string{"windows.dll": "windows"})
+	e.add(pak.Manifest{
+		ID:      "b",
+		Version: "2.0.0",
+		Files:   []pak.File{{Path: "windows.dll", Platforms: []string{"windows"}}},
+	}, map[string]string{"windows.dll": "windows 2"})
+
+	m := e.manager(pak.ManagerOptions{Platform: "linux/amd64", KeepVersions: 1})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}, pak.InstallSpec{ID: "b", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	want := []string{"common.txt", "linux.so"}
+	if got := e.installed("a").InstalledFiles; !reflect.DeepEqual(got, want) {
+		t.Errorf("installed files of a = %v, want %v", got, want)
+	}
+	for path, want := range map[string]string{"common.txt": "common", "linux.so": "linux", "windows.dll": "", "hd.png": ""} {
+		if got := e.read("a", path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	// no files are installed, which is distinct from not recording the installed files
+	if got := e.installed("b").InstalledFiles; got == nil || len(got) != 0 {
+		t.Errorf("installed files of b = %#v, want empty", got)
+	}
+
+	// keeping the version of b does not look for files that were not installed
+	if err := m.Upgrade(e.ctx, pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	results, err := m.Verify(e.ctx)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	for _, r := range results {
+		if !r.OK() || len(r.Extraneous) > 0 {
+			t.Errorf("Verify() = %+v, want no problems", r)
+		}
+	}
+
+	if err := m.Uninstall(e.ctx, "a", "b"); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+	e.checkVersions(map[string]string{})
+}
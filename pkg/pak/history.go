@@ -0,0 +1,122 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Action is an operation performed by the Manager.
+type Action string
+
+const (
+	ActionInstall   Action = "install"
+	ActionUpgrade   Action = "upgrade"
+	ActionDowngrade Action = "downgrade"
+	ActionUninstall Action = "uninstall"
+	ActionRollback  Action = "rollback"
+)
+
+// Result is the result of an operation performed by the Manager.
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailure Result = "failure"
+)
+
+// HistoryEntry is a record of an operation performed on a pak by the Manager.
+type HistoryEntry struct {
+	Time   Time   `yaml:"time" json:"time"`
+	Actor  string `yaml:"actor,omitempty" json:"actor,omitempty"`
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+	Action Action `yaml:"action" json:"action"`
+	ID     string `yaml:"id" json:"id"`
+	// OldVersion is the version installed before the operation, if any.
+	OldVersion string `yaml:"oldVersion,omitempty" json:"oldVersion,omitempty"`
+	// NewVersion is the version installed by the operation, if any.
+	NewVersion string `yaml:"newVersion,omitempty" json:"newVersion,omitempty"`
+	// Source describes the remote repository used by the operation.
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	Result Result `yaml:"result" json:"result"`
+	// Error is the error message if the operation failed.
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+type reasonKey struct{}
+
+// WithReason returns a context that records the given reason in the history
+// entries of operations performed with it.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+func reasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
+
+// History returns the operations performed on paks, oldest first.
+// If id is not empty, only the operations performed on the pak with the given id are returned.
+// It returns nil if the local repository does not implement HistoryRecorder.
+func (m *Manager) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	recorder, ok := m.local.(HistoryRecorder)
+	if !ok {
+		return nil, nil
+	}
+
+	history, err := recorder.History(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+
+	if id == "" {
+		return history, nil
+	}
+
+	var ret []HistoryEntry
+	for _, e := range history {
+		if e.ID == id {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret, nil
+}
+
+// record appends an entry for the operation to the history, if the local repository supports it.
+// Failure to record the entry is logged rather than returned, so that it does
+// not mask the result of the operation.
+func (m *Manager) record(ctx context.Context, entry HistoryEntry, opErr error) {
+	recorder, ok := m.local.(HistoryRecorder)
+	if !ok {
+		return
+	}
+
+	entry.Time = Time{Time: time.Now()}
+	entry.Actor = m.actor
+	entry.Reason = reasonFromContext(ctx)
+
+	// only operations that install from the remote have a source
+	if entry.Action != ActionUninstall && entry.Action != ActionRollback {
+		entry.Source = m.source()
+	}
+
+	entry.Result = ResultSuccess
+	if opErr != nil {
+		entry.Result = ResultFailure
+		entry.Error = opErr.Error()
+	}
+
+	if err := recorder.AppendHistory(ctx, entry); err != nil {
+		m.logger.Infof("Error recording history for %s: %v", entry.ID, err)
+	}
+}
+
+func (m *Manager) source() string {
+	if s, ok := m.remote.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return ""
+}
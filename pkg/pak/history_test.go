@@ -0,0 +1,107 @@
+package pak_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestHistory(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.addFiles("a", "2.0.0", map[string]string{"plugin.txt": "2.0.0"})
+	e.addFiles("b", "1.0.0", map[string]string{"plugin.txt": "b"})
+
+	m := e.manager(pak.ManagerOptions{KeepVersions: 1, Actor: "tester"})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := m.Install(pak.WithReason(e.ctx, "needed"), pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := m.Upgrade(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	// installing the installed version and refusing to downgrade are not recorded
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err == nil {
+		t.Fatalf("Install() of an older version succeeded")
+	}
+
+	if _, err := m.Rollback(e.ctx, "a"); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := m.Downgrade(e.ctx, pak.InstallSpec{ID: "b", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Downgrade() error = %v", err)
+	}
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "b", Version: "3.0.0"}); err == nil {
+		t.Fatalf("Install() of a missing version succeeded")
+	}
+	if err := m.Uninstall(e.ctx, "b"); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+
+	history, err := m.History(e.ctx, "")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+
+	type entry struct {
+		action     pak.Action
+		id         string
+		oldVersion string
+		newVersion string
+		result     pak.Result
+	}
+
+	var got []entry
+	for _, h := range history {
+		got = append(got, entry{h.Action, h.ID, h.OldVersion, h.NewVersion, h.Result})
+
+		if h.Actor != "tester" {
+			t.Errorf("%s %s actor = %q, want the manager actor", h.Action, h.ID, h.Actor)
+		}
+		if h.Time.IsZero() {
+			t.Errorf("%s %s time is not set", h.Action, h.ID)
+		}
+		if (h.Result == pak.ResultFailure) != (h.Error != "") {
+			t.Errorf("%s %s result = %s, error = %q, want an error only for failures", h.Action, h.ID, h.Result, h.Error)
+		}
+	}
+
+	want := []entry{
+		{pak.ActionInstall, "a", "", "1.0.0", pak.ResultSuccess},
+		{pak.ActionInstall, "b", "", "1.0.0", pak.ResultSuccess},
+		{pak.ActionUpgrade, "a", "1.0.0", "2.0.0", pak.ResultSuccess},
+		{pak.ActionRollback, "a", "2.0.0", "1.0.0", pak.ResultSuccess},
+		{pak.ActionUpgrade, "b", "1.0.0", "3.0.0", pak.ResultFailure},
+		{pak.ActionUninstall, "b", "1.0.0", "", pak.ResultSuccess},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("History() = %+v, want %+v", got, want)
+	}
+
+	if len(history) > 1 && history[1].Reason != "needed" {
+		t.Errorf("history reason = %q, want the reason of the context", history[1].Reason)
+	}
+
+	// the history is filtered by pak
+	filtered, err := m.History(e.ctx, "b")
+	if err != nil {
+		t.Fatalf("History(b) error = %v", err)
+	}
+	for _, h := range filtered {
+		if h.ID != "b" {
+			t.Errorf("History(b) returned an entry for %s", h.ID)
+		}
+	}
+	if len(filtered) != 3 {
+		t.Errorf("History(b) returned %d entries, want 3", len(filtered))
+	}
+}
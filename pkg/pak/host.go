@@ -0,0 +1,222 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HostRequirements describes the host application that a pak is compatible with.
+type HostRequirements struct {
+	// Version is a version constraint that the host application version must satisfy.
+	// See MatchesConstraint for the constraint format.
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	// Capabilities are capabilities that the host application must provide.
+	Capabilities []string `yaml:"capabilities,omitempty" json:"capabilities,omitempty"`
+}
+
+// IncompatibleError is returned when a pak version is not compatible with the host application.
+type IncompatibleError struct {
+	ID      string
+	Version string
+	Reason  string
+}
+
+func (e IncompatibleError) Error() string {
+	return fmt.Sprintf("%s@%s is not compatible with the host: %s", e.ID, e.Version, e.Reason)
+}
+
+// hostConfigured returns true if the host version or capabilities have been set.
+// Compatibility is not checked if neither is set.
+func (m *Manager) hostConfigured() bool {
+	return m.hostVersion != "" || m.hostCapabilities != nil
+}
+
+// checkCompatible returns an IncompatibleError if the manifest is not compatible
+// with the host. Requirements declared in the manifest take precedence over
+// those declared in the spec.
+func (m *Manager) checkCompatible(spec *Spec, manifest *Manifest) error {
+	if !m.hostConfigured() {
+		return nil
+	}
+
+	req := manifest.Host
+	if req == nil && spec != nil {
+		req = spec.Host
+	}
+
+	if req == nil {
+		return nil
+	}
+
+	incompatible := func(reason string) error {
+		return IncompatibleError{ID: manifest.ID, Version: manifest.Version, Reason: reason}
+	}
+
+	if req.Version != "" && m.hostVersion != "" {
+		ok, err := MatchesConstraint(m.hostVersion, req.Version)
+		if err != nil {
+			return incompatible(err.Error())
+		}
+
+		if !ok {
+			return incompatible(fmt.Sprintf("requires host version %s", req.Version))
+		}
+	}
+
+	var missing []string
+	for _, c := range req.Capabilities {
+		if _, ok := m.hostCapabilities[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+
+	if len(missing) > 0 {
+		return incompatible(fmt.Sprintf("requires host capabilities: %s", strings.Join(missing, ", ")))
+	}
+
+	return nil
+}
+
+// resolve returns the manifest of the version of the pak to install.
+// If a version is specified, then its manifest is returned if it is compatible
+// with the host and satisfies the requirements. Otherwise, the newest compatible
+// version satisfying the requirements and not newer than the current version of
+// the pak in the release channel is returned, skipping yanked versions.
+func (m *Manager) resolve(ctx context.Context, toInstall InstallSpec, reqs []requirement) (*Manifest, error) {
+	spec, err := m.remote.GetSpec(ctx, toInstall.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting spec: %w", err)
+	}
+
+	// a specific version may be installed without a spec, unless the host
+	// requirements in the spec are needed
+	if spec == nil && (toInstall.Version == "" || m.hostConfigured()) {
+		return nil, ErrSpecNotFound
+	}
+
+	if toInstall.Version != "" {
+		if err := checkRequirements(toInstall.ID, toInstall.Version, reqs); err != nil {
+			return nil, err
+		}
+
+		manifest, err := m.getManifest(ctx, toInstall.ID, toInstall.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := m.checkCompatible(spec, manifest); err != nil {
+			return nil, err
+		}
+
+		if spec != nil && spec.IsYanked(toInstall.Version) {
+			m.logger.Infof("Warning: %s@%s has been yanked", toInstall.ID, toInstall.Version)
+		}
+		m.warnDeprecated(spec, manifest)
+
+		return manifest, nil
+	}
+
+	if _, ok := spec.Channels[toInstall.Channel]; toInstall.Channel != "" && !ok {
+		m.logger.Debugf("%s has no %s channel, using the current version", toInstall.ID, toInstall.Channel)
+	}
+
+	var firstErr error
+	for _, v := range candidateVersions(spec, spec.ChannelVersion(toInstall.Channel)) {
+		if spec.IsYanked(v) {
+			err := YankedError{ID: toInstall.ID, Version: v}
+			m.logger.Debugf("skipping %s@%s: %v", toInstall.ID, v, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if err := checkRequirements(toInstall.ID, v, reqs); err != nil {
+			m.logger.Debugf("skipping %s@%s: %v", toInstall.ID, v, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		manifest, err := m.getManifest(ctx, toInstall.ID, v)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.checkCompatible(spec, manifest)
+		if err == nil {
+			m.warnDeprecated(spec, manifest)
+			return manifest, nil
+		}
+
+		m.logger.Debugf("skipping %s@%s: %v", toInstall.ID, v, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return nil, ManifestNotFoundError{Version: spec.ChannelVersion(toInstall.Channel)}
+}
+
+// warnDeprecated logs a warning if the version of the manifest is deprecated in the spec.
+func (m *Manager) warnDeprecated(spec *Spec, manifest *Manifest) {
+	if spec == nil {
+		return
+	}
+
+	if v, _ := spec.FindVersion(manifest.Version); v.Deprecated != "" {
+		m.logger.Infof("Warning: %s@%s is deprecated: %s", manifest.ID, manifest.Version, v.Deprecated)
+	}
+}
+
+func (m *Manager) getManifest(ctx context.Context, id string, version string) (*Manifest, error) {
+	manifest, err := m.remote.GetManifest(ctx, id, version)
+	if err != nil {
+		return nil, fmt.Errorf("getting remote pak manifest: %w", err)
+	}
+
+	if manifest == nil {
+		return nil, ManifestNotFoundError{Version: version}
+	}
+
+	return manifest, nil
+}
+
+// candidateVersions returns the given head version followed by the older
+// versions of the spec, newest first. Yanked versions are included.
+func candidateVersions(spec *Spec, head string) []string {
+	ret := []string{head}
+
+	var older []string
+	for _, v := range spec.Versions {
+		if v.Version != head && CompareVersions(v.Version, head) < 0 {
+			older = append(older, v.Version)
+		}
+	}
+
+	sort.SliceStable(older, func(i, j int) bool {
+		return CompareVersions(older[i], older[j]) > 0
+	})
+
+	return append(ret, older...)
+}
+
+// latestVersion returns the newest version of the spec that has not been
+// yanked and is not newer than the version of the given channel, or an empty
+// string if all such versions have been yanked.
+func latestVersion(spec *Spec, channel string) string {
+	for _, v := range candidateVersions(spec, spec.ChannelVersion(channel)) {
+		if !spec.IsYanked(v) {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,86 @@
+package pak
+
+// Index is the contents of an index file.
+type Index struct {
+	// FormatVersion is the version of the index file format.
+	// It is set to FormatVersion when the index is read.
+	FormatVersion int `yaml:"formatVersion" json:"formatVersion"`
+
+	// Revision identifies the contents of the index. It is increased each time
+	// the index is changed. If zero, the index does not support incremental updates.
+	Revision int64 `yaml:"revision,omitempty" json:"revision,omitempty"`
+
+	// Sharded is true if the full spec of each pak is stored in a separate
+	// file, and Paks only contains a summary of each pak.
+	Sharded bool `yaml:"sharded,omitempty" json:"sharded,omitempty"`
+
+	// KeepChanges is the number of previous revisions of the index that change
+	// files are kept for. It is recorded so that tools updating the repository
+	// keep the same number.
+	KeepChanges int `yaml:"keepChanges,omitempty" json:"keepChanges,omitempty"`
+
+	Paks SpecIndex `yaml:"paks" json:"paks"`
+}
+
+// MinFormatVersion returns the oldest format version that can represent the
+// index, which is the version that it is written with.
+func (i Index) MinFormatVersion() int {
+	if i.Sharded {
+		return 3
+	}
+
+	return 2
+}
+
+// IndexDelta is the changes made to an index since a previous revision.
+type IndexDelta struct {
+	// FormatVersion is the version of the file format.
+	FormatVersion int `yaml:"formatVersion" json:"formatVersion"`
+
+	// From is the revision of the index that the changes are relative to.
+	From int64 `yaml:"from" json:"from"`
+	// Revision is the revision of the index after the changes are applied.
+	Revision int64 `yaml:"revision" json:"revision"`
+
+	// Changed contains the added and changed specs, in the same form as the index.
+	Changed SpecIndex `yaml:"changed,omitempty" json:"changed,omitempty"`
+	// Removed contains the IDs of the removed paks.
+	Removed []string `yaml:"removed,omitempty" json:"removed,omitempty"`
+}
+
+// Apply returns a copy of the index with the changes in the delta applied.
+// It returns false if the delta is not relative to the revision of the index.
+func (i Index) Apply(delta IndexDelta) (Index, bool) {
+	if i.Revision == 0 || delta.From != i.Revision {
+		return i, false
+	}
+
+	paks := make(SpecIndex, len(i.Paks)+len(delta.Changed))
+	for id, spec := range i.Paks {
+		paks[id] = spec
+	}
+
+	for id, spec := range delta.Changed {
+		paks[id] = spec
+	}
+
+	for _, id := range delta.Removed {
+		delete(paks, id)
+	}
+
+	i.Paks = paks
+	i.Revision = delta.Revision
+	return i, true
+}
+
+// Summary returns a copy of the spec containing only the fields included in a sharded index.
+func (s Spec) Summary() Spec {
+	return Spec{
+		ID:             s.ID,
+		Name:           s.Name,
+		Description:    s.Description,
+		CurrentVersion: s.CurrentVersion,
+		Updated:        s.Updated,
+		Tags:           s.Tags,
+	}
+}
@@ -0,0 +1,145 @@
+package pak
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexApply(t *testing.T) {
+	a := Spec{ID: "a", CurrentVersion: "1.0.0"}
+	a2 := Spec{ID: "a", CurrentVersion: "2.0.0"}
+	b := Spec{ID: "b", CurrentVersion: "1.0.0"}
+	c := Spec{ID: "c", CurrentVersion: "1.0.0"}
+
+	index := Index{
+		FormatVersion: 2,
+		Revision:      3,
+		KeepChanges:   2,
+		Paks:          SpecIndex{"a": a, "b": b},
+	}
+
+	tests := []struct {
+		name    string
+		index   Index
+		delta   IndexDelta
+		want    SpecIndex
+		wantRev int64
+		wantOK  bool
+	}{
+		{
+			name:    "no changes",
+			index:   index,
+			delta:   IndexDelta{From: 3, Revision: 4},
+			want:    SpecIndex{"a": a, "b": b},
+			wantRev: 4,
+			wantOK:  true,
+		},
+		{
+			name:    "added",
+			index:   index,
+			delta:   IndexDelta{From: 3, Revision: 4, Changed: SpecIndex{"c": c}},
+			want:    SpecIndex{"a": a, "b": b, "c": c},
+			wantRev: 4,
+			wantOK:  true,
+		},
+		{
+			name:    "changed",
+			index:   index,
+			delta:   IndexDelta{From: 3, Revision: 5, Changed: SpecIndex{"a": a2}},
+			want:    SpecIndex{"a": a2, "b": b},
+			wantRev: 5,
+			wantOK:  true,
+		},
+		{
+			name:    "removed",
+			index:   index,
+			delta:   IndexDelta{From: 3, Revision: 4, Removed: []string{"b"}},
+			want:    SpecIndex{"a": a},
+			wantRev: 4,
+			wantOK:  true,
+		},
+		{
+			name:    "removed missing pak",
+			index:   index,
+			delta:   IndexDelta{From: 3, Revision: 4, Removed: []string{"c"}},
+			want:    SpecIndex{"a": a, "b": b},
+			wantRev: 4,
+			wantOK:  true,
+		},
+		{
+			name:  "added, changed and removed",
+			index: index,
+			delta: IndexDelta{
+				From:     3,
+				Revision: 6,
+				Changed:  SpecIndex{"a": a2, "c": c},
+				Removed:  []string{"b"},
+			},
+			want:    SpecIndex{"a": a2, "c": c},
+			wantRev: 6,
+			wantOK:  true,
+		},
+		{
+			name:    "empty index",
+			index:   Index{Revision: 1},
+			delta:   IndexDelta{From: 1, Revision: 2, Changed: SpecIndex{"a": a}},
+			want:    SpecIndex{"a": a},
+			wantRev: 2,
+			wantOK:  true,
+		},
+		{
+			name:    "older revision",
+			index:   index,
+			delta:   IndexDelta{From: 2, Revision: 4, Changed: SpecIndex{"c": c}},
+			want:    SpecIndex{"a": a, "b": b},
+			wantRev: 3,
+		},
+		{
+			name:    "newer revision",
+			index:   index,
+			delta:   IndexDelta{From: 4, Revision: 5, Changed: SpecIndex{"c": c}},
+			want:    SpecIndex{"a": a, "b": b},
+			wantRev: 3,
+		},
+		{
+			name:    "no revision",
+			index:   Index{Paks: SpecIndex{"a": a}},
+			delta:   IndexDelta{From: 0, Revision: 1, Changed: SpecIndex{"c": c}},
+			want:    SpecIndex{"a": a},
+			wantRev: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before SpecIndex
+			if tt.index.Paks != nil {
+				before = make(SpecIndex, len(tt.index.Paks))
+				for id, spec := range tt.index.Paks {
+					before[id] = spec
+				}
+			}
+
+			got, ok := tt.index.Apply(tt.delta)
+			if ok != tt.wantOK {
+				t.Errorf("Apply() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got.Revision != tt.wantRev {
+				t.Errorf("Apply() revision = %d, want %d", got.Revision, tt.wantRev)
+			}
+			if !reflect.DeepEqual(got.Paks, tt.want) {
+				t.Errorf("Apply() paks = %v, want %v", got.Paks, tt.want)
+			}
+
+			// the other fields are kept
+			if got.FormatVersion != tt.index.FormatVersion || got.KeepChanges != tt.index.KeepChanges || got.Sharded != tt.index.Sharded {
+				t.Errorf("Apply() = %+v, want the fields of %+v", got, tt.index)
+			}
+
+			// the original index is not modified
+			if !reflect.DeepEqual(tt.index.Paks, before) {
+				t.Errorf("Apply() modified the index: %v, want %v", tt.index.Paks, before)
+			}
+		})
+	}
+}
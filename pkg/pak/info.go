@@ -0,0 +1,43 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+)
+
+// PakInfo is the information about a pak returned by Info.
+type PakInfo struct {
+	Spec
+
+	// Latest is the manifest of the current version of the pak in the remote repository.
+	Latest *Manifest
+	// Installed is the manifest of the installed version of the pak, or nil if it is not installed.
+	Installed *Manifest
+}
+
+// Info returns the spec of the pak with the given ID, along with the manifests
+// of its current and installed versions.
+func (m *Manager) Info(ctx context.Context, id string) (*PakInfo, error) {
+	spec, err := m.remote.GetSpec(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting spec: %w", err)
+	}
+
+	if spec == nil {
+		return nil, ErrSpecNotFound
+	}
+
+	ret := &PakInfo{Spec: *spec}
+
+	ret.Latest, err = m.getManifest(ctx, id, spec.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.Installed, err = m.local.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting local pak manifest: %w", err)
+	}
+
+	return ret, nil
+}
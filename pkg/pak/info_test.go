@@ -0,0 +1,70 @@
+package pak_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestInfo(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.add(pak.Manifest{
+		ID:        "a",
+		Version:   "2.0.0",
+		Changelog: "Added things.",
+		Files:     []pak.File{{Path: "plugin.txt"}},
+	}, map[string]string{"plugin.txt": "2.0.0"})
+
+	spec := e.remote.Index["a"]
+	spec.Authors = []string{"Someone <someone@example.com>"}
+	spec.License = "MIT"
+	spec.Homepage = "https://example.com/a"
+	spec.Tags = []string{"Tools", "ui"}
+	e.remote.Index["a"] = spec
+
+	m := e.manager(pak.ManagerOptions{})
+
+	if _, err := m.Info(e.ctx, "missing"); !errors.Is(err, pak.ErrSpecNotFound) {
+		t.Errorf("Info(missing) error = %v, want ErrSpecNotFound", err)
+	}
+
+	info, err := m.Info(e.ctx, "a")
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(info.Spec, spec) {
+		t.Errorf("Info() spec = %+v, want %+v", info.Spec, spec)
+	}
+	if info.Latest == nil || info.Latest.Version != "2.0.0" || info.Latest.Changelog != "Added things." {
+		t.Errorf("Info() latest = %+v, want the manifest of 2.0.0", info.Latest)
+	}
+	if info.Installed != nil {
+		t.Errorf("Info() installed = %+v, want nil", info.Installed)
+	}
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	info, err = m.Info(e.ctx, "a")
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	if info.Installed == nil || info.Installed.Version != "1.0.0" {
+		t.Errorf("Info() installed = %+v, want the manifest of 1.0.0", info.Installed)
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	spec := pak.Spec{Tags: []string{"Tools", "ui"}}
+
+	for tag, want := range map[string]bool{"tools": true, "UI": true, "Tools": true, "tool": false, "": false} {
+		if got := spec.HasTag(tag); got != want {
+			t.Errorf("HasTag(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Package schema defines the fields of pakman files that are checked by the codecs when reading them.
+package schema
+
+// FormatVersionKey is the key of the format version of a file.
+const FormatVersionKey = "formatVersion"
+
+// The fields that must be present, and not empty, in each kind of file.
+var (
+	Spec     = []string{"id", "name", "currentVersion"}
+	Manifest = []string{"id", "name", "version"}
+	// Index is the required fields of an index since format version 2.
+	Index = []string{FormatVersionKey, "paks"}
+	Delta = []string{FormatVersionKey, "from", "revision"}
+)
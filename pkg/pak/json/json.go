@@ -0,0 +1,229 @@
+// Package json provides functions for reading and writing pakman json files.
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/internal/schema"
+)
+
+// readDocument reads f, returning its contents and root value.
+func readDocument(f io.Reader) ([]byte, *node, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, root, nil
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode json: %w", err)
+	}
+
+	return nil
+}
+
+// ReadSpec reads a spec from the given reader parsing it as json.
+// It returns an error if the spec is missing required fields or has unknown fields.
+func ReadSpec(f io.Reader) (*pak.Spec, error) {
+	data, root, err := readDocument(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(data, root, "spec", schema.Spec); err != nil {
+		return nil, err
+	}
+
+	var spec pak.Spec
+	if err := decode(data, root, &spec); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+// WriteSpec writes the given spec to the given writer as json.
+func WriteSpec(out io.Writer, spec pak.Spec) error {
+	return writeJSON(out, spec)
+}
+
+// ReadManifest reads a manifest from the given reader parsing it as json.
+// It returns an error if the manifest is missing required fields or has unknown fields.
+// Manifests in older formats are migrated to the current format.
+func ReadManifest(f io.Reader) (*pak.Manifest, error) {
+	data, root, err := readDocument(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := formatVersion(data, root); err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(data, root, "manifest", schema.Manifest); err != nil {
+		return nil, err
+	}
+
+	var manifest pak.Manifest
+	if err := decode(data, root, &manifest); err != nil {
+		return nil, err
+	}
+
+	// versions 2 and 3 only changed the index format
+	manifest.FormatVersion = pak.FormatVersion
+
+	return &manifest, nil
+}
+
+// manifestDocument is the form that manifests are written in. An empty list
+// of installed files is written, so that it is not read as nil.
+type manifestDocument struct {
+	pak.Manifest
+	InstalledFiles *[]string `json:"installedFiles,omitempty"`
+}
+
+// WriteManifest writes the given manifest to the given writer as json, in the current format.
+func WriteManifest(out io.Writer, manifest pak.Manifest) error {
+	manifest.FormatVersion = pak.ManifestFormatVersion
+
+	doc := manifestDocument{Manifest: manifest}
+	if manifest.InstalledFiles != nil {
+		doc.InstalledFiles = &manifest.InstalledFiles
+	}
+
+	return writeJSON(out, doc)
+}
+
+// ReadSpecIndex reads a spec index from the given reader parsing it as json.
+// See ReadIndex.
+func ReadSpecIndex(f io.Reader) (*pak.SpecIndex, error) {
+	index, err := ReadIndex(f)
+	if index == nil {
+		return nil, err
+	}
+
+	return &index.Paks, err
+}
+
+// WriteSpecIndex writes the given spec index to the given writer as json.
+func WriteSpecIndex(out io.Writer, index pak.SpecIndex) error {
+	return WriteIndex(out, pak.Index{Paks: index})
+}
+
+// ReadIndex reads an index from the given reader parsing it as json.
+// Indexes in older formats are migrated to the current format.
+//
+// Specs that are missing required fields or cannot otherwise be decoded are
+// omitted from the index, and a pak.IndexError naming them is returned along with the index.
+func ReadIndex(f io.Reader) (*pak.Index, error) {
+	data, root, err := readDocument(f)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := formatVersion(data, root)
+	if err != nil {
+		return nil, err
+	}
+
+	// version 1 indexes are an object of pak ID to spec, with no format version
+	if version == 1 {
+		paks, err := readSpecs(data, root)
+		if paks == nil {
+			return nil, err
+		}
+
+		return &pak.Index{FormatVersion: pak.FormatVersion, Paks: paks}, err
+	}
+
+	if err := checkRequired(data, root, "index", schema.Index); err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		FormatVersion int             `json:"formatVersion"`
+		Revision      int64           `json:"revision"`
+		Sharded       bool            `json:"sharded"`
+		KeepChanges   int             `json:"keepChanges"`
+		Paks          json.RawMessage `json:"paks"`
+	}
+	if err := decode(data, root, &doc); err != nil {
+		return nil, err
+	}
+
+	// version 3 added sharded indexes
+	if version == 2 && doc.Sharded {
+		return nil, SchemaError{Line: line(data, root.get("sharded").start), Message: "sharded indexes require format version 3"}
+	}
+
+	paks, err := readSpecs(data, root.get("paks"))
+	if paks == nil {
+		return nil, err
+	}
+
+	return &pak.Index{
+		FormatVersion: pak.FormatVersion,
+		Revision:      doc.Revision,
+		Sharded:       doc.Sharded,
+		KeepChanges:   doc.KeepChanges,
+		Paks:          paks,
+	}, err
+}
+
+// WriteIndex writes the given index to the given writer as json, in the
+// oldest format that can represent it. See pak.Index.MinFormatVersion.
+func WriteIndex(out io.Writer, index pak.Index) error {
+	index.FormatVersion = index.MinFormatVersion()
+	return writeJSON(out, index)
+}
+
+// ReadIndexDelta reads an index delta from the given reader parsing it as json.
+// Unlike ReadIndex, an invalid spec causes the whole delta to be rejected.
+func ReadIndexDelta(f io.Reader) (*pak.IndexDelta, error) {
+	data, root, err := readDocument(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := formatVersion(data, root); err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(data, root, "delta", schema.Delta); err != nil {
+		return nil, err
+	}
+
+	if changed := root.get("changed"); changed != nil {
+		if _, err := readSpecs(data, changed); err != nil {
+			return nil, err
+		}
+	}
+
+	var delta pak.IndexDelta
+	if err := decode(data, root, &delta); err != nil {
+		return nil, err
+	}
+
+	return &delta, nil
+}
+
+// WriteIndexDelta writes the given index delta to the given writer as json, in the current format.
+func WriteIndexDelta(out io.Writer, delta pak.IndexDelta) error {
+	delta.FormatVersion = pak.FormatVersion
+	return writeJSON(out, delta)
+}
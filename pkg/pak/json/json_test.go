@@ -0,0 +1,347 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestManifestInstalledFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+	}{
+		{name: "not recorded"},
+		{name: "none installed", installed: []string{}},
+		{name: "installed", installed: []string{"a.txt", "b/c.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteManifest(&buf, pak.Manifest{
+				ID:             "a",
+				Name:           "A",
+				Version:        "1.0.0",
+				Files:          []pak.File{{Path: "a.txt"}},
+				InstalledFiles: tt.installed,
+			}); err != nil {
+				t.Fatalf("WriteManifest() error = %v", err)
+			}
+
+			got, err := ReadManifest(&buf)
+			if err != nil {
+				t.Fatalf("ReadManifest() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.InstalledFiles, tt.installed) {
+				t.Errorf("InstalledFiles = %#v, want %#v", got.InstalledFiles, tt.installed)
+			}
+		})
+	}
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	updated, err := pak.ParseTime("2024-03-01T10:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := pak.Index{
+		FormatVersion: pak.FormatVersion,
+		Revision:      5,
+		KeepChanges:   3,
+		Paks: pak.SpecIndex{
+			"a": {
+				ID:             "a",
+				Name:           "A",
+				Description:    "<html> & \"quotes\"",
+				CurrentVersion: "1.1.0",
+				Updated:        updated,
+				Versions:       []pak.VersionInfo{{Version: "1.0.0"}, {Version: "1.1.0"}},
+				Channels:       map[string]string{"beta": "1.2.0-beta"},
+				Tags:           []string{"tools"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIndex(&buf, index); err != nil {
+		t.Fatalf("WriteIndex() error = %v", err)
+	}
+
+	// the index is written in the oldest format that can represent it
+	if !strings.Contains(buf.String(), `"formatVersion": 2`) {
+		t.Errorf("written index does not have format version 2:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `<html> & \"quotes\"`) {
+		t.Errorf("written index escapes html:\n%s", buf.String())
+	}
+
+	got, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got.Paks, index.Paks) || got.Revision != index.Revision || got.KeepChanges != index.KeepChanges {
+		t.Errorf("ReadIndex() = %+v, want %+v", got, index)
+	}
+}
+
+func TestReadIndexVersion1(t *testing.T) {
+	got, err := ReadIndex(strings.NewReader(`{
+  "a": {"id": "a", "name": "A", "currentVersion": "1.0.0"}
+}`))
+	if err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+
+	if got.FormatVersion != pak.FormatVersion || got.Paks["a"].CurrentVersion != "1.0.0" {
+		t.Errorf("ReadIndex() = %+v, want the migrated index", got)
+	}
+}
+
+func TestReadIndexInvalidSpec(t *testing.T) {
+	got, err := ReadIndex(strings.NewReader(`{
+  "formatVersion": 2,
+  "paks": {
+    "a": {"id": "a", "name": "A", "currentVersion": "1.0.0"},
+    "b": {
+      "id": "b",
+      "name": ""
+    }
+  }
+}`))
+
+	var indexErr pak.IndexError
+	if !errors.As(err, &indexErr) {
+		t.Fatalf("ReadIndex() error = %v, want IndexError", err)
+	}
+
+	if len(indexErr.Invalid) != 1 || indexErr.Invalid[0].ID != "b" {
+		t.Fatalf("invalid specs = %+v, want b", indexErr.Invalid)
+	}
+
+	var schemaErr SchemaError
+	if !errors.As(indexErr.Invalid[0].Err, &schemaErr) || schemaErr.Line != 7 {
+		t.Errorf("invalid spec error = %v, want a SchemaError on line 7", indexErr.Invalid[0].Err)
+	}
+
+	if got == nil || len(got.Paks) != 1 || got.Paks["a"].ID != "a" {
+		t.Errorf("ReadIndex() = %+v, want the valid specs", got)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     func(io.Reader) error
+		data     string
+		wantLine int
+		// notSchema is true if the error is not a SchemaError, but includes the line
+		notSchema bool
+	}{
+		{
+			name:      "syntax error",
+			read:      readManifest,
+			data:      "{\n  \"id\": \"a\",\n  \"name\" \"A\"\n}",
+			wantLine:  3,
+			notSchema: true,
+		},
+		{
+			name:     "not an object",
+			read:     readManifest,
+			data:     "\n[]",
+			wantLine: 2,
+		},
+		{
+			name:     "missing required field",
+			read:     readManifest,
+			data:     "{\n  \"id\": \"a\",\n  \"version\": \"1.0.0\"\n}",
+			wantLine: 1,
+		},
+		{
+			name:     "empty required field",
+			read:     readSpec,
+			data:     "{\n  \"id\": \"a\",\n  \"name\": \"A\",\n  \"currentVersion\": \"\"\n}",
+			wantLine: 4,
+		},
+		{
+			name:     "invalid format version",
+			read:     readIndex,
+			data:     "{\n  \"formatVersion\": \"two\",\n  \"paks\": {}\n}",
+			wantLine: 2,
+		},
+		{
+			name:     "sharded version 2 index",
+			read:     readIndex,
+			data:     "{\n  \"formatVersion\": 2,\n  \"paks\": {},\n  \"sharded\": true\n}",
+			wantLine: 4,
+		},
+		{
+			name:     "data after document",
+			read:     readSpec,
+			data:     "{\"id\": \"a\", \"name\": \"A\", \"currentVersion\": \"1.0.0\"}\n{}",
+			wantLine: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.read(strings.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("read succeeded, want an error")
+			}
+
+			if tt.notSchema {
+				if !strings.Contains(err.Error(), fmt.Sprintf("line %d", tt.wantLine)) {
+					t.Errorf("error = %v, want line %d", err, tt.wantLine)
+				}
+				return
+			}
+
+			var schemaErr SchemaError
+
+			if !errors.As(err, &schemaErr) || schemaErr.Line != tt.wantLine {
+				t.Errorf("error = %v, want a SchemaError on line %d", err, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestReadUnsupportedFormat(t *testing.T) {
+	_, err := ReadManifest(strings.NewReader(`{"formatVersion": 99, "id": "a", "name": "A", "version": "1.0.0"}`))
+
+	var unsupported pak.UnsupportedFormatError
+	if !errors.As(err, &unsupported) || unsupported.Version != 99 {
+		t.Errorf("ReadManifest() error = %v, want UnsupportedFormatError", err)
+	}
+}
+
+func readManifest(r io.Reader) error {
+	_, err := ReadManifest(r)
+	return err
+}
+
+func readSpec(r io.Reader) error {
+	_, err := ReadSpec(r)
+	return err
+}
+
+func readIndex(r io.Reader) error {
+	_, err := ReadIndex(r)
+	return err
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     func(io.Reader) error
+		data     string
+		wantLine int
+		wantKey  string
+	}{
+		{
+			name:     "manifest",
+			read:     readManifest,
+			data:     "{\n  \"id\": \"a\",\n  \"name\": \"A\",\n  \"version\": \"1.0.0\",\n  \"versoin\": \"2.0.0\"\n}",
+			wantLine: 5,
+			wantKey:  "versoin",
+		},
+		{
+			name:     "manifest file",
+			read:     readManifest,
+			data:     "{\n  \"id\": \"a\",\n  \"name\": \"A\",\n  \"version\": \"1.0.0\",\n  \"files\": [\n    \"a.txt\",\n    {\"path\": \"b.txt\",\n     \"plattforms\": [\"linux\"]}\n  ]\n}",
+			wantLine: 8,
+			wantKey:  "plattforms",
+		},
+		{
+			name:     "spec",
+			read:     readSpec,
+			data:     "{\n  \"id\": \"a\",\n  \"name\": \"A\",\n  \"currentVersion\": \"1.0.0\",\n  \"versions\": [{\"version\": \"1.0.0\", \"yank\": true}]\n}",
+			wantLine: 5,
+			wantKey:  "yank",
+		},
+		{
+			name:     "index",
+			read:     readIndex,
+			data:     "{\n  \"formatVersion\": 2,\n  \"revison\": 3,\n  \"paks\": {}\n}",
+			wantLine: 3,
+			wantKey:  "revison",
+		},
+		{
+			name:     "delta",
+			read:     readIndexDelta,
+			data:     "{\n  \"formatVersion\": 3,\n  \"from\": 1,\n  \"revision\": 2,\n  \"remove\": [\"a\"]\n}",
+			wantLine: 5,
+			wantKey:  "remove",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.read(strings.NewReader(tt.data))
+
+			var schemaErr SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("error = %v, want SchemaError", err)
+			}
+
+			want := SchemaError{Line: tt.wantLine, Message: fmt.Sprintf("unknown field %q", tt.wantKey)}
+			if schemaErr != want {
+				t.Errorf("error = %+v, want %+v", schemaErr, want)
+			}
+		})
+	}
+}
+
+// TestKnownFields checks that fields are matched ignoring case, as they are
+// decoded, and that fields of the embedded structs are known.
+func TestKnownFields(t *testing.T) {
+	manifest, err := ReadManifest(strings.NewReader(`{"id": "a", "name": "A", "version": "1.0.0", "Changelog": "fixes", "Scripts": {"postInstall": [{"run": ["a"], "timeout": "1s"}]}}`))
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+
+	if manifest.Changelog != "fixes" || manifest.Scripts == nil || len(manifest.Scripts.PostInstall) != 1 {
+		t.Errorf("ReadManifest() = %+v, want the fields decoded", manifest)
+	}
+}
+
+// TestIndexUnknownSpecField checks that a spec with an unknown field is omitted from the index.
+func TestIndexUnknownSpecField(t *testing.T) {
+	index, err := ReadIndex(strings.NewReader(`{
+  "formatVersion": 2,
+  "paks": {
+    "a": {"id": "a", "name": "A", "currentVersion": "1.0.0"},
+    "b": {"id": "b", "name": "B", "currentVersion": "1.0.0",
+          "colour": "blue"}
+  }
+}`))
+
+	var indexErr pak.IndexError
+	if !errors.As(err, &indexErr) || len(indexErr.Invalid) != 1 || indexErr.Invalid[0].ID != "b" {
+		t.Fatalf("ReadIndex() error = %v, want IndexError for b", err)
+	}
+
+	want := SchemaError{Line: 6, Message: `unknown field "colour"`}
+	var schemaErr SchemaError
+	if !errors.As(indexErr.Invalid[0].Err, &schemaErr) || schemaErr != want {
+		t.Errorf("invalid spec error = %v, want %v", indexErr.Invalid[0].Err, want)
+	}
+
+	if index == nil || len(index.Paks) != 1 || index.Paks["a"].ID != "a" {
+		t.Errorf("ReadIndex() = %+v, want the valid specs", index)
+	}
+}
+
+func readIndexDelta(r io.Reader) error {
+	_, err := ReadIndexDelta(r)
+	return err
+}
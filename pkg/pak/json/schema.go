@@ -0,0 +1,337 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/internal/schema"
+)
+
+// SchemaError is returned when a document does not match the expected schema.
+type SchemaError struct {
+	Line    int
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// node is a parsed json value, with its position in the document.
+type node struct {
+	// start and end are the offsets of the value in the document.
+	start, end int64
+
+	// delim is '{' for an object, '[' for an array and zero for a scalar.
+	delim json.Delim
+	// keys and values are the members of an object, or the elements of an array.
+	keys   []string
+	values []*node
+
+	// value is the value of a scalar, or nil if it is null.
+	value interface{}
+}
+
+func (n *node) isObject() bool {
+	return n.delim == '{'
+}
+
+// get returns the value of the given key in the object, or nil if it is not present.
+func (n *node) get(key string) *node {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i]
+		}
+	}
+
+	return nil
+}
+
+// parseDocument parses data, returning the root value of the document.
+// It returns an error if the root value is not an object.
+func parseDocument(data []byte) (*node, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	root, err := parseValue(decoder, data)
+	if err != nil {
+		return nil, decodeError(data, 0, err)
+	}
+
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, SchemaError{Line: line(data, decoder.InputOffset()), Message: "unexpected data after document"}
+	}
+
+	if !root.isObject() {
+		return nil, SchemaError{Line: line(data, root.start), Message: "expected an object"}
+	}
+
+	return root, nil
+}
+
+func parseValue(decoder *json.Decoder, data []byte) (*node, error) {
+	n := &node{start: skipSeparators(data, decoder.InputOffset())}
+
+	t, err := decoder.Token()
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if delim, ok := t.(json.Delim); ok {
+		n.delim = delim
+		for decoder.More() {
+			if n.isObject() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+
+			v, err := parseValue(decoder, data)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+		}
+
+		// the closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	} else {
+		n.value = t
+	}
+
+	n.end = decoder.InputOffset()
+	return n, nil
+}
+
+// skipSeparators returns the offset of the first byte from offset that is not
+// whitespace or a separator, which is the start of the next value.
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n:,"), data[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+// line returns the line number of the byte offset in data.
+func line(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// decodeError adds the line number to errors from encoding/json, where the
+// offset of the error is known. base is the offset of the decoded value in data.
+func decodeError(data []byte, base int64, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("failed to decode json: line %d: %w", line(data, base+syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("failed to decode json: line %d: %w", line(data, base+typeErr.Offset), err)
+	}
+
+	return fmt.Errorf("failed to decode json: %w", err)
+}
+
+// decode decodes the value of the node into v. Fields that are not in v are
+// rejected with a SchemaError.
+func decode(data []byte, n *node, v interface{}) error {
+	if err := checkKnown(data, n, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data[n.start:n.end], v); err != nil {
+		return decodeError(data, n.start, err)
+	}
+
+	return nil
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// checkKnown returns a SchemaError if an object in the value of the node has a
+// key that is not a field of the type that it is decoded into. Keys are
+// matched to fields as they are by encoding/json, ignoring case.
+func checkKnown(data []byte, n *node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == rawMessageType {
+		return nil
+	}
+
+	switch {
+	case n.isObject() && t.Kind() == reflect.Struct:
+		fields := jsonFields(t)
+		for i, key := range n.keys {
+			field, ok := findField(fields, key)
+			if !ok {
+				return SchemaError{Line: line(data, n.values[i].start), Message: fmt.Sprintf("unknown field %q", key)}
+			}
+
+			if err := checkKnown(data, n.values[i], field.Type); err != nil {
+				return err
+			}
+		}
+	case n.isObject() && t.Kind() == reflect.Map:
+		for _, v := range n.values {
+			if err := checkKnown(data, v, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case n.delim == '[' && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, v := range n.values {
+			if err := checkKnown(data, v, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonFields returns the fields of the struct type that are decoded by
+// encoding/json, keyed by name, including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	ret := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range jsonFields(ft) {
+				if _, ok := ret[k]; !ok {
+					ret[k] = v
+				}
+			}
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		// fields of the outer struct take precedence over embedded fields
+		ret[name] = f
+	}
+
+	return ret
+}
+
+// findField returns the field with the given name, or the first field whose
+// name matches ignoring case.
+func findField(fields map[string]reflect.StructField, name string) (reflect.StructField, bool) {
+	if f, ok := fields[name]; ok {
+		return f, true
+	}
+
+	for k, f := range fields {
+		if strings.EqualFold(k, name) {
+			return f, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// formatVersion returns the format version declared in the object.
+// Documents without a format version are version 1.
+func formatVersion(data []byte, n *node) (int, error) {
+	v := n.get(schema.FormatVersionKey)
+	if v == nil {
+		return 1, nil
+	}
+
+	number, _ := v.value.(json.Number)
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 0, SchemaError{Line: line(data, v.start), Message: fmt.Sprintf("invalid format version %s", data[v.start:v.end])}
+	}
+
+	if version > pak.FormatVersion {
+		return 0, fmt.Errorf("line %d: %w", line(data, v.start), pak.UnsupportedFormatError{Version: int(version)})
+	}
+
+	return int(version), nil
+}
+
+// checkRequired returns a SchemaError if n is not an object with a non-empty
+// value for each of the required keys.
+func checkRequired(data []byte, n *node, what string, required []string) error {
+	if !n.isObject() {
+		return SchemaError{Line: line(data, n.start), Message: fmt.Sprintf("%s: expected an object", what)}
+	}
+
+	for _, key := range required {
+		v := n.get(key)
+		if v == nil {
+			return SchemaError{Line: line(data, n.start), Message: fmt.Sprintf("%s: missing required field %q", what, key)}
+		}
+
+		if v.delim == 0 && (v.value == nil || v.value == "") {
+			return SchemaError{Line: line(data, v.start), Message: fmt.Sprintf("%s: required field %q is empty", what, key)}
+		}
+	}
+
+	return nil
+}
+
+// readSpecs checks and decodes each spec in an object of pak ID to spec.
+// Invalid specs are omitted, and are returned in an IndexError.
+func readSpecs(data []byte, n *node) (pak.SpecIndex, error) {
+	if !n.isObject() {
+		return nil, SchemaError{Line: line(data, n.start), Message: "expected an object of pak ID to spec"}
+	}
+
+	index := pak.SpecIndex{}
+	errs := make(map[string]error)
+	for i, id := range n.keys {
+		if err := checkRequired(data, n.values[i], "spec", schema.Spec); err != nil {
+			errs[id] = err
+			continue
+		}
+
+		var spec pak.Spec
+		if err := decode(data, n.values[i], &spec); err != nil {
+			errs[id] = err
+			continue
+		}
+
+		index[id] = spec
+	}
+
+	return index, pak.NewIndexError(errs)
+}
@@ -2,7 +2,9 @@ package pak
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 )
 
 var (
@@ -28,6 +30,22 @@ type Manager struct {
 	local  WritableRepository
 	remote SourceRepository
 
+	executor     Executor
+	allowScripts bool
+
+	hostVersion      string
+	hostCapabilities map[string]struct{}
+
+	keepVersions int
+
+	actor string
+
+	onConfigConflict func(ConfigConflict)
+
+	selector FileSelector
+
+	defaultChannel string
+
 	logger Logger
 	// TODO: progress
 }
@@ -36,6 +54,43 @@ type ManagerOptions struct {
 	Local  WritableRepository
 	Remote SourceRepository
 
+	// Executor is used to run commands declared by paks.
+	Executor Executor
+	// AllowScripts must be set to true for pak commands to be run.
+	// If false, declared commands are skipped. If true without an Executor,
+	// commands are skipped with a warning.
+	AllowScripts bool
+
+	// HostVersion is the version of the host application.
+	// Pak versions that are not compatible with it are not installed.
+	HostVersion string
+	// HostCapabilities are the capabilities provided by the host application.
+	// Pak versions that require other capabilities are not installed.
+	HostCapabilities []string
+
+	// Platform is the platform to install files for, in the form GOOS/GOARCH.
+	// If empty, the platform of the running program is used.
+	Platform string
+	// Tags select the manifest files that are restricted to tags.
+	Tags []string
+
+	// KeepVersions is the number of previously installed versions of each pak
+	// to keep in the local repository, so that they can be rolled back to.
+	// It is only used if the local repository implements Archiver.
+	KeepVersions int
+
+	// Actor identifies who is performing operations, for the history.
+	Actor string
+
+	// OnConfigConflict is called when a locally modified config file is kept
+	// during an upgrade, and the new version of the file is written alongside it.
+	OnConfigConflict func(ConfigConflict)
+
+	// DefaultChannel is the release channel that paks are installed from,
+	// unless another channel is requested. If empty, or if a pak does not have
+	// the channel, the current version of the pak is installed.
+	DefaultChannel string
+
 	Logger Logger
 }
 
@@ -59,10 +114,34 @@ func NewManager(options ManagerOptions) *Manager {
 		options.Logger = noopLogger{}
 	}
 
+	if options.Platform == "" {
+		options.Platform = DefaultPlatform()
+	}
+
+	var capabilities map[string]struct{}
+	if options.HostCapabilities != nil {
+		capabilities = make(map[string]struct{}, len(options.HostCapabilities))
+		for _, c := range options.HostCapabilities {
+			capabilities[c] = struct{}{}
+		}
+	}
+
 	return &Manager{
-		local:  options.Local,
-		remote: options.Remote,
-		logger: options.Logger,
+		local:            options.Local,
+		remote:           options.Remote,
+		executor:         options.Executor,
+		allowScripts:     options.AllowScripts,
+		hostVersion:      options.HostVersion,
+		hostCapabilities: capabilities,
+		keepVersions:     options.KeepVersions,
+		actor:            options.Actor,
+		onConfigConflict: options.OnConfigConflict,
+		selector: FileSelector{
+			Platform: options.Platform,
+			Tags:     options.Tags,
+		},
+		defaultChannel: options.DefaultChannel,
+		logger:         options.Logger,
 	}
 }
 
@@ -70,17 +149,26 @@ type InstallSpec struct {
 	ID string
 	// Version is the version to install. If empty, the latest version is installed.
 	Version string
+	// Channel is the release channel to install the latest version from. If
+	// empty, the channel followed by the installed pak is used, or the default
+	// channel if the pak is not installed.
+	Channel string
 }
 
 // Install installs the given paks.
 // If the pak is already installed, it will be upgraded to the applicable version,
 // unless the already installed version is the same as the requested version, in which
 // case the function will return with no changes.
+//
+// Install will not install a version older than the installed version. Use
+// Downgrade to install an older version.
+//
+// Paks required by the installed paks are also installed. The given paks are
+// recorded as explicitly installed.
 func (m *Manager) Install(ctx context.Context, specs ...InstallSpec) error {
-	const upgrade = false
 	for _, spec := range specs {
 		m.logger.Infof("Installing %s@%s", spec.ID, spec.Version)
-		if err := m.install(ctx, spec, upgrade); err != nil {
+		if err := m.install(ctx, spec, installOptions{explicit: true}); err != nil {
 			return fmt.Errorf("installing pak %s@%s: %w", spec.ID, spec.Version, err)
 		}
 	}
@@ -88,93 +176,299 @@ func (m *Manager) Install(ctx context.Context, specs ...InstallSpec) error {
 	return nil
 }
 
-func (m *Manager) install(ctx context.Context, toInstall InstallSpec, upgrade bool) error {
+// Downgrade installs the given versions of installed paks, which may be older than
+// the installed versions.
+func (m *Manager) Downgrade(ctx context.Context, specs ...InstallSpec) error {
+	for _, spec := range specs {
+		if spec.Version == "" {
+			return fmt.Errorf("downgrading pak %s: %w", spec.ID, ErrInvalidInstallSpec)
+		}
+
+		if err := m.install(ctx, spec, installOptions{allowDowngrade: true}); err != nil {
+			return fmt.Errorf("downgrading pak %s@%s: %w", spec.ID, spec.Version, err)
+		}
+	}
+
+	return nil
+}
+
+type installOptions struct {
+	allowDowngrade bool
+
+	// explicit is true if the pak was requested to be installed by the user.
+	// Paks that are already installed are marked as explicitly installed.
+	explicit bool
+
+	// dependency is true if the pak is being installed as a dependency of another pak.
+	dependency bool
+	// constraint is the version constraint that the installed version must satisfy.
+	constraint string
+
+	// installing contains the IDs of the paks that are being installed,
+	// to prevent cyclic dependencies from recursing.
+	installing map[string]struct{}
+}
+
+// install installs the pak, recording the operation in the history.
+func (m *Manager) install(ctx context.Context, toInstall InstallSpec, opts installOptions) error {
 	if toInstall.ID == "" {
 		return ErrInvalidInstallSpec
 	}
 
+	entry := HistoryEntry{
+		Action:     ActionInstall,
+		ID:         toInstall.ID,
+		NewVersion: toInstall.Version,
+	}
+
+	err := m.installPak(ctx, toInstall, opts, &entry)
+
+	// entry.Action is cleared if no changes were attempted
+	if entry.Action != "" {
+		m.record(ctx, entry, err)
+	}
+
+	return err
+}
+
+func (m *Manager) installPak(ctx context.Context, toInstall InstallSpec, opts installOptions, entry *HistoryEntry) (err error) {
 	// check if pak already installed
 	existing, err := m.local.GetInstalledManifest(ctx, toInstall.ID)
 	if err != nil {
 		return fmt.Errorf("getting local pak spec: %w", err)
 	}
 
-	if toInstall.Version == "" {
-		spec, err := m.remote.GetSpec(ctx, toInstall.ID)
-		if err != nil {
-			return fmt.Errorf("getting spec: %w", err)
-		}
+	if existing != nil {
+		entry.Action = ActionUpgrade
+		entry.OldVersion = existing.Version
+	}
 
-		if spec == nil {
-			return ErrSpecNotFound
-		}
+	toInstall.Channel = m.channel(toInstall, existing)
 
-		toInstall.Version = spec.CurrentVersion
+	// the version must satisfy the installed paks that require it
+	reqs, err := m.requirements(ctx, toInstall.ID, opts)
+	if err != nil {
+		return err
 	}
 
+	// get pak manifest for latest compatible version/selected version
+	manifest, err := m.resolve(ctx, toInstall, reqs)
+	if err != nil {
+		return err
+	}
+
+	toInstall.Version = manifest.Version
+	entry.NewVersion = manifest.Version
+
 	if existing != nil {
 		// check if version is already installed
 		if existing.Version == toInstall.Version {
 			m.logger.Debugf("pak %s@%s already installed", toInstall.ID, toInstall.Version)
-			return nil
+			entry.Action = ""
+
+			if existing.Channel != toInstall.Channel {
+				existing.Channel = toInstall.Channel
+				if err := m.local.WriteManifest(ctx, *existing); err != nil {
+					return fmt.Errorf("writing local pak manifest: %w", err)
+				}
+			}
+
+			return m.markExplicit(ctx, existing, opts)
 		}
 	}
 
-	if upgrade {
-		m.logger.Infof("Upgrading %s from %s to %s", toInstall.ID, existing.Version, toInstall.Version)
+	if existing != nil {
+		if CompareVersions(toInstall.Version, existing.Version) < 0 {
+			if !opts.allowDowngrade {
+				entry.Action = ""
+				return DowngradeError{ID: toInstall.ID, From: existing.Version, To: toInstall.Version}
+			}
+
+			entry.Action = ActionDowngrade
+			m.logger.Infof("Downgrading %s from %s to %s", toInstall.ID, existing.Version, toInstall.Version)
+		} else {
+			m.logger.Infof("Upgrading %s from %s to %s", toInstall.ID, existing.Version, toInstall.Version)
+		}
 	}
 
-	// get pak manifest for latest version/selected version
-	manifest, err := m.remote.GetManifest(ctx, toInstall.ID, toInstall.Version)
-	if err != nil {
-		return fmt.Errorf("getting remote pak manifest: %w", err)
+	if err := m.checkFileConflicts(ctx, manifest); err != nil {
+		return err
 	}
 
-	if manifest == nil {
-		return ManifestNotFoundError{Version: toInstall.Version}
+	// paks installed as dependencies are removed if this version is not installed
+	addedDeps, err := m.installDependencies(ctx, manifest, opts)
+	written := false
+	defer func() {
+		if err != nil && !written {
+			m.removeDependencies(ctx, toInstall.ID, addedDeps)
+		}
+	}()
+
+	if err != nil {
+		return err
 	}
 
+	var modifiedConfig []string
+	previousFiles := make(map[string]File)
+
 	if existing != nil {
-		// uninstall the existing version
-		if err := m.uninstall(ctx, toInstall.ID); err != nil {
+		// keep locally modified config files
+		modifiedConfig, err = m.modifiedConfigFiles(ctx, existing)
+		if err != nil {
+			return fmt.Errorf("checking config files: %w", err)
+		}
+
+		for _, f := range existing.Files {
+			previousFiles[f.Path] = f
+		}
+
+		// keep the existing version so that it can be rolled back to
+		if err := m.archive(ctx, toInstall.ID); err != nil {
+			return err
+		}
+
+		restore, err := m.keepConfigFiles(ctx, existing, modifiedConfig)
+		if err != nil {
+			return fmt.Errorf("keeping config files: %w", err)
+		}
+
+		// remove the existing version, without running its pre-uninstall
+		// commands, since the pak is not being uninstalled
+		if err := m.deletePak(ctx, toInstall.ID); err != nil {
+			restore()
 			return fmt.Errorf("uninstalling existing version: %w", err)
 		}
 	}
 
-	// download pak files sending to store
-	for _, file := range manifest.Files {
-		if err := m.downloadFile(ctx, toInstall.ID, toInstall.Version, file); err != nil {
-			return fmt.Errorf("downloading file %q: %w", file, err)
+	kept := make(map[string]struct{}, len(modifiedConfig))
+	for _, path := range modifiedConfig {
+		kept[path] = struct{}{}
+	}
+
+	// download the pak files for this platform, sending to store
+	installed := []string{}
+	for i, file := range manifest.Files {
+		if !m.selector.Matches(file) {
+			continue
+		}
+
+		// the modified config files of the existing version are kept. Other
+		// files at the same path, such as files left by another installation,
+		// are replaced.
+		if _, wasKept := kept[file.Path]; wasKept {
+			modified, err := m.localConfigFile(ctx, toInstall.ID, file.Path)
+			if err != nil {
+				return fmt.Errorf("reading config file %q: %w", file.Path, err)
+			}
+
+			if modified != nil {
+				delete(kept, file.Path)
+
+				downloaded, paths, err := m.installConfigFile(ctx, manifest, file, previousFiles[file.Path], modified)
+				if err != nil {
+					return fmt.Errorf("installing config file %q: %w", file.Path, err)
+				}
+
+				manifest.Files[i] = downloaded
+				installed = append(installed, paths...)
+				continue
+			}
+		}
+
+		// record the size and digest of the installed file for verification
+		downloaded, err := m.downloadFile(ctx, toInstall.ID, toInstall.Version, file)
+		if err != nil {
+			return fmt.Errorf("downloading file %q: %w", file.Path, err)
+		}
+
+		manifest.Files[i] = downloaded
+		installed = append(installed, file.Path)
+	}
+
+	// modified config files that the new version does not install are left in place
+	for _, path := range modifiedConfig {
+		if _, ok := kept[path]; ok {
+			m.keptConfigConflict(manifest, path)
 		}
 	}
 
+	// record the files that were actually installed
+	manifest.InstalledFiles = installed
+
+	manifest.Channel = toInstall.Channel
+
+	// keep the installed state of the existing pak
+	if existing != nil {
+		manifest.Disabled = existing.Disabled
+		manifest.Held = existing.Held
+		manifest.AutoInstalled = existing.AutoInstalled && !opts.explicit
+	} else {
+		manifest.AutoInstalled = opts.dependency
+	}
+
 	if err := m.local.WriteManifest(ctx, *manifest); err != nil {
 		return fmt.Errorf("writing local pak manifest: %w", err)
 	}
+	written = true
+
+	if manifest.Disabled {
+		if err := m.setEnabled(ctx, toInstall.ID, false); err != nil {
+			return err
+		}
+	}
+
+	if manifest.Scripts != nil {
+		if err := m.runCommands(ctx, toInstall.ID, "post-install", manifest.Scripts.PostInstall); err != nil {
+			return fmt.Errorf("running post-install commands: %w", err)
+		}
+	}
 
 	return nil
 }
 
-func (m *Manager) downloadFile(ctx context.Context, id string, version string, file string) error {
-	rc, err := m.remote.GetFile(ctx, id, version, file)
+// downloadFile downloads the file from the remote, writing it to the local repository.
+// It returns an error if the size or digest of the file do not match the manifest,
+// and otherwise returns the file with its size and digest set.
+func (m *Manager) downloadFile(ctx context.Context, id string, version string, file File) (File, error) {
+	rc, err := m.remote.GetFile(ctx, id, version, file.Path)
 	if err != nil {
-		return fmt.Errorf("getting remote pak file: %w", err)
+		return file, fmt.Errorf("getting remote pak file: %w", err)
 	}
 
 	defer rc.Close()
 
-	if err := m.local.Write(ctx, id, version, file, rc); err != nil {
-		return fmt.Errorf("writing local pak file: %w", err)
+	d := newDigester()
+	if err := m.local.Write(ctx, id, version, file.Path, io.TeeReader(rc, d)); err != nil {
+		return file, fmt.Errorf("writing local pak file: %w", err)
 	}
 
-	return nil
+	digest := d.digest()
+	if err := file.Check(digest, d.size); err != nil {
+		return file, err
+	}
+
+	file.Size = d.size
+	file.Digest = digest
+	file.Modified = false
+	return file, nil
 }
 
-// Uninstall uninstalls the given paks.
+// Uninstall uninstalls the given paks. Previous versions of the paks kept for
+// rollback are also removed.
+// It returns a DependentsError if any of the paks are required by other installed paks.
 func (m *Manager) Uninstall(ctx context.Context, ids ...string) error {
+	if err := m.checkDependents(ctx, ids); err != nil {
+		return err
+	}
+
+	return m.ForceUninstall(ctx, ids...)
+}
+
+// ForceUninstall uninstalls the given paks, even if they are required by other installed paks.
+func (m *Manager) ForceUninstall(ctx context.Context, ids ...string) error {
 	for _, id := range ids {
 		m.logger.Infof("Uninstalling %s", id)
-		if err := m.uninstall(ctx, id); err != nil {
+		if err := m.removePak(ctx, id); err != nil {
 			return fmt.Errorf("uninstalling pak %s: %w", id, err)
 		}
 	}
@@ -182,7 +476,60 @@ func (m *Manager) Uninstall(ctx context.Context, ids ...string) error {
 	return nil
 }
 
+// removePak uninstalls the pak and removes its previous versions.
+func (m *Manager) removePak(ctx context.Context, id string) error {
+	if err := m.uninstallPak(ctx, id); err != nil {
+		return err
+	}
+
+	if err := m.pruneArchive(ctx, id, 0); err != nil {
+		return fmt.Errorf("removing previous versions: %w", err)
+	}
+
+	return nil
+}
+
+// uninstallPak uninstalls the pak, recording the operation in the history.
+func (m *Manager) uninstallPak(ctx context.Context, id string) error {
+	existing, err := m.local.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("getting local pak manifest: %w", err)
+	}
+
+	if existing == nil {
+		m.logger.Debugf("pak %s not installed", id)
+		return nil
+	}
+
+	err = m.uninstall(ctx, id)
+	m.record(ctx, HistoryEntry{
+		Action:     ActionUninstall,
+		ID:         id,
+		OldVersion: existing.Version,
+	}, err)
+
+	return err
+}
+
+// uninstall runs the pre-uninstall commands of the installed pak and deletes it.
 func (m *Manager) uninstall(ctx context.Context, id string) error {
+	existing, err := m.local.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("getting local pak manifest: %w", err)
+	}
+
+	if existing != nil && existing.Scripts != nil {
+		if err := m.runCommands(ctx, id, "pre-uninstall", existing.Scripts.PreUninstall); err != nil {
+			return fmt.Errorf("running pre-uninstall commands: %w", err)
+		}
+	}
+
+	return m.deletePak(ctx, id)
+}
+
+// deletePak deletes the installed pak from the local repository. It is used
+// directly when the installed version is replaced by another version.
+func (m *Manager) deletePak(ctx context.Context, id string) error {
 	if err := m.local.Delete(ctx, id); err != nil {
 		return fmt.Errorf("deleting local pak: %w", err)
 	}
@@ -191,9 +538,15 @@ func (m *Manager) uninstall(ctx context.Context, id string) error {
 }
 
 // Upgrade upgrades the given paks to the version specified in the spec.
-// If no specs are given then all paks are upgraded to the latest version.
+// If no specs are given then all paks that are not held are upgraded to the latest version.
+// Paks are not downgraded by Upgrade.
+//
+// The new version must satisfy the version constraints of the installed paks
+// that require it. If no version is specified, the newest version satisfying
+// them is used; otherwise a ConstraintError is returned.
 func (m *Manager) Upgrade(ctx context.Context, specs ...InstallSpec) error {
-	if len(specs) == 0 {
+	bulk := len(specs) == 0
+	if bulk {
 		// get all installed paks
 		installed, err := m.local.ListInstalled(ctx)
 		if err != nil {
@@ -201,16 +554,28 @@ func (m *Manager) Upgrade(ctx context.Context, specs ...InstallSpec) error {
 		}
 
 		for _, pak := range installed {
+			if pak.Held {
+				m.logger.Infof("Skipping held pak %s", pak.ID)
+				continue
+			}
+
 			specs = append(specs, InstallSpec{
 				ID: pak.ID,
 			})
 		}
 	}
 
-	const upgrade = true
-
 	for _, spec := range specs {
-		if err := m.install(ctx, spec, upgrade); err != nil {
+		err := m.install(ctx, spec, installOptions{})
+
+		var downgrade DowngradeError
+		var constraintErr ConstraintError
+		if bulk && (errors.As(err, &downgrade) || errors.As(err, &constraintErr)) {
+			m.logger.Infof("Skipping %s: %v", spec.ID, err)
+			continue
+		}
+
+		if err != nil {
 			return fmt.Errorf("upgrading pak %s: %w", spec.ID, err)
 		}
 	}
@@ -219,6 +584,8 @@ func (m *Manager) Upgrade(ctx context.Context, specs ...InstallSpec) error {
 }
 
 // Upgradable returns a list of paks that can be upgraded.
+// The latest version of each pak is the newest version compatible with the host
+// and satisfying the version constraints of the installed paks that require it.
 func (m *Manager) Upgradable(ctx context.Context) ([]UpgradableSpec, error) {
 	// get all installed paks
 	installed, err := m.local.ListInstalled(ctx)
@@ -233,7 +600,31 @@ func (m *Manager) Upgradable(ctx context.Context) ([]UpgradableSpec, error) {
 			return nil, fmt.Errorf("getting latest version: %w", err)
 		}
 
-		if spec.CurrentVersion != pak.Version {
+		if spec == nil {
+			continue
+		}
+
+		channel := m.channel(InstallSpec{}, &pak)
+		latest := latestVersion(spec, channel)
+
+		// the version must satisfy the installed paks that require it
+		reqs := dependentRequirements(installed, pak.ID, nil)
+		if m.hostConfigured() || len(reqs) > 0 {
+			manifest, err := m.resolve(ctx, InstallSpec{ID: pak.ID, Channel: channel}, reqs)
+			var incompatible IncompatibleError
+			var constraintErr ConstraintError
+			if errors.As(err, &incompatible) || errors.As(err, &constraintErr) {
+				// no compatible version
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("resolving latest compatible version: %w", err)
+			}
+
+			latest = manifest.Version
+		}
+
+		if latest != "" && CompareVersions(latest, pak.Version) > 0 {
 			upgradable = append(upgradable, UpgradableSpec{
 				Spec: Spec{
 					ID:          pak.ID,
@@ -242,8 +633,10 @@ func (m *Manager) Upgradable(ctx context.Context) ([]UpgradableSpec, error) {
 					CurrentVersion: pak.Version,
 					Updated:        pak.Date,
 				},
-				LatestVersion: spec.CurrentVersion,
+				LatestVersion: latest,
 				LastUpdated:   spec.Updated,
+				Held:          pak.Held,
+				Channel:       pak.Channel,
 			})
 		}
 	}
@@ -251,7 +644,7 @@ func (m *Manager) Upgradable(ctx context.Context) ([]UpgradableSpec, error) {
 	return upgradable, nil
 }
 
-// List lists all paks in the remote repository.
+// List lists the full spec of every pak in the remote repository.
 func (m *Manager) List(ctx context.Context) (SpecIndex, error) {
 	specs, err := m.remote.List(ctx)
 	if err != nil {
@@ -261,6 +654,17 @@ func (m *Manager) List(ctx context.Context) (SpecIndex, error) {
 	return specs, nil
 }
 
+// ListSummaries lists the summary of every pak in the remote repository,
+// without reading the full spec of each pak if the index is sharded.
+func (m *Manager) ListSummaries(ctx context.Context) (SpecIndex, error) {
+	specs, err := ListSummaries(ctx, m.remote)
+	if err != nil {
+		return nil, fmt.Errorf("listing remote paks: %w", err)
+	}
+
+	return specs, nil
+}
+
 // ListInstalled lists all installed paks in the local repository.
 func (m *Manager) ListInstalled(ctx context.Context) ([]Manifest, error) {
 	installed, err := m.local.ListInstalled(ctx)
@@ -0,0 +1,220 @@
+package pak_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repository/fs"
+	"github.com/WithoutPants/pakman/pkg/repository/memory"
+)
+
+// testLogger logs to the test, and records the logged messages.
+type testLogger struct {
+	t        *testing.T
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+// logged returns true if an info message containing s was logged.
+func (l *testLogger) logged(s string) bool {
+	for _, m := range l.messages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testEnv is a manager installing paks from a memory repository to a
+// file system repository in a temporary directory.
+type testEnv struct {
+	t      *testing.T
+	ctx    context.Context
+	remote *memory.Repository
+	local  *fs.Repository
+	logger *testLogger
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	return &testEnv{
+		t:      t,
+		ctx:    context.Background(),
+		remote: memory.New(),
+		local:  &fs.Repository{BaseDir: t.TempDir()},
+		logger: &testLogger{t: t},
+	}
+}
+
+// manager returns a new manager for the environment. The repositories and
+// logger of the options are set.
+func (e *testEnv) manager(options pak.ManagerOptions) *pak.Manager {
+	options.Local = e.local
+	options.Remote = e.remote
+	options.Logger = e.logger
+	return pak.NewManager(options)
+}
+
+// add adds the version of the pak in the manifest to the remote repository.
+// The contents of each file of the manifest are given in files, keyed by
+// path; the size and digest of each file are set. The current version of the
+// pak is the newest version added.
+func (e *testEnv) add(manifest pak.Manifest, files map[string]string) {
+	e.t.Helper()
+
+	if manifest.Name == "" {
+		manifest.Name = manifest.ID
+	}
+
+	for i, f := range manifest.Files {
+		data, ok := files[f.Path]
+		if !ok {
+			e.t.Fatalf("no contents for %s@%s file %s", manifest.ID, manifest.Version, f.Path)
+		}
+
+		digest, size, err := pak.ComputeDigest(strings.NewReader(data))
+		if err != nil {
+			e.t.Fatal(err)
+		}
+
+		manifest.Files[i].Digest = digest
+		manifest.Files[i].Size = size
+		e.remote.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: manifest.ID, Version: manifest.Version}, File: f.Path}] = []byte(data)
+	}
+
+	e.remote.Manifests[pak.InstallSpec{ID: manifest.ID, Version: manifest.Version}] = manifest
+
+	spec := e.remote.Index[manifest.ID]
+	spec.ID = manifest.ID
+	spec.Name = manifest.Name
+	spec.Versions = append(spec.Versions, pak.VersionInfo{Version: manifest.Version})
+	if spec.CurrentVersion == "" || pak.CompareVersions(manifest.Version, spec.CurrentVersion) > 0 {
+		spec.CurrentVersion = manifest.Version
+	}
+	e.remote.Index[manifest.ID] = spec
+}
+
+// addFiles adds a version of the pak with the given files, keyed by path.
+func (e *testEnv) addFiles(id string, version string, files map[string]string) {
+	e.t.Helper()
+
+	manifest := pak.Manifest{ID: id, Version: version}
+	for path := range files {
+		manifest.Files = append(manifest.Files, pak.File{Path: path})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	e.add(manifest, files)
+}
+
+// path returns the path of the installed file of the pak.
+func (e *testEnv) path(id string, file string) string {
+	if e.local.SharedRoot {
+		return filepath.Join(e.local.BaseDir, filepath.FromSlash(file))
+	}
+
+	return filepath.Join(e.local.BaseDir, id, filepath.FromSlash(file))
+}
+
+// read returns the contents of the installed file of the pak, or an empty
+// string if it does not exist.
+func (e *testEnv) read(id string, file string) string {
+	e.t.Helper()
+
+	data, err := os.ReadFile(e.path(id, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return ""
+	}
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// write replaces the contents of the installed file of the pak.
+func (e *testEnv) write(id string, file string, data string) {
+	e.t.Helper()
+
+	if err := os.WriteFile(e.path(id, file), []byte(data), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// installed returns the installed manifest of the pak, or nil if it is not installed.
+func (e *testEnv) installed(id string) *pak.Manifest {
+	e.t.Helper()
+
+	manifest, err := e.local.GetInstalledManifest(e.ctx, id)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	return manifest
+}
+
+// installedVersions returns the installed version of each installed pak, keyed by ID.
+func (e *testEnv) installedVersions() map[string]string {
+	e.t.Helper()
+
+	installed, err := e.local.ListInstalled(e.ctx)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	ret := make(map[string]string)
+	for _, m := range installed {
+		ret[m.ID] = m.Version
+	}
+
+	return ret
+}
+
+// checkVersions fails the test if the installed paks are not the given versions, keyed by ID.
+func (e *testEnv) checkVersions(want map[string]string) {
+	e.t.Helper()
+
+	if got := e.installedVersions(); !reflect.DeepEqual(got, want) {
+		e.t.Errorf("installed versions = %v, want %v", got, want)
+	}
+}
+
+// recordingExecutor records the commands that it is asked to run.
+type recordingExecutor struct {
+	requests []pak.ExecRequest
+	// err is returned for every command if set.
+	err error
+}
+
+func (e *recordingExecutor) Execute(ctx context.Context, req pak.ExecRequest) (*pak.ExecResult, error) {
+	e.requests = append(e.requests, req)
+	return &pak.ExecResult{}, e.err
+}
+
+// commands returns the first argument of each command run.
+func (e *recordingExecutor) commands() []string {
+	var ret []string
+	for _, r := range e.requests {
+		ret = append(ret, r.Args[0])
+	}
+
+	return ret
+}
@@ -0,0 +1,95 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FileConflictError is returned when installing a pak with a file that is owned by another installed pak.
+type FileConflictError struct {
+	ID    string
+	Path  string
+	Owner string
+}
+
+func (e FileConflictError) Error() string {
+	return fmt.Sprintf("file %q of pak %s is owned by pak %s", e.Path, e.ID, e.Owner)
+}
+
+func (m *Manager) sharesInstallRoot() bool {
+	s, ok := m.local.(SharedInstallRoot)
+	return ok && s.SharesInstallRoot()
+}
+
+// checkFileConflicts returns a FileConflictError if any of the files to be
+// installed from the manifest are owned by another installed pak.
+// Conflicts are only possible if the local repository shares its install root.
+func (m *Manager) checkFileConflicts(ctx context.Context, manifest *Manifest) error {
+	if !m.sharesInstallRoot() {
+		return nil
+	}
+
+	owners, err := m.fileOwners(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range m.selector.Select(manifest.Files) {
+		for _, p := range []string{file.Path, file.Path + NewConfigSuffix} {
+			for _, owner := range owners[cleanPath(p)] {
+				if owner != manifest.ID {
+					return FileConflictError{ID: manifest.ID, Path: file.Path, Owner: owner}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// fileOwners returns a map of file path to the IDs of the installed paks that installed it.
+// Paths are relative to the install root of the local repository.
+func (m *Manager) fileOwners(ctx context.Context) (map[string][]string, error) {
+	installed, err := m.local.ListInstalled(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing local paks: %w", err)
+	}
+
+	shared := m.sharesInstallRoot()
+
+	ret := make(map[string][]string)
+	for _, p := range installed {
+		for _, f := range p.LocalFiles() {
+			if !shared {
+				f = path.Join(p.ID, f)
+			}
+
+			f = cleanPath(f)
+			ret[f] = append(ret[f], p.ID)
+		}
+	}
+
+	return ret, nil
+}
+
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(p, "\\", "/")), "/")
+}
+
+// Owns returns the IDs of the installed paks that installed the file with the given path.
+// The path is relative to the install root of the local repository. If the
+// local repository does not share its install root, the path must be prefixed
+// with the pak ID.
+func (m *Manager) Owns(ctx context.Context, path string) ([]string, error) {
+	owners, err := m.fileOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := owners[cleanPath(path)]
+	sort.Strings(ret)
+	return ret, nil
+}
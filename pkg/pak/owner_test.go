@@ -0,0 +1,88 @@
+package pak_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestFileConflicts(t *testing.T) {
+	e := newTestEnv(t)
+	e.local.SharedRoot = true
+	e.addFiles("a", "1.0.0", map[string]string{"plugins/a.txt": "a", "shared.txt": "a"})
+	e.addFiles("a", "2.0.0", map[string]string{"plugins/a.txt": "a"})
+	e.addFiles("b", "1.0.0", map[string]string{"plugins/b.txt": "b", "shared.txt": "b"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install(a) error = %v", err)
+	}
+
+	err := m.Install(e.ctx, pak.InstallSpec{ID: "b"})
+	var conflict pak.FileConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Install(b) error = %v, want FileConflictError", err)
+	}
+	if want := (pak.FileConflictError{ID: "b", Path: "shared.txt", Owner: "a"}); conflict != want {
+		t.Errorf("Install(b) conflict = %+v, want %+v", conflict, want)
+	}
+
+	// nothing is written when there is a conflict
+	e.checkVersions(map[string]string{"a": "1.0.0"})
+	if got := e.read("a", "shared.txt"); got != "a" {
+		t.Errorf("shared.txt = %q, want the file of a", got)
+	}
+	if got := e.read("b", "plugins/b.txt"); got != "" {
+		t.Errorf("plugins/b.txt = %q, want not written", got)
+	}
+
+	owners, err := m.Owns(e.ctx, "./plugins/../shared.txt")
+	if err != nil {
+		t.Fatalf("Owns() error = %v", err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("Owns(shared.txt) = %v, want %v", owners, want)
+	}
+
+	// once a no longer installs the file, b can be installed
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Install(b) error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "2.0.0", "b": "1.0.0"})
+
+	owners, err = m.Owns(e.ctx, "shared.txt")
+	if err != nil {
+		t.Fatalf("Owns() error = %v", err)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("Owns(shared.txt) = %v, want %v", owners, want)
+	}
+}
+
+// TestFileConflictsSeparateDirs checks that paks installed to their own
+// directories do not conflict, and that owned paths are prefixed with the pak ID.
+func TestFileConflictsSeparateDirs(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"shared.txt": "a"})
+	e.addFiles("b", "1.0.0", map[string]string{"shared.txt": "b"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}, pak.InstallSpec{ID: "b"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		owners, err := m.Owns(e.ctx, id+"/shared.txt")
+		if err != nil {
+			t.Fatalf("Owns() error = %v", err)
+		}
+		if want := []string{id}; !reflect.DeepEqual(owners, want) {
+			t.Errorf("Owns(%s/shared.txt) = %v, want %v", id, owners, want)
+		}
+	}
+}
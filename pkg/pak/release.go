@@ -0,0 +1,117 @@
+package pak
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// VersionInfo describes a version of a pak in the index.
+// A version that is only a version string may be written in the index as a plain string.
+type VersionInfo struct {
+	Version string `yaml:"version" json:"version"`
+
+	// Date is the release date of the version, if known.
+	Date *Time `yaml:"date,omitempty" json:"date,omitempty"`
+	// Size is the total size of the files of the version in bytes, if known.
+	Size int64 `yaml:"size,omitempty" json:"size,omitempty"`
+	// Notes are the release notes of the version.
+	Notes string `yaml:"notes,omitempty" json:"notes,omitempty"`
+
+	// Deprecated is a message explaining why the version is deprecated.
+	// If empty, the version is not deprecated. Deprecated versions may still be installed.
+	Deprecated string `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	// Yanked is true if the version has been withdrawn. Yanked versions are
+	// only installed if the version is explicitly requested.
+	Yanked bool `yaml:"yanked,omitempty" json:"yanked,omitempty"`
+}
+
+// String returns the version string.
+func (v VersionInfo) String() string {
+	return v.Version
+}
+
+// isPlain returns true if the version has no fields other than the version string.
+func (v VersionInfo) isPlain() bool {
+	return v.Date == nil && v.Size == 0 && v.Notes == "" && v.Deprecated == "" && !v.Yanked
+}
+
+func (v *VersionInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var version string
+	if err := unmarshal(&version); err == nil {
+		*v = VersionInfo{Version: version}
+		return nil
+	}
+
+	// alias the type to avoid recursing into this method
+	type versionInfo VersionInfo
+	var vv versionInfo
+	if err := unmarshal(&vv); err != nil {
+		return err
+	}
+
+	*v = VersionInfo(vv)
+	return nil
+}
+
+func (v VersionInfo) MarshalYAML() (interface{}, error) {
+	if v.isPlain() {
+		return v.Version, nil
+	}
+
+	type versionInfo VersionInfo
+	return versionInfo(v), nil
+}
+
+func (v *VersionInfo) UnmarshalJSON(data []byte) error {
+	var version string
+	if err := json.Unmarshal(data, &version); err == nil {
+		*v = VersionInfo{Version: version}
+		return nil
+	}
+
+	type versionInfo VersionInfo
+	var vv versionInfo
+	if err := json.Unmarshal(data, &vv); err != nil {
+		return err
+	}
+
+	*v = VersionInfo(vv)
+	return nil
+}
+
+func (v VersionInfo) MarshalJSON() ([]byte, error) {
+	if v.isPlain() {
+		return json.Marshal(v.Version)
+	}
+
+	type versionInfo VersionInfo
+	return json.Marshal(versionInfo(v))
+}
+
+// YankedError is returned when the only versions of a pak that could be installed have been yanked.
+type YankedError struct {
+	ID      string
+	Version string
+}
+
+func (e YankedError) Error() string {
+	return fmt.Sprintf("%s@%s has been yanked", e.ID, e.Version)
+}
+
+// FindVersion returns the VersionInfo of the given version of the spec.
+// It returns false if the version is not listed in the spec.
+func (s Spec) FindVersion(version string) (VersionInfo, bool) {
+	for _, v := range s.Versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+
+	return VersionInfo{}, false
+}
+
+// IsYanked returns true if the given version of the spec has been yanked.
+func (s Spec) IsYanked(version string) bool {
+	v, _ := s.FindVersion(version)
+	return v.Yanked
+}
@@ -0,0 +1,139 @@
+package pak_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"gopkg.in/yaml.v3"
+)
+
+// setVersionInfo replaces the info of a version in the spec of the pak in the remote repository.
+func (e *testEnv) setVersionInfo(id string, info pak.VersionInfo) {
+	e.t.Helper()
+
+	spec := e.remote.Index[id]
+	for i, v := range spec.Versions {
+		if v.Version == info.Version {
+			spec.Versions[i] = info
+			e.remote.Index[id] = spec
+			return
+		}
+	}
+
+	e.t.Fatalf("%s@%s is not in the spec", id, info.Version)
+}
+
+func TestYankedVersions(t *testing.T) {
+	e := newTestEnv(t)
+	for _, v := range []string{"1.0.0", "1.1.0", "2.0.0"} {
+		e.addFiles("a", v, map[string]string{"plugin.txt": v})
+	}
+
+	e.setVersionInfo("a", pak.VersionInfo{Version: "2.0.0", Yanked: true})
+
+	m := e.manager(pak.ManagerOptions{})
+
+	// the newest version that is not yanked is installed
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.1.0"})
+
+	upgradable, err := m.Upgradable(e.ctx)
+	if err != nil {
+		t.Fatalf("Upgradable() error = %v", err)
+	}
+	if len(upgradable) != 0 {
+		t.Errorf("Upgradable() = %+v, want the yanked version not offered", upgradable)
+	}
+
+	// a yanked version may be installed explicitly, with a warning
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Install(a@2.0.0) error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "2.0.0"})
+	if !e.logger.logged("Warning: a@2.0.0 has been yanked") {
+		t.Errorf("installing a yanked version was not warned about")
+	}
+}
+
+func TestAllVersionsYanked(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.setVersionInfo("a", pak.VersionInfo{Version: "1.0.0", Yanked: true})
+
+	m := e.manager(pak.ManagerOptions{})
+
+	err := m.Install(e.ctx, pak.InstallSpec{ID: "a"})
+	var yanked pak.YankedError
+	if !errors.As(err, &yanked) || yanked.Version != "1.0.0" {
+		t.Errorf("Install() error = %v, want YankedError for 1.0.0", err)
+	}
+	e.checkVersions(map[string]string{})
+}
+
+func TestDeprecatedVersion(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.setVersionInfo("a", pak.VersionInfo{Version: "1.0.0", Deprecated: "use b instead"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	// deprecated versions are installed, with a warning
+	e.checkVersions(map[string]string{"a": "1.0.0"})
+	if !e.logger.logged("Warning: a@1.0.0 is deprecated: use b instead") {
+		t.Errorf("installing a deprecated version was not warned about")
+	}
+}
+
+func TestVersionInfoEncoding(t *testing.T) {
+	date, err := pak.ParseTime("2024-03-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []pak.VersionInfo{
+		{Version: "1.0.0"},
+		{Version: "1.1.0", Date: &date, Size: 10, Notes: "notes", Deprecated: "old", Yanked: true},
+	}
+
+	wantYAML := "- 1.0.0\n- version: 1.1.0\n  date: \"2024-03-01\"\n  size: 10\n  notes: notes\n  deprecated: old\n  yanked: true\n"
+	gotYAML, err := yaml.Marshal(versions)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	if string(gotYAML) != wantYAML {
+		t.Errorf("yaml = %q, want %q", gotYAML, wantYAML)
+	}
+
+	var fromYAML []pak.VersionInfo
+	if err := yaml.Unmarshal(gotYAML, &fromYAML); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, versions) {
+		t.Errorf("yaml decoded as %+v, want %+v", fromYAML, versions)
+	}
+
+	wantJSON := `["1.0.0",{"version":"1.1.0","date":"2024-03-01","size":10,"notes":"notes","deprecated":"old","yanked":true}]`
+	gotJSON, err := json.Marshal(versions)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if string(gotJSON) != wantJSON {
+		t.Errorf("json = %s, want %s", gotJSON, wantJSON)
+	}
+
+	var fromJSON []pak.VersionInfo
+	if err := json.Unmarshal(gotJSON, &fromJSON); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, versions) {
+		t.Errorf("json decoded as %+v, want %+v", fromJSON, versions)
+	}
+}
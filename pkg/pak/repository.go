@@ -5,6 +5,25 @@ import (
 	"io"
 )
 
+// The layout of a source repository. Files are named without an extension,
+// which is that of the codec that the file is written with, for example index.yml:
+//
+//	index
+//	changes/<revision>
+//	<id>/spec
+//	<id>/<version>/manifest
+//	<id>/<version>/<file>
+const (
+	// IndexName is the name of the index file.
+	IndexName = "index"
+	// SpecName is the name of the spec file of a pak in a sharded repository.
+	SpecName = "spec"
+	// ManifestName is the name of the manifest file of a pak version.
+	ManifestName = "manifest"
+	// ChangesDir is the directory containing the changes to the index since each kept revision.
+	ChangesDir = "changes"
+)
+
 // SourceRepository is a repository that can be used to get paks from.
 type SourceRepository interface {
 	ManifestGetter
@@ -37,10 +56,40 @@ type SpecGetter interface {
 	// GetSpec gets the spec for the given id.
 	GetSpec(ctx context.Context, id string) (*Spec, error)
 
-	// List returns all specs in the repository.
+	// List returns the full spec of every pak in the repository. If the
+	// repository has a sharded index, the spec of each pak is read. Use
+	// ListSummaries if only the summaries of the paks are needed.
 	List(ctx context.Context) (SpecIndex, error)
 }
 
+// SummaryLister is implemented by source repositories that can list their paks
+// without reading the full spec of each, such as repositories with a sharded index.
+type SummaryLister interface {
+	// ListSummaries returns the summary of every pak in the repository. See Spec.Summary.
+	ListSummaries(ctx context.Context) (SpecIndex, error)
+}
+
+// ListSummaries returns the summary of every pak in the repository. If the
+// repository does not implement SummaryLister, the summaries of the specs
+// returned by List are returned.
+func ListSummaries(ctx context.Context, r SpecGetter) (SpecIndex, error) {
+	if l, ok := r.(SummaryLister); ok {
+		return l.ListSummaries(ctx)
+	}
+
+	specs, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(SpecIndex, len(specs))
+	for id, spec := range specs {
+		ret[id] = spec.Summary()
+	}
+
+	return ret, nil
+}
+
 type ManifestGetter interface {
 	// GetManifest gets the manifest for the given id and version.
 	// If version is empty then the latest version is returned.
@@ -59,3 +108,58 @@ type InstalledLister interface {
 type FileGetter interface {
 	GetFile(ctx context.Context, id string, version string, file string) (io.ReadCloser, error)
 }
+
+// InstallDirGetter is implemented by local repositories that store paks on the file system.
+type InstallDirGetter interface {
+	// InstallDir returns the directory that the pak with the given id is installed in.
+	InstallDir(ctx context.Context, id string) (string, error)
+}
+
+// EnabledSetter is implemented by local repositories that store disabled paks separately.
+type EnabledSetter interface {
+	// SetEnabled enables or disables the installed pak with the given id.
+	SetEnabled(ctx context.Context, id string, enabled bool) error
+}
+
+// Archiver is implemented by local repositories that can keep previously installed versions of paks.
+type Archiver interface {
+	// Archive keeps a copy of the installed version of the pak with the given id.
+	Archive(ctx context.Context, id string) error
+
+	// ListArchived returns the manifests of the archived versions of the pak
+	// with the given id, most recently archived first.
+	ListArchived(ctx context.Context, id string) ([]Manifest, error)
+
+	// Restore installs the archived version of the pak with the given id,
+	// removing it from the archive. The pak must not be installed.
+	Restore(ctx context.Context, id string, version string) error
+
+	// DeleteArchived removes the archived version of the pak with the given id.
+	DeleteArchived(ctx context.Context, id string, version string) error
+}
+
+// HistoryRecorder is implemented by local repositories that keep a history of operations.
+type HistoryRecorder interface {
+	// AppendHistory appends the entry to the history.
+	AppendHistory(ctx context.Context, entry HistoryEntry) error
+	// History returns all history entries, oldest first.
+	History(ctx context.Context) ([]HistoryEntry, error)
+}
+
+// InstalledFileGetter is implemented by local repositories that can read installed pak files.
+type InstalledFileGetter interface {
+	// GetInstalledFile opens the installed file of the pak with the given id.
+	// It returns an error satisfying errors.Is(err, fs.ErrNotExist) if the file does not exist.
+	GetInstalledFile(ctx context.Context, id string, file string) (io.ReadCloser, error)
+
+	// ListInstalledFiles returns the paths of all files in the directory of the
+	// pak with the given id, excluding the manifest. Paths use forward slashes.
+	ListInstalledFiles(ctx context.Context, id string) ([]string, error)
+}
+
+// SharedInstallRoot is implemented by local repositories that may store the files of
+// multiple paks in the same directory.
+type SharedInstallRoot interface {
+	// SharesInstallRoot returns true if the files of all paks are stored in the same directory.
+	SharesInstallRoot() bool
+}
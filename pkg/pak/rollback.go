@@ -0,0 +1,141 @@
+package pak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoPreviousVersion is returned when rolling back a pak that has no previous version kept.
+var ErrNoPreviousVersion = errors.New("no previous version available")
+
+// DowngradeError is returned when installing a version older than the installed version
+// without explicitly downgrading.
+type DowngradeError struct {
+	ID   string
+	From string
+	To   string
+}
+
+func (e DowngradeError) Error() string {
+	return fmt.Sprintf("installing %s@%s would downgrade from %s", e.ID, e.To, e.From)
+}
+
+// Rollback replaces the installed version of the pak with the given id with
+// the most recently replaced version, kept in the local repository. No remote
+// access is required. The replaced version is itself kept, so that a
+// subsequent rollback restores it.
+//
+// Previous versions are only kept if KeepVersions is set and the local
+// repository implements Archiver.
+func (m *Manager) Rollback(ctx context.Context, id string) (_ *Manifest, err error) {
+	archiver, ok := m.local.(Archiver)
+	if !ok {
+		return nil, ErrNoPreviousVersion
+	}
+
+	archived, err := archiver.ListArchived(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("listing previous versions: %w", err)
+	}
+
+	if len(archived) == 0 {
+		return nil, ErrNoPreviousVersion
+	}
+
+	previous := archived[0]
+	previous.Archived = nil
+
+	existing, err := m.local.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting local pak manifest: %w", err)
+	}
+
+	entry := HistoryEntry{
+		Action:     ActionRollback,
+		ID:         id,
+		NewVersion: previous.Version,
+	}
+	if existing != nil {
+		entry.OldVersion = existing.Version
+	}
+
+	defer func() {
+		m.record(ctx, entry, err)
+	}()
+
+	if existing != nil {
+		m.logger.Infof("Rolling back %s from %s to %s", id, existing.Version, previous.Version)
+
+		// keep the existing version, pruning after the previous version is restored
+		if m.keepVersions > 0 {
+			if err := archiver.Archive(ctx, id); err != nil {
+				return nil, fmt.Errorf("keeping existing version: %w", err)
+			}
+		}
+
+		if err := m.deletePak(ctx, id); err != nil {
+			return nil, fmt.Errorf("uninstalling existing version: %w", err)
+		}
+	} else {
+		m.logger.Infof("Restoring %s@%s", id, previous.Version)
+	}
+
+	if err := archiver.Restore(ctx, id, previous.Version); err != nil {
+		return nil, fmt.Errorf("restoring %s@%s: %w", id, previous.Version, err)
+	}
+
+	if err := m.pruneArchive(ctx, id, m.keepVersions); err != nil {
+		return nil, err
+	}
+
+	if previous.Disabled {
+		if err := m.setEnabled(ctx, id, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if previous.Scripts != nil {
+		if err := m.runCommands(ctx, id, "post-install", previous.Scripts.PostInstall); err != nil {
+			return nil, fmt.Errorf("running post-install commands: %w", err)
+		}
+	}
+
+	return &previous, nil
+}
+
+// archive keeps the installed version of the pak, if configured to do so.
+func (m *Manager) archive(ctx context.Context, id string) error {
+	archiver, ok := m.local.(Archiver)
+	if !ok || m.keepVersions <= 0 {
+		return nil
+	}
+
+	if err := archiver.Archive(ctx, id); err != nil {
+		return fmt.Errorf("keeping existing version: %w", err)
+	}
+
+	return m.pruneArchive(ctx, id, m.keepVersions)
+}
+
+// pruneArchive removes all but the most recent keep archived versions of the pak.
+func (m *Manager) pruneArchive(ctx context.Context, id string, keep int) error {
+	archiver, ok := m.local.(Archiver)
+	if !ok {
+		return nil
+	}
+
+	archived, err := archiver.ListArchived(ctx, id)
+	if err != nil {
+		return fmt.Errorf("listing previous versions: %w", err)
+	}
+
+	for i := keep; i < len(archived); i++ {
+		m.logger.Debugf("removing previous version %s@%s", id, archived[i].Version)
+		if err := archiver.DeleteArchived(ctx, id, archived[i].Version); err != nil {
+			return fmt.Errorf("removing previous version %s: %w", archived[i].Version, err)
+		}
+	}
+
+	return nil
+}
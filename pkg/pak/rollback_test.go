@@ -0,0 +1,155 @@
+package pak_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+// archivedVersions returns the archived versions of the pak, most recently archived first.
+func (e *testEnv) archivedVersions(id string) []string {
+	e.t.Helper()
+
+	archived, err := e.local.ListArchived(e.ctx, id)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	var ret []string
+	for _, m := range archived {
+		ret = append(ret, m.Version)
+	}
+
+	return ret
+}
+
+func TestRollback(t *testing.T) {
+	e := newTestEnv(t)
+	for _, v := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		e.addFiles("a", v, map[string]string{"plugin.txt": v})
+	}
+
+	m := e.manager(pak.ManagerOptions{KeepVersions: 2})
+
+	if _, err := m.Rollback(e.ctx, "a"); !errors.Is(err, pak.ErrNoPreviousVersion) {
+		t.Errorf("Rollback() of a pak without previous versions error = %v, want ErrNoPreviousVersion", err)
+	}
+
+	for _, v := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: v}); err != nil {
+			t.Fatalf("Install(a@%s) error = %v", v, err)
+		}
+	}
+
+	if got, want := e.archivedVersions("a"), []string{"2.0.0", "1.0.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived versions = %v, want %v", got, want)
+	}
+
+	// the previous version is restored, and the replaced version is kept
+	previous, err := m.Rollback(e.ctx, "a")
+	if err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if previous.Version != "2.0.0" || previous.Archived != nil {
+		t.Errorf("Rollback() = %+v, want version 2.0.0 without an archive time", previous)
+	}
+
+	e.checkVersions(map[string]string{"a": "2.0.0"})
+	if got := e.read("a", "plugin.txt"); got != "2.0.0" {
+		t.Errorf("plugin.txt = %q, want the restored contents", got)
+	}
+	if manifest := e.installed("a"); manifest.Archived != nil {
+		t.Errorf("restored manifest archived = %v, want not set", manifest.Archived)
+	}
+	if got, want := e.archivedVersions("a"), []string{"3.0.0", "1.0.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived versions = %v, want %v", got, want)
+	}
+
+	// rolling back again undoes the rollback
+	if _, err := m.Rollback(e.ctx, "a"); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "3.0.0"})
+	if got, want := e.archivedVersions("a"), []string{"2.0.0", "1.0.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived versions = %v, want %v", got, want)
+	}
+}
+
+// TestRollbackArchiveOrder checks that the most recently archived version is
+// restored, even if it is older and the archives have the same modification time.
+func TestRollbackArchiveOrder(t *testing.T) {
+	e := newTestEnv(t)
+	for _, v := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		e.addFiles("a", v, map[string]string{"plugin.txt": v})
+	}
+
+	m := e.manager(pak.ManagerOptions{KeepVersions: 2})
+
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := m.Downgrade(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Downgrade() error = %v", err)
+	}
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "3.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		path := filepath.Join(e.local.BaseDir, fs.StateDir, fs.ArchiveDir, "a", v, fs.ManifestPath)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := e.archivedVersions("a"), []string{"1.0.0", "2.0.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived versions = %v, want %v", got, want)
+	}
+
+	if _, err := m.Rollback(e.ctx, "a"); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.0.0"})
+}
+
+func TestDowngrade(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.addFiles("a", "2.0.0", map[string]string{"plugin.txt": "2.0.0"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"})
+	var downgrade pak.DowngradeError
+	if !errors.As(err, &downgrade) {
+		t.Errorf("Install() of an older version error = %v, want DowngradeError", err)
+	}
+	e.checkVersions(map[string]string{"a": "2.0.0"})
+
+	if err := m.Downgrade(e.ctx, pak.InstallSpec{ID: "a"}); !errors.Is(err, pak.ErrInvalidInstallSpec) {
+		t.Errorf("Downgrade() without a version error = %v, want ErrInvalidInstallSpec", err)
+	}
+
+	if err := m.Downgrade(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Downgrade() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.0.0"})
+	if got := e.read("a", "plugin.txt"); got != "1.0.0" {
+		t.Errorf("plugin.txt = %q, want the older contents", got)
+	}
+
+	// no versions are kept by default
+	if got := e.archivedVersions("a"); len(got) != 0 {
+		t.Errorf("archived versions = %v, want none", got)
+	}
+}
@@ -1,13 +1,94 @@
 package pak
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const timeFormat = "2006-01-02 15:04:05 -0700"
+const (
+	timeFormat = "2006-01-02 15:04:05 -0700"
+	dateFormat = "2006-01-02"
+	// unixFormat indicates that a Time was parsed from a Unix timestamp.
+	unixFormat = "unix"
+)
+
+// timeLayouts are the layouts accepted by ParseTime, in the order they are tried.
+var timeLayouts = []string{timeFormat, time.RFC3339, time.RFC3339Nano, dateFormat}
+
+// FormatVersion is the current version of the index and manifest file formats.
+// Files without a format version are version 1. Version 2 changed the index
+// to a mapping with the format version and paks, and version 3 added sharded indexes.
+//
+// Unknown fields are rejected when reading files. Files are written with the
+// oldest format version that can represent them, so that they can be read by
+// older clients.
+const FormatVersion = 3
+
+// ManifestFormatVersion is the format version that manifests are written with.
+// Manifests have not changed since version 2.
+const ManifestFormatVersion = 2
+
+// UnsupportedFormatError is returned when a file has a newer format version than is supported.
+type UnsupportedFormatError struct {
+	Version int
+}
+
+func (e UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("unsupported format version %d (newest supported version is %d)", e.Version, FormatVersion)
+}
 
+// Time is a timestamp in a pakman file. It is written in the format that it
+// was read in. If it was not read from a file, it is written in the format
+// "2006-01-02 15:04:05 -0700".
 type Time struct {
 	time.Time
+
+	// layout is the layout that the time was parsed with.
+	layout string
+}
+
+// ParseTime parses a timestamp in any of the supported formats: "2006-01-02 15:04:05 -0700",
+// RFC 3339 with or without fractional seconds, a date in the form "2006-01-02", or a
+// Unix timestamp in seconds.
+func ParseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range timeLayouts {
+		parsed, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+
+		// RFC 3339 parsing accepts fractional seconds, but does not write them
+		if layout == time.RFC3339 && parsed.Format(layout) != s {
+			layout = time.RFC3339Nano
+		}
+
+		return Time{Time: parsed, layout: layout}, nil
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Time{Time: time.Unix(secs, 0).UTC(), layout: unixFormat}, nil
+	}
+
+	return Time{}, fmt.Errorf("invalid time %q: expected RFC 3339, a date (YYYY-MM-DD), a Unix timestamp or %q", s, timeFormat)
+}
+
+// String returns the time in the format that it was read in.
+func (t Time) String() string {
+	switch t.layout {
+	case "":
+		return t.Format(timeFormat)
+	case unixFormat:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+
+	return t.Format(t.layout)
 }
 
 func (t *Time) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -15,42 +96,297 @@ func (t *Time) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&s); err != nil {
 		return err
 	}
-	parsed, err := time.Parse(timeFormat, s)
+	parsed, err := ParseTime(s)
 	if err != nil {
 		return err
 	}
-	t.Time = parsed
+	*t = parsed
 	return nil
 }
 
 func (t Time) MarshalYAML() (interface{}, error) {
-	return t.Format(timeFormat), nil
+	if t.layout == unixFormat {
+		return t.Unix(), nil
+	}
+	return t.String(), nil
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// may be a Unix timestamp
+		var n json.Number
+		if json.Unmarshal(data, &n) != nil {
+			return err
+		}
+		s = n.String()
+	}
+	parsed, err := ParseTime(s)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.layout == unixFormat {
+		return json.Marshal(t.Unix())
+	}
+	return json.Marshal(t.String())
 }
 
 // Spec is a pak specification.
 type Spec struct {
-	ID             string   `yaml:"id"`
-	Name           string   `yaml:"name"`
-	Description    string   `yaml:"description"`
-	CurrentVersion string   `yaml:"currentVersion"`
-	Updated        Time     `yaml:"updated"`
-	Versions       []string `yaml:"versions"`
+	ID             string        `yaml:"id" json:"id"`
+	Name           string        `yaml:"name" json:"name"`
+	Description    string        `yaml:"description" json:"description"`
+	CurrentVersion string        `yaml:"currentVersion" json:"currentVersion"`
+	Updated        Time          `yaml:"updated" json:"updated"`
+	Versions       []VersionInfo `yaml:"versions" json:"versions"`
+
+	// Channels maps release channel names, such as "beta", to the version
+	// that the channel points to. CurrentVersion is the version of the default channel.
+	Channels map[string]string `yaml:"channels,omitempty" json:"channels,omitempty"`
+
+	// Authors are the authors of the pak, optionally in the form "Name <email>".
+	Authors []string `yaml:"authors,omitempty" json:"authors,omitempty"`
+	// License is the SPDX license expression of the pak.
+	License string `yaml:"license,omitempty" json:"license,omitempty"`
+	// Homepage is the URL of the pak's homepage.
+	Homepage string `yaml:"homepage,omitempty" json:"homepage,omitempty"`
+	// Source is the URL of the pak's source repository.
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Tags are the tags or categories of the pak.
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// Icon is the URL of the pak's icon. It may be relative to the pak's
+	// directory in the repository.
+	Icon string `yaml:"icon,omitempty" json:"icon,omitempty"`
+	// Screenshots are the URLs of screenshots of the pak. They may be relative
+	// to the pak's directory in the repository.
+	Screenshots []string `yaml:"screenshots,omitempty" json:"screenshots,omitempty"`
+
+	// Host describes the host application that the pak is compatible with.
+	// It may be overridden by the manifest of each version.
+	Host *HostRequirements `yaml:"host,omitempty" json:"host,omitempty"`
+}
+
+// HasTag returns true if the spec has the given tag, ignoring case.
+func (s Spec) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type UpgradableSpec struct {
 	Spec
-	LatestVersion string `yaml:"latestVersion"`
-	LastUpdated   Time   `yaml:"lastUpdated"`
+	LatestVersion string `yaml:"latestVersion" json:"latestVersion"`
+	LastUpdated   Time   `yaml:"lastUpdated" json:"lastUpdated"`
+
+	// Held is true if the installed pak is held, and will not be upgraded by a bulk upgrade.
+	Held bool `yaml:"held,omitempty" json:"held,omitempty"`
+
+	// Channel is the release channel followed by the installed pak.
+	Channel string `yaml:"channel,omitempty" json:"channel,omitempty"`
 }
 
 // SpecIndex is a map of pak ID to Spec
 type SpecIndex map[string]Spec
 
+// InvalidSpecError is returned when the spec of a pak in an index could not be read.
+type InvalidSpecError struct {
+	ID  string
+	Err error
+}
+
+func (e InvalidSpecError) Error() string {
+	return fmt.Sprintf("invalid spec for pak %q: %v", e.ID, e.Err)
+}
+
+func (e InvalidSpecError) Unwrap() error {
+	return e.Err
+}
+
+// IndexError is returned when reading an index that contains invalid specs.
+// The invalid specs are omitted from the index that is returned with it,
+// which may otherwise be used as normal.
+type IndexError struct {
+	Invalid []InvalidSpecError
+}
+
+// NewIndexError returns an IndexError for the given errors keyed by pak ID,
+// or nil if there are none.
+func NewIndexError(errs map[string]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	ret := IndexError{}
+	for id, err := range errs {
+		ret.Invalid = append(ret.Invalid, InvalidSpecError{ID: id, Err: err})
+	}
+
+	sort.Slice(ret.Invalid, func(i, j int) bool {
+		return ret.Invalid[i].ID < ret.Invalid[j].ID
+	})
+
+	return ret
+}
+
+func (e IndexError) Error() string {
+	if len(e.Invalid) == 1 {
+		return e.Invalid[0].Error()
+	}
+
+	ids := make([]string, len(e.Invalid))
+	for i, invalid := range e.Invalid {
+		ids[i] = fmt.Sprintf("%q", invalid.ID)
+	}
+
+	return fmt.Sprintf("invalid specs for paks %s", strings.Join(ids, ", "))
+}
+
+// Spec returns the InvalidSpecError for the pak with the given ID, or nil if
+// the pak's spec was not invalid.
+func (e IndexError) Spec(id string) error {
+	for _, invalid := range e.Invalid {
+		if invalid.ID == id {
+			return invalid
+		}
+	}
+
+	return nil
+}
+
+// SplitIndexError returns the IndexError wrapped by err, if any, and any other error.
+// It is used by repositories to treat invalid specs in an index as non-fatal.
+func SplitIndexError(err error) (*IndexError, error) {
+	var indexErr IndexError
+	if errors.As(err, &indexErr) {
+		return &indexErr, nil
+	}
+
+	return nil, err
+}
+
 // Manifest is a pak manifest. It contains the list of files in the pak.
 type Manifest struct {
-	ID      string   `yaml:"id"`
-	Name    string   `yaml:"name"`
-	Version string   `yaml:"version"`
-	Date    Time     `yaml:"date"`
-	Files   []string `yaml:"files"`
+	// FormatVersion is the version of the manifest file format.
+	// It is set to FormatVersion when the manifest is read.
+	FormatVersion int `yaml:"formatVersion,omitempty" json:"formatVersion,omitempty"`
+
+	ID      string `yaml:"id" json:"id"`
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	Date    Time   `yaml:"date" json:"date"`
+	Files   []File `yaml:"files" json:"files"`
+
+	// Changelog describes the changes in this version of the pak.
+	Changelog string `yaml:"changelog,omitempty" json:"changelog,omitempty"`
+
+	// InstalledFiles is the list of files that were installed from Files.
+	// It is only set in installed manifests, and is omitted from remote
+	// manifests. If nil, all files are assumed to have been installed, while
+	// an empty list means that no files were installed. The codecs write an
+	// empty list, so that it is read back as empty rather than nil.
+	InstalledFiles []string `yaml:"installedFiles,omitempty" json:"installedFiles,omitempty"`
+
+	// Disabled is true if the installed pak has been disabled.
+	// It is only set in installed manifests.
+	Disabled bool `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+
+	// Held is true if the installed pak is excluded from bulk upgrades.
+	// It is only set in installed manifests.
+	Held bool `yaml:"held,omitempty" json:"held,omitempty"`
+
+	// AutoInstalled is true if the pak was installed as a dependency of
+	// another pak, rather than explicitly. It is only set in installed manifests.
+	AutoInstalled bool `yaml:"autoInstalled,omitempty" json:"autoInstalled,omitempty"`
+
+	// Channel is the release channel that the installed pak follows.
+	// If empty, the default channel is followed. It is only set in installed manifests.
+	Channel string `yaml:"channel,omitempty" json:"channel,omitempty"`
+
+	// Archived is the time that the installed version was replaced and kept
+	// so that it can be rolled back to. It is only set in archived manifests.
+	Archived *Time `yaml:"archived,omitempty" json:"archived,omitempty"`
+
+	// Host describes the host application that this version of the pak is compatible with.
+	Host *HostRequirements `yaml:"host,omitempty" json:"host,omitempty"`
+
+	// Requires lists the paks that this version of the pak depends on.
+	Requires []Dependency `yaml:"requires,omitempty" json:"requires,omitempty"`
+
+	// Scripts are commands to run at points in the pak lifecycle.
+	// They are only run if the Manager is configured to allow it.
+	Scripts *Scripts `yaml:"scripts,omitempty" json:"scripts,omitempty"`
+}
+
+// LocalFiles returns the paths of the files installed by the manifest.
+func (m Manifest) LocalFiles() []string {
+	if m.InstalledFiles != nil {
+		return m.InstalledFiles
+	}
+
+	ret := make([]string, len(m.Files))
+	for i, f := range m.Files {
+		ret[i] = f.Path
+	}
+	return ret
+}
+
+// Scripts are the commands declared by a pak to be run by the Manager.
+type Scripts struct {
+	// PostInstall commands are run after the pak files and manifest are written.
+	PostInstall []Command `yaml:"postInstall,omitempty" json:"postInstall,omitempty"`
+	// PreUninstall commands are run before the pak files are removed when the
+	// pak is uninstalled. They are not run when the installed version is
+	// replaced by an upgrade, downgrade or rollback.
+	PreUninstall []Command `yaml:"preUninstall,omitempty" json:"preUninstall,omitempty"`
+}
+
+// Command is a command declared by a pak.
+type Command struct {
+	// Run is the program followed by its arguments. It is not interpreted by a shell.
+	Run []string `yaml:"run" json:"run"`
+	// Timeout is the maximum duration of the command. If zero, DefaultCommandTimeout is used.
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// commandJSON is the JSON representation of a Command.
+// The timeout is written as a duration string, as it is in yaml.
+type commandJSON struct {
+	Run     []string `json:"run"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var cc commandJSON
+	if err := json.Unmarshal(data, &cc); err != nil {
+		return err
+	}
+
+	*c = Command{Run: cc.Run}
+	if cc.Timeout != "" {
+		timeout, err := time.ParseDuration(cc.Timeout)
+		if err != nil {
+			return err
+		}
+		c.Timeout = timeout
+	}
+
+	return nil
+}
+
+func (c Command) MarshalJSON() ([]byte, error) {
+	cc := commandJSON{Run: c.Run}
+	if c.Timeout != 0 {
+		cc.Timeout = c.Timeout.String()
+	}
+
+	return json.Marshal(cc)
 }
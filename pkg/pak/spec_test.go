@@ -0,0 +1,183 @@
+package pak
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Time
+		written string
+		wantErr bool
+	}{
+		{
+			s:       "2024-03-01 10:30:00 +0100",
+			want:    time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+			written: "2024-03-01 10:30:00 +0100",
+		},
+		{
+			s:       "2024-03-01T10:30:00Z",
+			want:    time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC),
+			written: "2024-03-01T10:30:00Z",
+		},
+		{
+			s:       "2024-03-01T10:30:00.25+02:00",
+			want:    time.Date(2024, 3, 1, 8, 30, 0, 250000000, time.UTC),
+			written: "2024-03-01T10:30:00.25+02:00",
+		},
+		{
+			s:       "2024-03-01",
+			want:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			written: "2024-03-01",
+		},
+		{
+			s:       "1709289000",
+			want:    time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC),
+			written: "1709289000",
+		},
+		{
+			s:       " 2024-03-01 ",
+			want:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			written: "2024-03-01",
+		},
+		{s: "", wantErr: true},
+		{s: "yesterday", wantErr: true},
+		{s: "2024-13-01", wantErr: true},
+		{s: "01/03/2024", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTime(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.s, got.Time, tt.want)
+		}
+
+		// times are written in the format that they were read in
+		if s := got.String(); s != tt.written {
+			t.Errorf("ParseTime(%q).String() = %q, want %q", tt.s, s, tt.written)
+		}
+	}
+}
+
+func TestTimeDefaultFormat(t *testing.T) {
+	tm := Time{Time: time.Date(2024, 3, 1, 10, 30, 0, 0, time.FixedZone("", 3600))}
+	if got, want := tm.String(), "2024-03-01 10:30:00 +0100"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestTimeEncoding(t *testing.T) {
+	type doc struct {
+		Time Time `yaml:"time" json:"time"`
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		json string
+	}{
+		{name: "default", yaml: "time: 2024-03-01 10:30:00 +0100\n", json: `{"time":"2024-03-01 10:30:00 +0100"}`},
+		{name: "rfc 3339", yaml: "time: \"2024-03-01T10:30:00Z\"\n", json: `{"time":"2024-03-01T10:30:00Z"}`},
+		{name: "date", yaml: "time: \"2024-03-01\"\n", json: `{"time":"2024-03-01"}`},
+		{name: "unix", yaml: "time: 1709289000\n", json: `{"time":1709289000}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromYAML doc
+			if err := yaml.Unmarshal([]byte(tt.yaml), &fromYAML); err != nil {
+				t.Fatalf("yaml.Unmarshal() error = %v", err)
+			}
+
+			written, err := yaml.Marshal(fromYAML)
+			if err != nil {
+				t.Fatalf("yaml.Marshal() error = %v", err)
+			}
+			if string(written) != tt.yaml {
+				t.Errorf("yaml written as %q, want %q", written, tt.yaml)
+			}
+
+			var fromJSON doc
+			if err := json.Unmarshal([]byte(tt.json), &fromJSON); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+
+			written, err = json.Marshal(fromJSON)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(written) != tt.json {
+				t.Errorf("json written as %s, want %s", written, tt.json)
+			}
+
+			if !fromYAML.Time.Equal(fromJSON.Time.Time) {
+				t.Errorf("yaml time = %v, json time = %v, want equal", fromYAML.Time.Time, fromJSON.Time.Time)
+			}
+		})
+	}
+}
+
+func TestTimeInvalid(t *testing.T) {
+	var d struct {
+		Time Time `yaml:"time" json:"time"`
+	}
+
+	if err := yaml.Unmarshal([]byte("time: soon\n"), &d); err == nil {
+		t.Errorf("yaml.Unmarshal() of an invalid time succeeded")
+	}
+	if err := json.Unmarshal([]byte(`{"time": "soon"}`), &d); err == nil {
+		t.Errorf("json.Unmarshal() of an invalid time succeeded")
+	}
+	if err := json.Unmarshal([]byte(`{"time": true}`), &d); err == nil {
+		t.Errorf("json.Unmarshal() of a boolean time succeeded")
+	}
+}
+
+func TestIndexError(t *testing.T) {
+	if err := NewIndexError(nil); err != nil {
+		t.Errorf("NewIndexError(nil) = %v, want nil", err)
+	}
+
+	errB := errors.New("invalid b")
+	err := NewIndexError(map[string]error{"b": errB, "a": errors.New("invalid a")})
+
+	wrapped := fmt.Errorf("reading index: %w", err)
+	indexErr, other := SplitIndexError(wrapped)
+	if other != nil || indexErr == nil {
+		t.Fatalf("SplitIndexError() = %v, %v, want the IndexError", indexErr, other)
+	}
+
+	if len(indexErr.Invalid) != 2 || indexErr.Invalid[0].ID != "a" || indexErr.Invalid[1].ID != "b" {
+		t.Errorf("invalid specs = %+v, want a and b in order", indexErr.Invalid)
+	}
+	if !strings.Contains(indexErr.Error(), `"a", "b"`) {
+		t.Errorf("Error() = %q, want both IDs", indexErr.Error())
+	}
+	if !errors.Is(indexErr.Spec("b"), errB) {
+		t.Errorf("Spec(b) = %v, want the error of b", indexErr.Spec("b"))
+	}
+	if indexErr.Spec("c") != nil {
+		t.Errorf("Spec(c) = %v, want nil", indexErr.Spec("c"))
+	}
+
+	other = errors.New("other")
+	if indexErr, err := SplitIndexError(other); indexErr != nil || err != other {
+		t.Errorf("SplitIndexError(other) = %v, %v, want the other error", indexErr, err)
+	}
+}
@@ -0,0 +1,138 @@
+package pak
+
+import (
+	"context"
+	"fmt"
+)
+
+// PakNotInstalledError is returned when an operation requires an installed pak.
+type PakNotInstalledError struct {
+	ID string
+}
+
+func (e PakNotInstalledError) Error() string {
+	return fmt.Sprintf("pak %s is not installed", e.ID)
+}
+
+// GetInstalled returns the installed manifest of the pak with the given id.
+// It returns a PakNotInstalledError if the pak is not installed.
+func (m *Manager) GetInstalled(ctx context.Context, id string) (*Manifest, error) {
+	manifest, err := m.local.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting local pak manifest: %w", err)
+	}
+
+	if manifest == nil {
+		return nil, PakNotInstalledError{ID: id}
+	}
+
+	return manifest, nil
+}
+
+// Enable enables the given installed paks.
+func (m *Manager) Enable(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		m.logger.Infof("Enabling %s", id)
+		if err := m.setDisabled(ctx, id, false); err != nil {
+			return fmt.Errorf("enabling pak %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Disable disables the given installed paks without uninstalling them.
+// If the local repository supports it, the pak files are moved so that they
+// are ignored by the host application.
+func (m *Manager) Disable(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		m.logger.Infof("Disabling %s", id)
+		if err := m.setDisabled(ctx, id, true); err != nil {
+			return fmt.Errorf("disabling pak %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) setDisabled(ctx context.Context, id string, disabled bool) error {
+	manifest, err := m.GetInstalled(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Disabled == disabled {
+		m.logger.Debugf("pak %s already in requested state", id)
+		return nil
+	}
+
+	// move the files before writing the manifest, so that the manifest is
+	// written to the new location
+	if err := m.setEnabled(ctx, id, !disabled); err != nil {
+		return err
+	}
+
+	manifest.Disabled = disabled
+	if err := m.local.WriteManifest(ctx, *manifest); err != nil {
+		return fmt.Errorf("writing local pak manifest: %w", err)
+	}
+
+	return nil
+}
+
+// setEnabled moves the pak files if the local repository supports it.
+func (m *Manager) setEnabled(ctx context.Context, id string, enabled bool) error {
+	s, ok := m.local.(EnabledSetter)
+	if !ok {
+		return nil
+	}
+
+	if err := s.SetEnabled(ctx, id, enabled); err != nil {
+		return fmt.Errorf("moving pak files: %w", err)
+	}
+
+	return nil
+}
+
+// Hold prevents the given installed paks from being upgraded by a bulk upgrade.
+func (m *Manager) Hold(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		m.logger.Infof("Holding %s", id)
+		if err := m.setHeld(ctx, id, true); err != nil {
+			return fmt.Errorf("holding pak %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Unhold allows the given installed paks to be upgraded by a bulk upgrade.
+func (m *Manager) Unhold(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		m.logger.Infof("Unholding %s", id)
+		if err := m.setHeld(ctx, id, false); err != nil {
+			return fmt.Errorf("unholding pak %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) setHeld(ctx context.Context, id string, held bool) error {
+	manifest, err := m.GetInstalled(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Held == held {
+		m.logger.Debugf("pak %s already in requested state", id)
+		return nil
+	}
+
+	manifest.Held = held
+	if err := m.local.WriteManifest(ctx, *manifest); err != nil {
+		return fmt.Errorf("writing local pak manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package pak_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+func TestDisable(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+	e.addFiles("a", "2.0.0", map[string]string{"plugin.txt": "2.0.0"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	disabledPath := filepath.Join(e.local.BaseDir, fs.StateDir, fs.DisabledDir, "a", "plugin.txt")
+	checkDisabled := func(disabled bool) {
+		t.Helper()
+
+		manifest := e.installed("a")
+		if manifest == nil || manifest.Disabled != disabled {
+			t.Fatalf("installed manifest = %+v, want disabled = %v", manifest, disabled)
+		}
+
+		_, activeErr := os.Stat(e.path("a", "plugin.txt"))
+		_, disabledErr := os.Stat(disabledPath)
+		if (activeErr == nil) == disabled || (disabledErr == nil) != disabled {
+			t.Errorf("active file error = %v, disabled file error = %v, want the file moved when disabled", activeErr, disabledErr)
+		}
+	}
+
+	if err := m.Disable(e.ctx, "a"); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	checkDisabled(true)
+
+	// disabling again does nothing
+	if err := m.Disable(e.ctx, "a"); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	checkDisabled(true)
+
+	// upgrading keeps the pak disabled
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	checkDisabled(true)
+	e.checkVersions(map[string]string{"a": "2.0.0"})
+
+	if err := m.Enable(e.ctx, "a"); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	checkDisabled(false)
+
+	if got := e.read("a", "plugin.txt"); got != "2.0.0" {
+		t.Errorf("plugin.txt = %q, want the upgraded file", got)
+	}
+
+	var notInstalled pak.PakNotInstalledError
+	if err := m.Disable(e.ctx, "b"); !errors.As(err, &notInstalled) {
+		t.Errorf("Disable(b) error = %v, want PakNotInstalledError", err)
+	}
+}
+
+func TestUninstallDisabled(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := m.Disable(e.ctx, "a"); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if err := m.Uninstall(e.ctx, "a"); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+
+	e.checkVersions(map[string]string{})
+	if _, err := os.Stat(filepath.Join(e.local.BaseDir, fs.StateDir, fs.DisabledDir, "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("disabled directory error = %v, want it removed", err)
+	}
+}
+
+func TestHold(t *testing.T) {
+	e := newTestEnv(t)
+	for _, id := range []string{"a", "b"} {
+		e.addFiles(id, "1.0.0", map[string]string{"plugin.txt": "1.0.0"})
+		e.addFiles(id, "2.0.0", map[string]string{"plugin.txt": "2.0.0"})
+		e.addFiles(id, "3.0.0", map[string]string{"plugin.txt": "3.0.0"})
+	}
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}, pak.InstallSpec{ID: "b", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	if err := m.Hold(e.ctx, "a"); err != nil {
+		t.Fatalf("Hold() error = %v", err)
+	}
+
+	upgradable, err := m.Upgradable(e.ctx)
+	if err != nil {
+		t.Fatalf("Upgradable() error = %v", err)
+	}
+	held := make(map[string]bool)
+	for _, u := range upgradable {
+		held[u.ID] = u.Held
+	}
+	if want := map[string]bool{"a": true, "b": false}; !reflect.DeepEqual(held, want) {
+		t.Errorf("Upgradable() held = %v, want %v", held, want)
+	}
+
+	// a bulk upgrade skips held paks
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "1.0.0", "b": "3.0.0"})
+
+	// held paks may be upgraded explicitly, and stay held
+	if err := m.Upgrade(e.ctx, pak.InstallSpec{ID: "a", Version: "2.0.0"}); err != nil {
+		t.Fatalf("Upgrade(a) error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "2.0.0", "b": "3.0.0"})
+	if !e.installed("a").Held {
+		t.Errorf("a is not held after an explicit upgrade")
+	}
+
+	if err := m.Unhold(e.ctx, "a"); err != nil {
+		t.Fatalf("Unhold() error = %v", err)
+	}
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+	e.checkVersions(map[string]string{"a": "3.0.0", "b": "3.0.0"})
+
+	var notInstalled pak.PakNotInstalledError
+	if err := m.Hold(e.ctx, "c"); !errors.As(err, &notInstalled) {
+		t.Errorf("Hold(c) error = %v, want PakNotInstalledError", err)
+	}
+}
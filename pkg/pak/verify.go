@@ -0,0 +1,231 @@
+package pak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// ErrVerifyNotSupported is returned when the local repository cannot read installed files.
+var ErrVerifyNotSupported = errors.New("local repository does not support reading installed files")
+
+// VerifyResult is the result of verifying an installed pak.
+type VerifyResult struct {
+	ID      string
+	Version string
+
+	// Missing are the installed files that no longer exist.
+	Missing []string
+	// Modified are the installed files whose size or digest no longer match the manifest.
+	// Config files are expected to be modified by the user, and are not included.
+	Modified []string
+	// Extraneous are the files in the pak directory that were not installed from the manifest.
+	Extraneous []string
+}
+
+// OK returns true if no missing or modified files were found.
+// Extraneous files are not considered a problem.
+func (r VerifyResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Modified) == 0
+}
+
+// Damaged returns the missing and modified files.
+func (r VerifyResult) Damaged() []string {
+	ret := append([]string{}, r.Missing...)
+	return append(ret, r.Modified...)
+}
+
+// Verify checks the files of the given installed paks against their installed manifests.
+// If no ids are given, all installed paks are verified.
+//
+// Files are only checked against their size and digest if these were recorded when installed.
+// Modified config files are not reported, so that they are not replaced by Repair.
+func (m *Manager) Verify(ctx context.Context, ids ...string) ([]VerifyResult, error) {
+	getter, ok := m.local.(InstalledFileGetter)
+	if !ok {
+		return nil, ErrVerifyNotSupported
+	}
+
+	manifests, err := m.installedManifests(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []VerifyResult
+	for _, manifest := range manifests {
+		result, err := m.verify(ctx, getter, manifest)
+		if err != nil {
+			return nil, fmt.Errorf("verifying pak %s: %w", manifest.ID, err)
+		}
+
+		ret = append(ret, *result)
+	}
+
+	return ret, nil
+}
+
+// installedManifests returns the manifests of the installed paks with the given ids,
+// or all installed paks if no ids are given.
+func (m *Manager) installedManifests(ctx context.Context, ids []string) ([]Manifest, error) {
+	if len(ids) == 0 {
+		installed, err := m.local.ListInstalled(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing local paks: %w", err)
+		}
+
+		sort.Slice(installed, func(i, j int) bool {
+			return installed[i].ID < installed[j].ID
+		})
+
+		return installed, nil
+	}
+
+	var ret []Manifest
+	for _, id := range ids {
+		manifest, err := m.GetInstalled(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, *manifest)
+	}
+
+	return ret, nil
+}
+
+func (m *Manager) verify(ctx context.Context, getter InstalledFileGetter, manifest Manifest) (*VerifyResult, error) {
+	ret := &VerifyResult{
+		ID:      manifest.ID,
+		Version: manifest.Version,
+	}
+
+	files := make(map[string]File, len(manifest.Files))
+	for _, f := range manifest.Files {
+		files[f.Path] = f
+	}
+
+	installed := make(map[string]struct{})
+	for _, path := range manifest.LocalFiles() {
+		installed[path] = struct{}{}
+
+		file, ok := files[path]
+		if !ok {
+			file = File{Path: path}
+		}
+
+		status, err := m.checkInstalledFile(ctx, getter, manifest.ID, file)
+		if err != nil {
+			return nil, err
+		}
+
+		switch status {
+		case fileMissing:
+			ret.Missing = append(ret.Missing, path)
+		case fileModified:
+			if !file.Config {
+				ret.Modified = append(ret.Modified, path)
+			}
+		}
+	}
+
+	all, err := getter.ListInstalledFiles(ctx, manifest.ID)
+	if err != nil {
+		return nil, fmt.Errorf("listing installed files: %w", err)
+	}
+
+	for _, path := range all {
+		if _, ok := installed[path]; !ok {
+			ret.Extraneous = append(ret.Extraneous, path)
+		}
+	}
+
+	return ret, nil
+}
+
+type fileStatus int
+
+const (
+	fileOK fileStatus = iota
+	fileMissing
+	fileModified
+)
+
+func (m *Manager) checkInstalledFile(ctx context.Context, getter InstalledFileGetter, id string, file File) (fileStatus, error) {
+	rc, err := getter.GetInstalledFile(ctx, id, file.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileMissing, nil
+	}
+	if err != nil {
+		return fileOK, fmt.Errorf("opening installed file %q: %w", file.Path, err)
+	}
+	defer rc.Close()
+
+	digest, size, err := ComputeDigest(rc)
+	if err != nil {
+		return fileOK, fmt.Errorf("reading installed file %q: %w", file.Path, err)
+	}
+
+	if err := file.Check(digest, size); err != nil {
+		return fileModified, nil
+	}
+
+	return fileOK, nil
+}
+
+// Repair verifies the given installed paks and downloads their missing and
+// modified files from the remote. If no ids are given, all installed paks are
+// repaired. Extraneous files are not removed.
+// It returns the verification results from before the repair.
+func (m *Manager) Repair(ctx context.Context, ids ...string) ([]VerifyResult, error) {
+	results, err := m.Verify(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.OK() {
+			continue
+		}
+
+		m.logger.Infof("Repairing %s", result.ID)
+		if err := m.repair(ctx, result); err != nil {
+			return results, fmt.Errorf("repairing pak %s: %w", result.ID, err)
+		}
+	}
+
+	return results, nil
+}
+
+func (m *Manager) repair(ctx context.Context, result VerifyResult) error {
+	manifest, err := m.GetInstalled(ctx, result.ID)
+	if err != nil {
+		return err
+	}
+
+	damaged := make(map[string]struct{})
+	for _, path := range result.Damaged() {
+		damaged[path] = struct{}{}
+	}
+
+	for i, file := range manifest.Files {
+		if _, ok := damaged[file.Path]; !ok {
+			continue
+		}
+
+		m.logger.Debugf("downloading %s", file.Path)
+		downloaded, err := m.downloadFile(ctx, manifest.ID, manifest.Version, file)
+		if err != nil {
+			return fmt.Errorf("downloading file %q: %w", file.Path, err)
+		}
+
+		manifest.Files[i] = downloaded
+	}
+
+	if err := m.local.WriteManifest(ctx, *manifest); err != nil {
+		return fmt.Errorf("writing local pak manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package pak_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	e := newTestEnv(t)
+	e.addFiles("a", "1.0.0", map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	results, err := m.Verify(e.ctx)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].OK() || len(results[0].Extraneous) != 0 {
+		t.Fatalf("Verify() = %+v, want a single OK result", results)
+	}
+
+	e.write("a", "a.txt", "modified")
+	if err := os.Remove(e.path("a", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	e.write("a", "extra.txt", "extra")
+
+	results, err = m.Repair(e.ctx, "a")
+	if err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+
+	want := pak.VerifyResult{
+		ID:         "a",
+		Version:    "1.0.0",
+		Missing:    []string{"b.txt"},
+		Modified:   []string{"a.txt"},
+		Extraneous: []string{"extra.txt"},
+	}
+	if len(results) != 1 || !reflect.DeepEqual(results[0], want) {
+		t.Errorf("Repair() = %+v, want %+v", results, want)
+	}
+
+	for file, data := range map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "extra.txt": "extra"} {
+		if got := e.read("a", file); got != data {
+			t.Errorf("%s = %q after repair, want %q", file, got, data)
+		}
+	}
+
+	results, err = m.Verify(e.ctx, "a")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].OK() {
+		t.Errorf("Verify() after repair = %+v, want OK", results)
+	}
+}
+
+// TestRepairKeptConfig checks that a config file that was modified and kept
+// by an upgrade is not replaced by Repair, while a missing config file is restored.
+func TestRepairKeptConfig(t *testing.T) {
+	e := newTestEnv(t)
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		e.add(pak.Manifest{
+			ID:      "a",
+			Version: v,
+			Files: []pak.File{
+				{Path: "config.yml", Config: true},
+				{Path: "other.yml", Config: true},
+			},
+		}, map[string]string{"config.yml": "config " + v, "other.yml": "other " + v})
+	}
+
+	m := e.manager(pak.ManagerOptions{})
+	if err := m.Install(e.ctx, pak.InstallSpec{ID: "a", Version: "1.0.0"}); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+
+	e.write("a", "config.yml", "edited")
+	if err := m.Upgrade(e.ctx); err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	if got := e.read("a", "config.yml"); got != "edited" {
+		t.Fatalf("config.yml = %q after upgrade, want the edited file", got)
+	}
+
+	if err := os.Remove(e.path("a", "other.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := m.Repair(e.ctx)
+	if err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+
+	if len(results) != 1 || len(results[0].Modified) != 0 || !reflect.DeepEqual(results[0].Missing, []string{"other.yml"}) {
+		t.Errorf("Repair() = %+v, want only other.yml missing", results)
+	}
+
+	if got := e.read("a", "config.yml"); got != "edited" {
+		t.Errorf("config.yml = %q after repair, want the edited file", got)
+	}
+	if got := e.read("a", "other.yml"); got != "other 2.0.0" {
+		t.Errorf("other.yml = %q after repair, want the restored file", got)
+	}
+}
@@ -0,0 +1,202 @@
+package pak
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two semantic version strings. It returns -1 if a is
+// lower than b, 1 if a is greater than b and 0 if they are equal.
+// A leading "v" and build metadata are ignored. Pre-release versions are lower
+// than the equivalent release version. Components that are not numeric are
+// compared lexically.
+func CompareVersions(a, b string) int {
+	aVer, aPre := splitVersion(a)
+	bVer, bPre := splitVersion(b)
+
+	if c := compareComponents(aVer, bVer); c != 0 {
+		return c
+	}
+
+	// a version without a pre-release has higher precedence
+	switch {
+	case aPre == "" && bPre == "":
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+
+	return compareComponents(aPre, bPre)
+}
+
+func splitVersion(v string) (version string, prerelease string) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+
+	// ignore build metadata
+	if i := strings.Index(v, "+"); i != -1 {
+		v = v[:i]
+	}
+
+	if i := strings.Index(v, "-"); i != -1 {
+		return v[:i], v[i+1:]
+	}
+
+	return v, ""
+}
+
+func compareComponents(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		// missing components are treated as zero
+		aPart := "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		bPart := "0"
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		if c := compareComponent(aPart, bPart); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func compareComponent(a, b string) int {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		switch {
+		case aNum < bNum:
+			return -1
+		case aNum > bNum:
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		// numeric identifiers have lower precedence
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// MatchesConstraint returns true if version satisfies the given constraint.
+//
+// A constraint is a list of comparisons separated by commas, all of which must
+// be satisfied. Alternative lists may be separated by "||".
+// Supported operators are =, !=, >, >=, <, <=, ~ (same minor version) and ^ (same
+// major version). A version without an operator must match exactly. Spaces
+// around comparisons, and between an operator and its version, are ignored.
+// An empty constraint matches any version.
+//
+// For example: ">= 1.2.0, < 2.0.0 || ^3.1".
+func MatchesConstraint(version string, constraint string) (bool, error) {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" {
+		return true, nil
+	}
+
+	for _, alternative := range strings.Split(constraint, "||") {
+		matched, err := matchesAll(version, alternative)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchesAll(version string, constraint string) (bool, error) {
+	if strings.TrimSpace(constraint) == "" {
+		return false, fmt.Errorf("invalid version constraint %q", constraint)
+	}
+
+	for _, c := range strings.Split(constraint, ",") {
+		matched, err := matchesComparison(version, strings.TrimSpace(c))
+		if err != nil {
+			return false, err
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+var constraintOperators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+func matchesComparison(version string, comparison string) (bool, error) {
+	op := ""
+	for _, o := range constraintOperators {
+		if strings.HasPrefix(comparison, o) {
+			op = o
+			break
+		}
+	}
+
+	target := strings.TrimSpace(strings.TrimPrefix(comparison, op))
+	if target == "" || strings.ContainsAny(target, " \t") {
+		return false, fmt.Errorf("invalid version comparison %q", comparison)
+	}
+
+	c := CompareVersions(version, target)
+
+	switch op {
+	case "", "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "~":
+		return c >= 0 && CompareVersions(version, nextVersion(target, 1)) < 0, nil
+	case "^":
+		return c >= 0 && CompareVersions(version, nextVersion(target, 0)) < 0, nil
+	}
+
+	return false, fmt.Errorf("invalid version comparison %q", comparison)
+}
+
+// nextVersion returns the lowest version that has a higher component at index i
+// than v. For example, nextVersion("1.2.3", 1) returns "1.3.0-0".
+func nextVersion(v string, i int) string {
+	base, _ := splitVersion(v)
+	parts := strings.Split(base, ".")
+	for len(parts) <= i {
+		parts = append(parts, "0")
+	}
+
+	n, _ := strconv.Atoi(parts[i])
+	parts[i] = strconv.Itoa(n + 1)
+	for j := i + 1; j < len(parts); j++ {
+		parts[j] = "0"
+	}
+
+	// the lowest pre-release of the next version excludes pre-releases of it
+	return strings.Join(parts, ".") + "-0"
+}
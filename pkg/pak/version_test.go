@@ -0,0 +1,107 @@
+package pak
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.2.0", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0", "1.0.0", 0},
+		{"1", "1.0.1", -1},
+		{"v1.2.3", "1.2.3", 0},
+		{" 1.2.3 ", "1.2.3", 0},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-beta", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.1", "1.0.0-beta.x", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1.1", -1},
+		{"1.3.0-0", "1.2.9", 1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+
+		// the comparison is antisymmetric
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestMatchesConstraint(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+		wantErr    bool
+	}{
+		{version: "1.0.0", constraint: "", want: true},
+		{version: "1.0.0", constraint: "  ", want: true},
+		{version: "1.2.0", constraint: "1.2.0", want: true},
+		{version: "1.2.1", constraint: "1.2.0", want: false},
+		{version: "1.2.0", constraint: "=1.2", want: true},
+		{version: "1.2.0", constraint: "!=1.2.0", want: false},
+		{version: "1.3.0", constraint: ">1.2.0", want: true},
+		{version: "1.2.0", constraint: ">1.2.0", want: false},
+		{version: "1.2.0", constraint: ">=1.2.0", want: true},
+		{version: "1.1.9", constraint: ">=1.2.0", want: false},
+		{version: "1.9.0", constraint: "<2", want: true},
+		{version: "2.0.0-beta", constraint: "<2", want: true},
+		{version: "2.0.0", constraint: "<=2.0.0", want: true},
+
+		// ~ matches the same minor version
+		{version: "1.2.5", constraint: "~1.2.3", want: true},
+		{version: "1.2.2", constraint: "~1.2.3", want: false},
+		{version: "1.3.0", constraint: "~1.2.3", want: false},
+		{version: "1.3.0-beta", constraint: "~1.2.3", want: false},
+
+		// ^ matches the same major version
+		{version: "1.9.0", constraint: "^1.2", want: true},
+		{version: "1.1.0", constraint: "^1.2", want: false},
+		{version: "2.0.0", constraint: "^1.2", want: false},
+
+		// comparisons separated by commas must all match
+		{version: "1.5.0", constraint: ">=1.2.0,<2.0.0", want: true},
+		{version: "2.1.0", constraint: ">=1.2.0,<2.0.0", want: false},
+		{version: "1.5.0", constraint: ">=1.2.0, <2.0.0", want: true},
+
+		// spaces between operators and versions are ignored
+		{version: "1.3.0", constraint: ">= 1.2.0", want: true},
+		{version: "1.1.0", constraint: ">= 1.2.0", want: false},
+		{version: "1.5.0", constraint: " >= 1.2.0 , < 2.0.0 ", want: true},
+		{version: "1.2.0", constraint: "= 1.2.0", want: true},
+
+		// alternatives
+		{version: "3.2.0", constraint: ">=1.2.0, <2.0.0 || ^3.1", want: true},
+		{version: "2.5.0", constraint: ">=1.2.0, <2.0.0 || ^3.1", want: false},
+		{version: "1.0.0", constraint: "1.0.0 || 2.0.0", want: true},
+
+		// invalid constraints
+		{version: "1.0.0", constraint: ">=", wantErr: true},
+		{version: "1.0.0", constraint: ">= ", wantErr: true},
+		{version: "1.0.0", constraint: ">=1.0.0,", wantErr: true},
+		{version: "2.0.0", constraint: "1.0.0 ||", wantErr: true},
+		{version: "1.0.0", constraint: ">=1.0.0 <2.0.0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := MatchesConstraint(tt.version, tt.constraint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MatchesConstraint(%q, %q) error = %v, want error %v", tt.version, tt.constraint, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("MatchesConstraint(%q, %q) = %v, want %v", tt.version, tt.constraint, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package yaml
+
+import (
+	"io"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+// Codec reads and writes pakman files as yaml.
+type Codec struct{}
+
+// Extensions returns the file extensions of yaml files. The first is the preferred extension.
+func (Codec) Extensions() []string {
+	return []string{".yml", ".yaml"}
+}
+
+// ContentTypes returns the media types of yaml files. The first is the preferred media type.
+func (Codec) ContentTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+}
+
+func (Codec) ReadSpec(r io.Reader) (*pak.Spec, error) {
+	return ReadSpec(r)
+}
+
+func (Codec) WriteSpec(w io.Writer, spec pak.Spec) error {
+	return WriteSpec(w, spec)
+}
+
+func (Codec) ReadManifest(r io.Reader) (*pak.Manifest, error) {
+	return ReadManifest(r)
+}
+
+func (Codec) WriteManifest(w io.Writer, manifest pak.Manifest) error {
+	return WriteManifest(w, manifest)
+}
+
+func (Codec) ReadSpecIndex(r io.Reader) (*pak.SpecIndex, error) {
+	return ReadSpecIndex(r)
+}
+
+func (Codec) WriteSpecIndex(w io.Writer, index pak.SpecIndex) error {
+	return WriteSpecIndex(w, index)
+}
+
+func (Codec) ReadIndex(r io.Reader) (*pak.Index, error) {
+	return ReadIndex(r)
+}
+
+func (Codec) WriteIndex(w io.Writer, index pak.Index) error {
+	return WriteIndex(w, index)
+}
+
+func (Codec) ReadIndexDelta(r io.Reader) (*pak.IndexDelta, error) {
+	return ReadIndexDelta(r)
+}
+
+func (Codec) WriteIndexDelta(w io.Writer, delta pak.IndexDelta) error {
+	return WriteIndexDelta(w, delta)
+}
@@ -0,0 +1,249 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/internal/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaError is returned when a document does not match the expected schema.
+type SchemaError struct {
+	Line    int
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// indexDocument is the format of an index file since format version 2.
+// Version 1 index files are a map of pak ID to spec.
+type indexDocument struct {
+	FormatVersion int                  `yaml:"formatVersion"`
+	Revision      int64                `yaml:"revision,omitempty"`
+	Sharded       bool                 `yaml:"sharded,omitempty"`
+	KeepChanges   int                  `yaml:"keepChanges,omitempty"`
+	Paks          map[string]specEntry `yaml:"paks"`
+}
+
+// specEntry is a spec in an index. Errors decoding the spec are recorded
+// rather than failing the decoding of the whole index.
+type specEntry struct {
+	spec pak.Spec
+	err  error
+}
+
+func (e *specEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	e.err = unmarshal(&e.spec)
+	return nil
+}
+
+// indexReaders decode each format version of the index file, returning the
+// index in the current format.
+var indexReaders = map[int]func(data []byte, root *yaml.Node) (*pak.Index, error){
+	1: readIndexV1,
+	2: readIndexV2,
+	3: readIndexV3,
+}
+
+// manifestMigrations migrate a manifest decoded from the keyed format version
+// to the next format version.
+var manifestMigrations = map[int]func(m *pak.Manifest){
+	// versions 2 and 3 only changed the index format
+	1: func(m *pak.Manifest) {},
+	2: func(m *pak.Manifest) {},
+}
+
+func readIndexV1(data []byte, root *yaml.Node) (*pak.Index, error) {
+	errs, err := checkSpecs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]specEntry
+	if err := decode(data, &entries); err != nil {
+		return nil, err
+	}
+
+	paks, err := toSpecIndex(entries, errs)
+	return &pak.Index{Paks: paks}, err
+}
+
+// readIndexV2 reads an index that is not sharded.
+func readIndexV2(data []byte, root *yaml.Node) (*pak.Index, error) {
+	if node := mappingValue(root, "sharded"); node != nil {
+		var sharded bool
+		if err := node.Decode(&sharded); err != nil || sharded {
+			return nil, SchemaError{Line: node.Line, Message: "sharded indexes require format version 3"}
+		}
+	}
+
+	return readIndexV3(data, root)
+}
+
+// readIndexV3 reads an index that may be sharded.
+func readIndexV3(data []byte, root *yaml.Node) (*pak.Index, error) {
+	if err := checkRequired(root, "index", schema.Index); err != nil {
+		return nil, err
+	}
+
+	errs, err := checkSpecs(mappingValue(root, "paks"))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc indexDocument
+	if err := decode(data, &doc); err != nil {
+		return nil, err
+	}
+
+	paks, err := toSpecIndex(doc.Paks, errs)
+	return &pak.Index{Revision: doc.Revision, Sharded: doc.Sharded, KeepChanges: doc.KeepChanges, Paks: paks}, err
+}
+
+// toSpecIndex returns the index of the valid specs in entries.
+// If any spec is invalid, it returns an IndexError with the index.
+func toSpecIndex(entries map[string]specEntry, errs map[string]error) (pak.SpecIndex, error) {
+	index := pak.SpecIndex{}
+	for id, e := range entries {
+		if _, invalid := errs[id]; invalid {
+			continue
+		}
+
+		if e.err != nil {
+			errs[id] = decodeError(e.err)
+			continue
+		}
+
+		index[id] = e.spec
+	}
+
+	return index, pak.NewIndexError(errs)
+}
+
+// checkSpecs checks the required fields of each spec in a mapping of pak ID to spec.
+// It returns the errors keyed by pak ID.
+func checkSpecs(node *yaml.Node) (map[string]error, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, SchemaError{Line: node.Line, Message: "expected a mapping of pak ID to spec"}
+	}
+
+	errs := make(map[string]error)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		id := node.Content[i].Value
+		if err := checkRequired(node.Content[i+1], "spec", schema.Spec); err != nil {
+			errs[id] = err
+		}
+	}
+
+	return errs, nil
+}
+
+// parseDocument parses data, returning the root node of the document.
+// It returns an error if the root node is not a mapping.
+func parseDocument(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode yaml: %w", err)
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, SchemaError{Line: 1, Message: "empty document"}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, SchemaError{Line: root.Line, Message: "expected a mapping"}
+	}
+
+	return root, nil
+}
+
+// formatVersion returns the format version declared in the mapping node.
+// Documents without a format version are version 1.
+func formatVersion(node *yaml.Node) (int, error) {
+	v := mappingValue(node, schema.FormatVersionKey)
+	if v == nil {
+		return 1, nil
+	}
+
+	var version int
+	if err := v.Decode(&version); err != nil || version < 1 {
+		return 0, SchemaError{Line: v.Line, Message: fmt.Sprintf("invalid format version %q", v.Value)}
+	}
+
+	if version > pak.FormatVersion {
+		return 0, fmt.Errorf("line %d: %w", v.Line, pak.UnsupportedFormatError{Version: version})
+	}
+
+	return version, nil
+}
+
+// mappingValue returns the value of the given key in the mapping node, or nil if it is not present.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// checkRequired returns a SchemaError if node is not a mapping with a
+// non-empty value for each of the required keys.
+func checkRequired(node *yaml.Node, what string, required []string) error {
+	if node.Kind != yaml.MappingNode {
+		return SchemaError{Line: node.Line, Message: fmt.Sprintf("%s: expected a mapping", what)}
+	}
+
+	for _, key := range required {
+		v := mappingValue(node, key)
+		if v == nil {
+			return SchemaError{Line: node.Line, Message: fmt.Sprintf("%s: missing required field %q", what, key)}
+		}
+
+		if v.Kind == yaml.ScalarNode && v.Value == "" {
+			return SchemaError{Line: v.Line, Message: fmt.Sprintf("%s: required field %q is empty", what, key)}
+		}
+	}
+
+	return nil
+}
+
+// decode decodes data into v. Fields that are not in v are rejected with a SchemaError.
+func decode(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+
+	return nil
+}
+
+// unknownFieldPattern matches the errors returned by yaml for unknown fields.
+var unknownFieldPattern = regexp.MustCompile(`^line (\d+): field (.+) not found in type .+$`)
+
+// decodeError returns a SchemaError if err is caused by an unknown field,
+// and otherwise returns err wrapped.
+func decodeError(err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			if m := unknownFieldPattern.FindStringSubmatch(e); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				return SchemaError{Line: line, Message: fmt.Sprintf("unknown field %q", m[2])}
+			}
+		}
+	}
+
+	return fmt.Errorf("failed to decode yaml: %w", err)
+}
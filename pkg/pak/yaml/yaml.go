@@ -2,23 +2,16 @@
 package yaml
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/internal/schema"
 	"gopkg.in/yaml.v3"
 )
 
-func readYaml(f io.Reader, v interface{}) error {
-	decoder := yaml.NewDecoder(f)
-
-	if err := decoder.Decode(v); err != nil {
-		return fmt.Errorf("failed to decode yaml: %w", err)
-	}
-
-	return nil
-}
-
 func writeYaml(out io.Writer, v interface{}) error {
 	encoder := yaml.NewEncoder(out)
 	defer encoder.Close()
@@ -31,9 +24,24 @@ func writeYaml(out io.Writer, v interface{}) error {
 }
 
 // ReadSpec reads a spec from the given reader parsing it as yaml.
+// It returns an error if the spec is missing required fields or has unknown fields.
 func ReadSpec(f io.Reader) (*pak.Spec, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(root, "spec", schema.Spec); err != nil {
+		return nil, err
+	}
+
 	var spec pak.Spec
-	if err := readYaml(f, &spec); err != nil {
+	if err := decode(data, &spec); err != nil {
 		return nil, err
 	}
 
@@ -41,26 +49,194 @@ func ReadSpec(f io.Reader) (*pak.Spec, error) {
 }
 
 // ReadManifest reads a manifest from the given reader parsing it as yaml.
+// It returns an error if the manifest is missing required fields or has unknown fields.
+// Manifests in older formats are migrated to the current format.
 func ReadManifest(f io.Reader) (*pak.Manifest, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := formatVersion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(root, "manifest", schema.Manifest); err != nil {
+		return nil, err
+	}
+
 	var manifest pak.Manifest
-	if err := readYaml(f, &manifest); err != nil {
+	if err := decode(data, &manifest); err != nil {
 		return nil, err
 	}
 
+	for v := version; v < pak.FormatVersion; v++ {
+		manifestMigrations[v](&manifest)
+	}
+	manifest.FormatVersion = pak.FormatVersion
+
 	return &manifest, nil
 }
 
-// WriteManifest writes the given manifest to the given writer as yaml.
+// WriteManifest writes the given manifest to the given writer as yaml, in the current format.
+// An empty list of installed files is written, so that it is not read as nil.
 func WriteManifest(out io.Writer, manifest pak.Manifest) error {
-	return writeYaml(out, manifest)
+	manifest.FormatVersion = pak.ManifestFormatVersion
+
+	var node yaml.Node
+	if err := node.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to encode yaml: %w", err)
+	}
+
+	if manifest.InstalledFiles != nil && len(manifest.InstalledFiles) == 0 {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "installedFiles"},
+			&yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle},
+		)
+	}
+
+	return writeYaml(out, &node)
+}
+
+// WriteSpec writes the given spec to the given writer as yaml.
+func WriteSpec(out io.Writer, spec pak.Spec) error {
+	return writeYaml(out, spec)
+}
+
+// WriteSpecIndex writes the given spec index to the given writer as yaml.
+func WriteSpecIndex(out io.Writer, index pak.SpecIndex) error {
+	return WriteIndex(out, pak.Index{Paks: index})
+}
+
+// WriteIndex writes the given index to the given writer as yaml, in the
+// oldest format that can represent it. See pak.Index.MinFormatVersion.
+func WriteIndex(out io.Writer, index pak.Index) error {
+	index.FormatVersion = index.MinFormatVersion()
+	return writeYaml(out, index)
 }
 
 // ReadSpecIndex reads a spec index from the given reader parsing it as yaml.
+// See ReadIndex.
 func ReadSpecIndex(f io.Reader) (*pak.SpecIndex, error) {
-	var index pak.SpecIndex
-	if err := readYaml(f, &index); err != nil {
+	index, err := ReadIndex(f)
+	if index == nil {
+		return nil, err
+	}
+
+	return &index.Paks, err
+}
+
+// ReadIndex reads an index from the given reader parsing it as yaml.
+// Indexes in older formats are migrated to the current format.
+//
+// Specs that are missing required fields or cannot otherwise be decoded are omitted from the index, and a pak.IndexError
+// naming them is returned along with the index.
+func ReadIndex(f io.Reader) (*pak.Index, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := formatVersion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := indexReaders[version](data, root)
+	if index == nil {
+		return nil, err
+	}
+
+	index.FormatVersion = pak.FormatVersion
+	return index, err
+}
+
+// WriteIndexDelta writes the given index delta to the given writer as yaml, in the current format.
+func WriteIndexDelta(out io.Writer, delta pak.IndexDelta) error {
+	delta.FormatVersion = pak.FormatVersion
+	return writeYaml(out, delta)
+}
+
+// ReadIndexDelta reads an index delta from the given reader parsing it as yaml.
+// Unlike ReadIndex, an invalid spec causes the whole delta to be rejected.
+func ReadIndexDelta(f io.Reader) (*pak.IndexDelta, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
 		return nil, err
 	}
 
-	return &index, nil
+	if _, err := formatVersion(root); err != nil {
+		return nil, err
+	}
+
+	if err := checkRequired(root, "delta", schema.Delta); err != nil {
+		return nil, err
+	}
+
+	if changed := mappingValue(root, "changed"); changed != nil {
+		errs, err := checkSpecs(changed)
+		if err != nil {
+			return nil, err
+		}
+		if err := pak.NewIndexError(errs); err != nil {
+			return nil, err
+		}
+	}
+
+	var delta pak.IndexDelta
+	if err := decode(data, &delta); err != nil {
+		return nil, err
+	}
+
+	return &delta, nil
+}
+
+// WriteHistoryEntry writes the given history entry to the given writer as a yaml document.
+// The document is written with a single call to Write, so that it may be appended to a file.
+func WriteHistoryEntry(out io.Writer, entry pak.HistoryEntry) error {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	if err := writeYaml(&buf, entry); err != nil {
+		return err
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// ReadHistory reads all history entries from the given reader, parsing each yaml document as an entry.
+func ReadHistory(f io.Reader) ([]pak.HistoryEntry, error) {
+	decoder := yaml.NewDecoder(f)
+
+	var ret []pak.HistoryEntry
+	for {
+		var entry pak.HistoryEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode yaml: %w", err)
+		}
+
+		ret = append(ret, entry)
+	}
+
+	return ret, nil
 }
@@ -0,0 +1,168 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestManifestInstalledFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+	}{
+		{name: "not recorded"},
+		{name: "none installed", installed: []string{}},
+		{name: "installed", installed: []string{"a.txt", "b/c.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteManifest(&buf, pak.Manifest{
+				ID:             "a",
+				Name:           "A",
+				Version:        "1.0.0",
+				Files:          []pak.File{{Path: "a.txt"}},
+				InstalledFiles: tt.installed,
+			}); err != nil {
+				t.Fatalf("WriteManifest() error = %v", err)
+			}
+
+			got, err := ReadManifest(&buf)
+			if err != nil {
+				t.Fatalf("ReadManifest() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.InstalledFiles, tt.installed) {
+				t.Errorf("InstalledFiles = %#v, want %#v", got.InstalledFiles, tt.installed)
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     func(io.Reader) error
+		data     string
+		wantLine int
+		wantKey  string
+	}{
+		{
+			name:     "manifest",
+			read:     readManifest,
+			data:     "id: a\nname: A\nversion: 1.0.0\nversoin: 2.0.0\n",
+			wantLine: 4,
+			wantKey:  "versoin",
+		},
+		{
+			name:     "manifest file",
+			read:     readManifest,
+			data:     "id: a\nname: A\nversion: 1.0.0\nfiles:\n  - a.txt\n  - path: b.txt\n    plattforms: [linux]\n",
+			wantLine: 7,
+			wantKey:  "plattforms",
+		},
+		{
+			name:     "manifest dependency",
+			read:     readManifest,
+			data:     "id: a\nname: A\nversion: 1.0.0\nrequires:\n  - id: b\n    versions: '>=1'\n",
+			wantLine: 6,
+			wantKey:  "versions",
+		},
+		{
+			name:     "spec",
+			read:     readSpec,
+			data:     "id: a\nname: A\ncurrentVersion: 1.0.0\nversions:\n  - version: 1.0.0\n    yank: true\n",
+			wantLine: 6,
+			wantKey:  "yank",
+		},
+		{
+			name:     "index",
+			read:     readIndex,
+			data:     "formatVersion: 2\nrevison: 3\npaks: {}\n",
+			wantLine: 2,
+			wantKey:  "revison",
+		},
+		{
+			name:     "delta",
+			read:     readIndexDelta,
+			data:     "formatVersion: 3\nfrom: 1\nrevision: 2\nremove: [a]\n",
+			wantLine: 4,
+			wantKey:  "remove",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.read(strings.NewReader(tt.data))
+
+			var schemaErr SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("error = %v, want SchemaError", err)
+			}
+
+			want := SchemaError{Line: tt.wantLine, Message: fmt.Sprintf("unknown field %q", tt.wantKey)}
+			if schemaErr != want {
+				t.Errorf("error = %+v, want %+v", schemaErr, want)
+			}
+		})
+	}
+}
+
+// TestIndexUnknownSpecField checks that a spec with an unknown field is omitted from the index.
+func TestIndexUnknownSpecField(t *testing.T) {
+	index, err := ReadIndex(strings.NewReader(`formatVersion: 2
+paks:
+  a:
+    id: a
+    name: A
+    currentVersion: 1.0.0
+  b:
+    id: b
+    name: B
+    currentVersion: 1.0.0
+    colour: blue
+`))
+
+	var indexErr pak.IndexError
+	if !errors.As(err, &indexErr) || len(indexErr.Invalid) != 1 || indexErr.Invalid[0].ID != "b" {
+		t.Fatalf("ReadIndex() error = %v, want IndexError for b", err)
+	}
+
+	want := SchemaError{Line: 11, Message: `unknown field "colour"`}
+	var schemaErr SchemaError
+	if !errors.As(indexErr.Invalid[0].Err, &schemaErr) || schemaErr != want {
+		t.Errorf("invalid spec error = %v, want %v", indexErr.Invalid[0].Err, want)
+	}
+
+	if index == nil || len(index.Paks) != 1 || index.Paks["a"].ID != "a" {
+		t.Errorf("ReadIndex() = %+v, want the valid specs", index)
+	}
+}
+
+func readManifest(r io.Reader) error {
+	_, err := ReadManifest(r)
+	return err
+}
+
+func readSpec(r io.Reader) error {
+	_, err := ReadSpec(r)
+	return err
+}
+
+func readIndex(r io.Reader) error {
+	_, err := ReadIndex(r)
+	return err
+}
+
+func readIndexDelta(r io.Reader) error {
+	_, err := ReadIndexDelta(r)
+	return err
+}
@@ -0,0 +1,816 @@
+// Package build generates the manifests and index of a source repository from the pak files in it.
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repo"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+// Options are the options used to build a repository.
+type Options struct {
+	// Codec is the encoding of the files written. If nil, codec.YAML is used.
+	Codec codec.Codec
+
+	// Sharded writes a sharded index, with the full spec of each pak in <id>/spec.yml.
+	Sharded bool
+
+	// Compress are the encodings that compressed variants of the index,
+	// spec and manifest files are written with.
+	Compress []compress.Encoding
+
+	// KeepChanges is the number of previous revisions of the index that
+	// change files are kept for, allowing clients to update their copy of the
+	// index incrementally. Older change files are removed. If zero or
+	// negative, no change files are written and existing change files are
+	// removed. The number is recorded in the index.
+	KeepChanges int
+
+	// IDs are the IDs of the paks to build. If empty, all paks are built.
+	// Otherwise, the specs of other paks are copied from the existing index.
+	IDs []string
+
+	// Metadata contains the new metadata of paks, keyed by ID. The name,
+	// description, authors, license, homepage, source, tags, icon and
+	// screenshots of each spec replace those in the existing index.
+	Metadata pak.SpecIndex
+}
+
+// Detect returns the options that the existing repository in dir was built
+// with, as far as they can be determined from its files. KeepChanges is the
+// number recorded in the index. If the index does not record it, but change
+// files exist, it is the number of revisions since the oldest change file.
+func Detect(ctx context.Context, dir string) (Options, error) {
+	var ret Options
+
+	index, err := (&pakfs.Repository{BaseDir: dir}).Index(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	}
+	if _, err := pak.SplitIndexError(err); err != nil {
+		return ret, err
+	}
+	ret.Sharded = index.Sharded
+
+	for _, c := range codec.All() {
+		name := pak.IndexName + codec.Extension(c)
+		if !exists(filepath.Join(dir, name)) {
+			continue
+		}
+
+		ret.Codec = c
+		for _, e := range compress.Encodings() {
+			if exists(filepath.Join(dir, name+e.Extension)) {
+				ret.Compress = append(ret.Compress, e)
+			}
+		}
+		break
+	}
+
+	ret.KeepChanges = index.KeepChanges
+	if ret.KeepChanges == 0 {
+		deltas, err := (repo.Writer{Dir: dir}).ReadIndexDeltas()
+		if err != nil {
+			return ret, err
+		}
+
+		for from := range deltas {
+			if n := int(index.Revision - from); n > ret.KeepChanges {
+				ret.KeepChanges = n
+			}
+		}
+
+		// the only change file is relative to the current revision
+		if len(deltas) > 0 && ret.KeepChanges == 0 {
+			ret.KeepChanges = 1
+		}
+	}
+
+	return ret, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Build generates the manifests and index of the source repository in dir,
+// which is laid out as read by the fs repository:
+//
+//	<dir>/<id>/<version>/<files>
+//
+// The manifest of each version lists every file in the version directory, with
+// its size and digest. Existing manifests are updated: their name, date,
+// dependencies and other fields, and the platforms, tags and config flag of
+// listed files, are kept. Versions without a manifest are given one named
+// after the pak, dated with the modification time of their newest file.
+//
+// The spec of each pak lists its versions in semantic version order, and its
+// current version is the newest version that is not a pre-release or yanked.
+// The metadata of paks and versions in the existing index, such as
+// descriptions, channels and yanked versions, is kept. The revision of the
+// index is increased if it has changed.
+//
+// Files and directories whose names start with "." are ignored. If the
+// repository is inconsistent, a repo.ProblemsError is returned and nothing is
+// written. If writing a file fails, the files already written are restored.
+func Build(ctx context.Context, dir string, options Options) (*pak.Index, error) {
+	b := newBuilder(dir, options)
+	index, err := b.build(ctx)
+	if err != nil {
+		return nil, b.rollback(err)
+	}
+
+	return index, nil
+}
+
+// WriteIndex writes the index of the repository in dir, containing the given
+// specs, without building the paks. Spec files, the revision and change files
+// are updated, and restored on failure, as they are by Build. IDs and Metadata
+// in options are ignored.
+func WriteIndex(ctx context.Context, dir string, specs pak.SpecIndex, options Options) (*pak.Index, error) {
+	b := newBuilder(dir, options)
+
+	// invalid specs in the existing index are replaced
+	if err := b.readIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	index, err := b.writeIndex(specs)
+	if err != nil {
+		return nil, b.rollback(err)
+	}
+
+	return index, nil
+}
+
+// AddVersion adds a new version of a pak to the index of the repository in
+// dir, without building its other versions. The files of the version must be
+// in <dir>/<id>/<version>, and are listed with their sizes and digests in
+// manifest, which is written to that directory. If metadata is not nil, it
+// replaces the metadata of the pak, as Options.Metadata does for Build.
+// Spec files, the revision and change files are updated, and restored on
+// failure, as they are by Build. IDs and Metadata in options are ignored.
+func AddVersion(ctx context.Context, dir string, manifest pak.Manifest, metadata *pak.Spec, options Options) (*pak.Index, error) {
+	b := newBuilder(dir, options)
+	if err := b.readIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	if len(b.problems) > 0 {
+		return nil, repo.ProblemsError{Problems: b.problems}
+	}
+
+	spec, ok := b.oldSpecs[manifest.ID]
+	if !ok {
+		spec = pak.Spec{ID: manifest.ID, Name: manifest.Name}
+	}
+
+	if _, ok := spec.FindVersion(manifest.Version); ok {
+		return nil, fmt.Errorf("%s@%s is already in the index", manifest.ID, manifest.Version)
+	}
+
+	if metadata != nil {
+		setMetadata(&spec, *metadata)
+	}
+
+	// the versions of the existing spec are not modified, so that it can be compared
+	versions := make([]pak.VersionInfo, 0, len(spec.Versions)+1)
+	versions = append(versions, spec.Versions...)
+	versions = append(versions, versionInfo(pak.VersionInfo{}, manifest))
+	sort.SliceStable(versions, func(i, j int) bool {
+		return pak.CompareVersions(versions[i].Version, versions[j].Version) < 0
+	})
+
+	spec.Versions = versions
+	spec.CurrentVersion = currentVersion(versions)
+	if spec.CurrentVersion == manifest.Version {
+		spec.Updated = manifest.Date
+	}
+
+	specs := pak.SpecIndex{}
+	for id, s := range b.oldSpecs {
+		specs[id] = s
+	}
+	specs[manifest.ID] = spec
+
+	if err := b.writer.WriteManifest(manifest); err != nil {
+		return nil, b.rollback(err)
+	}
+
+	index, err := b.writeIndex(specs)
+	if err != nil {
+		return nil, b.rollback(err)
+	}
+
+	return index, nil
+}
+
+func newBuilder(dir string, options Options) *builder {
+	return &builder{
+		dir:     dir,
+		options: options,
+		src:     &pakfs.Repository{BaseDir: dir},
+		writer: repo.Writer{
+			Dir:      dir,
+			Codec:    options.Codec,
+			Compress: options.Compress,
+			Journal:  &repo.Journal{},
+		},
+	}
+}
+
+type builder struct {
+	dir     string
+	options Options
+	src     *pakfs.Repository
+	writer  repo.Writer
+
+	problems []repo.Problem
+
+	// old is the existing index, or nil if there is none
+	old *pak.Index
+	// oldSpecs are the full specs of the existing index
+	oldSpecs pak.SpecIndex
+}
+
+func (b *builder) problem(p string, format string, args ...interface{}) {
+	b.problems = append(b.problems, repo.Problem{Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// rollback restores the files written by the builder after err.
+func (b *builder) rollback(err error) error {
+	if rbErr := b.writer.Journal.Rollback(); rbErr != nil {
+		return fmt.Errorf("%w (failed to restore files: %v)", err, rbErr)
+	}
+
+	return err
+}
+
+func (b *builder) build(ctx context.Context) (*pak.Index, error) {
+	if err := b.readIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	ids := b.options.IDs
+	specs := pak.SpecIndex{}
+	if len(ids) == 0 {
+		var err error
+		if ids, err = repo.ListDirs(b.dir); err != nil {
+			return nil, err
+		}
+	} else {
+		for id, spec := range b.oldSpecs {
+			specs[id] = spec
+		}
+	}
+
+	var manifests []pak.Manifest
+	for _, id := range ids {
+		// the changes directory is the only directory in the root that is not a pak
+		if id == pak.ChangesDir {
+			continue
+		}
+
+		spec, m, err := b.buildPak(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(specs, id)
+		if spec != nil {
+			specs[id] = *spec
+			manifests = append(manifests, m...)
+		}
+	}
+
+	if len(b.problems) > 0 {
+		return nil, repo.ProblemsError{Problems: b.problems}
+	}
+
+	for _, m := range manifests {
+		if err := b.writer.WriteManifest(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.writeIndex(specs)
+}
+
+// readIndex reads the existing index and the full specs of its paks, if it exists.
+func (b *builder) readIndex(ctx context.Context) error {
+	index, err := b.src.Index(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	invalid, err := pak.SplitIndexError(err)
+	if err != nil {
+		return err
+	}
+
+	// the metadata of invalid specs would be lost
+	if invalid != nil {
+		for _, e := range invalid.Invalid {
+			b.problem(pakfs.IndexPath, "%v", e)
+		}
+	}
+
+	b.old = index
+	b.oldSpecs = pak.SpecIndex{}
+	for id, spec := range index.Paks {
+		if index.Sharded {
+			full, err := b.src.GetSpec(ctx, id)
+			if err != nil {
+				b.problem(id, "%v", err)
+				continue
+			}
+			spec = *full
+		}
+
+		b.oldSpecs[id] = spec
+	}
+
+	return nil
+}
+
+// buildPak returns the spec and manifests of the pak with the given id.
+func (b *builder) buildPak(ctx context.Context, id string) (*pak.Spec, []pak.Manifest, error) {
+	versions, err := repo.ListDirs(filepath.Join(b.dir, id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(versions) == 0 {
+		b.problem(id, "no versions")
+		return nil, nil, nil
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return pak.CompareVersions(versions[i], versions[j]) < 0
+	})
+
+	spec, ok := b.oldSpecs[id]
+	if !ok {
+		spec = pak.Spec{ID: id, Name: b.manifestName(ctx, id, versions)}
+	}
+
+	if metadata, ok := b.options.Metadata[id]; ok {
+		setMetadata(&spec, metadata)
+	}
+
+	var manifests []pak.Manifest
+	var infos []pak.VersionInfo
+	for _, version := range versions {
+		m, err := b.buildManifest(ctx, spec, version)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if m == nil {
+			continue
+		}
+
+		info, _ := spec.FindVersion(version)
+		manifests = append(manifests, *m)
+		infos = append(infos, versionInfo(info, *m))
+	}
+
+	if len(manifests) == 0 {
+		return nil, nil, nil
+	}
+
+	spec.Versions = infos
+	spec.CurrentVersion = currentVersion(infos)
+
+	for _, m := range manifests {
+		if m.Version == spec.CurrentVersion {
+			spec.Updated = m.Date
+		}
+	}
+
+	for _, channel := range sortedKeys(spec.Channels) {
+		if _, ok := spec.FindVersion(spec.Channels[channel]); !ok {
+			b.problem(id, "channel %q refers to version %q, which does not exist", channel, spec.Channels[channel])
+		}
+	}
+
+	return &spec, manifests, nil
+}
+
+// versionInfo returns info, which is the existing information about the
+// version in the index, updated from the version's manifest.
+func versionInfo(info pak.VersionInfo, m pak.Manifest) pak.VersionInfo {
+	info.Version = m.Version
+	date := m.Date
+	info.Date = &date
+	info.Size = 0
+	for _, f := range m.Files {
+		info.Size += f.Size
+	}
+	if m.Changelog != "" {
+		info.Notes = m.Changelog
+	}
+
+	return info
+}
+
+// setMetadata replaces the metadata of spec with that of metadata.
+func setMetadata(spec *pak.Spec, metadata pak.Spec) {
+	spec.Name = metadata.Name
+	spec.Description = metadata.Description
+	spec.Authors = metadata.Authors
+	spec.License = metadata.License
+	spec.Homepage = metadata.Homepage
+	spec.Source = metadata.Source
+	spec.Tags = metadata.Tags
+	spec.Icon = metadata.Icon
+	spec.Screenshots = metadata.Screenshots
+}
+
+// manifestName returns the name in the manifest of the newest version of a
+// new pak that has one, or the pak's id if none do.
+func (b *builder) manifestName(ctx context.Context, id string, versions []string) string {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if m, err := b.src.GetManifest(ctx, id, versions[i]); err == nil {
+			return m.Name
+		}
+	}
+
+	return id
+}
+
+// currentVersion returns the newest version that is not a pre-release or yanked.
+// If there are none, the newest version that is not yanked is returned, or
+// the newest version if all are yanked. versions must be sorted.
+func currentVersion(versions []pak.VersionInfo) string {
+	ret := ""
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v.Yanked {
+			continue
+		}
+
+		if !strings.Contains(strings.SplitN(v.Version, "+", 2)[0], "-") {
+			return v.Version
+		}
+
+		if ret == "" {
+			ret = v.Version
+		}
+	}
+
+	if ret == "" {
+		ret = versions[len(versions)-1].Version
+	}
+
+	return ret
+}
+
+// buildManifest returns the manifest of the given version of the pak, or nil if there are problems with it.
+func (b *builder) buildManifest(ctx context.Context, spec pak.Spec, version string) (*pak.Manifest, error) {
+	rel := path.Join(spec.ID, version)
+	dir := filepath.Join(b.dir, spec.ID, version)
+
+	m, err := b.src.GetManifest(ctx, spec.ID, version)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		m = &pak.Manifest{ID: spec.ID, Name: spec.Name, Version: version}
+	case err != nil:
+		b.problem(path.Join(rel, pakfs.RemoteManifestPath), "%v", err)
+		return nil, nil
+	}
+
+	nProblems := len(b.problems)
+	if m.ID != spec.ID {
+		b.problem(rel, "manifest has id %q", m.ID)
+	}
+	if m.Version != version {
+		b.problem(rel, "manifest has version %q", m.Version)
+	}
+
+	listed := make(map[string]pak.File)
+	for _, f := range m.Files {
+		listed[f.Path] = f
+	}
+
+	found, modTime, err := b.walkFiles(dir, listed)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the order of the files listed in the existing manifest
+	var files []pak.File
+	for _, f := range m.Files {
+		computed, ok := found[f.Path]
+		if !ok {
+			b.problem(path.Join(rel, f.Path), "listed in manifest but does not exist")
+			continue
+		}
+
+		f.Size = computed.Size
+		f.Digest = computed.Digest
+		files = append(files, f)
+		delete(found, f.Path)
+	}
+
+	for _, p := range sortedKeys(found) {
+		files = append(files, found[p])
+	}
+
+	if len(b.problems) > nProblems {
+		return nil, nil
+	}
+
+	m.FormatVersion = pak.FormatVersion
+	m.Files = files
+	if m.Date.IsZero() {
+		m.Date = pak.Time{Time: modTime.UTC().Truncate(time.Second)}
+	}
+
+	return m, nil
+}
+
+// walkFiles returns the files in the version directory, keyed by path, with
+// their sizes and digests, and the modification time of the newest file.
+// Manifest files are excluded. A compressed file whose uncompressed path is
+// listed is a compressed variant of the listed file, and is read in its place
+// if the uncompressed file does not exist.
+func (b *builder) walkFiles(dir string, listed map[string]pak.File) (map[string]pak.File, time.Time, error) {
+	manifestNames := repo.ManifestNames()
+
+	ret := make(map[string]pak.File)
+	var newest time.Time
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if manifestNames[rel] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+
+		var enc *compress.Encoding
+		if e := compress.ForPath(rel); e != nil {
+			if _, ok := listed[strings.TrimSuffix(rel, e.Extension)]; ok {
+				enc = e
+				rel = strings.TrimSuffix(rel, e.Extension)
+			}
+		}
+
+		if enc != nil {
+			// prefer the uncompressed file
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel))); err == nil {
+				return nil
+			}
+		}
+
+		digest, size, err := computeDigest(p, enc)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", p, err)
+		}
+
+		ret[rel] = pak.File{Path: rel, Size: size, Digest: digest}
+		return nil
+	})
+	if err != nil {
+		return nil, newest, fmt.Errorf("failed to walk %q: %w", dir, err)
+	}
+
+	return ret, newest, nil
+}
+
+// computeDigest returns the digest and size of the file, decompressing it if enc is not nil.
+func computeDigest(p string, enc *compress.Encoding) (string, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var r io.ReadCloser = f
+	if enc != nil {
+		if r, err = enc.NewReader(f, 0); err != nil {
+			return "", 0, err
+		}
+	}
+	defer r.Close()
+
+	return pak.ComputeDigest(r)
+}
+
+// writeIndex writes the spec files, change files and index, in that order,
+// so that the files referred to by the index exist before it is written.
+func (b *builder) writeIndex(specs pak.SpecIndex) (*pak.Index, error) {
+	c := b.writer.Codec
+	if c == nil {
+		c = codec.YAML
+	}
+
+	index := pak.Index{
+		FormatVersion: pak.FormatVersion,
+		Sharded:       b.options.Sharded,
+		Paks:          pak.SpecIndex{},
+	}
+	if b.options.KeepChanges > 0 {
+		index.KeepChanges = b.options.KeepChanges
+	}
+
+	for id, spec := range specs {
+		if index.Sharded {
+			index.Paks[id] = spec.Summary()
+			if err := b.writer.WriteSpec(spec); err != nil {
+				return nil, err
+			}
+		} else {
+			index.Paks[id] = spec
+		}
+	}
+
+	// the layout of the index changed, so changes relative to the old index cannot be applied
+	reset := b.old == nil || b.old.Revision == 0 || b.old.Sharded != index.Sharded
+
+	var step pak.IndexDelta
+	if b.old != nil {
+		step = pak.IndexDelta{From: b.old.Revision, Changed: pak.SpecIndex{}}
+		for id, spec := range specs {
+			if old, ok := b.oldSpecs[id]; !ok || !sameSpec(c, old, spec) {
+				step.Changed[id] = index.Paks[id]
+			}
+		}
+
+		for _, id := range sortedKeys(b.old.Paks) {
+			if _, ok := specs[id]; !ok {
+				step.Removed = append(step.Removed, id)
+			}
+		}
+	}
+
+	// the old index is not nil unless reset
+	changed := reset || len(step.Changed) > 0 || len(step.Removed) > 0 || b.old.KeepChanges != index.KeepChanges
+	switch {
+	case b.old == nil:
+		index.Revision = 1
+	case changed:
+		index.Revision = b.old.Revision + 1
+	default:
+		index.Revision = b.old.Revision
+	}
+	step.Revision = index.Revision
+
+	if !index.Sharded {
+		for id := range b.oldSpecs {
+			if err := b.writer.RemoveSpec(id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := b.writeChanges(step, changed && !reset, reset); err != nil {
+		return nil, err
+	}
+
+	if err := b.writer.WriteIndex(index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// writeChanges updates the change files for each of the kept revisions of the
+// index, so that each contains the changes up to the current revision.
+// step contains the changes since the previous revision.
+func (b *builder) writeChanges(step pak.IndexDelta, changed bool, reset bool) error {
+	existing, err := b.writer.ReadIndexDeltas()
+	if err != nil {
+		return err
+	}
+
+	keep := b.options.KeepChanges
+	deltas := make(map[int64]pak.IndexDelta)
+	if keep > 0 && !reset {
+		for from, delta := range existing {
+			switch {
+			case changed && delta.Revision == step.From:
+				deltas[from] = compose(delta, step)
+			case !changed && delta.Revision == step.Revision:
+				deltas[from] = delta
+			}
+		}
+
+		if changed {
+			deltas[step.From] = compose(pak.IndexDelta{From: step.From, Revision: step.From}, step)
+		}
+	}
+
+	if keep > 0 {
+		deltas[step.Revision] = pak.IndexDelta{From: step.Revision, Revision: step.Revision}
+	}
+
+	for from := range existing {
+		if _, ok := deltas[from]; !ok || from < step.Revision-int64(keep) {
+			if err := b.writer.RemoveIndexDelta(from); err != nil {
+				return err
+			}
+			delete(deltas, from)
+		}
+	}
+
+	for _, delta := range deltas {
+		if err := b.writer.WriteIndexDelta(delta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compose returns the changes in a followed by the changes in b.
+func compose(a, b pak.IndexDelta) pak.IndexDelta {
+	ret := pak.IndexDelta{
+		From:     a.From,
+		Revision: b.Revision,
+		Changed:  pak.SpecIndex{},
+	}
+
+	removed := make(map[string]bool)
+	for _, id := range a.Removed {
+		removed[id] = true
+	}
+
+	for id, spec := range a.Changed {
+		ret.Changed[id] = spec
+	}
+
+	for id, spec := range b.Changed {
+		ret.Changed[id] = spec
+		delete(removed, id)
+	}
+
+	for _, id := range b.Removed {
+		delete(ret.Changed, id)
+		removed[id] = true
+	}
+
+	ret.Removed = sortedKeys(removed)
+	return ret
+}
+
+// sameSpec returns true if the specs are encoded identically.
+func sameSpec(c codec.Codec, a, b pak.Spec) bool {
+	var aBuf, bBuf bytes.Buffer
+	if c.WriteSpec(&aBuf, a) != nil || c.WriteSpec(&bBuf, b) != nil {
+		return false
+	}
+
+	return bytes.Equal(aBuf.Bytes(), bBuf.Bytes())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+
+	sort.Strings(ret)
+	return ret
+}
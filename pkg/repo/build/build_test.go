@@ -0,0 +1,114 @@
+package build
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+)
+
+func TestCompose(t *testing.T) {
+	a := pak.Spec{ID: "a", CurrentVersion: "1.0.0"}
+	a2 := pak.Spec{ID: "a", CurrentVersion: "2.0.0"}
+	b := pak.Spec{ID: "b", CurrentVersion: "1.0.0"}
+	c := pak.Spec{ID: "c", CurrentVersion: "1.0.0"}
+
+	tests := []struct {
+		name string
+		a    pak.IndexDelta
+		b    pak.IndexDelta
+		want pak.IndexDelta
+	}{
+		{
+			name: "no changes",
+			a:    pak.IndexDelta{From: 1, Revision: 2},
+			b:    pak.IndexDelta{From: 2, Revision: 3},
+			want: pak.IndexDelta{From: 1, Revision: 3, Changed: pak.SpecIndex{}, Removed: []string{}},
+		},
+		{
+			name: "separate changes",
+			a:    pak.IndexDelta{From: 1, Revision: 2, Changed: pak.SpecIndex{"a": a}},
+			b:    pak.IndexDelta{From: 2, Revision: 3, Changed: pak.SpecIndex{"b": b}, Removed: []string{"c"}},
+			want: pak.IndexDelta{From: 1, Revision: 3, Changed: pak.SpecIndex{"a": a, "b": b}, Removed: []string{"c"}},
+		},
+		{
+			name: "changed twice",
+			a:    pak.IndexDelta{From: 1, Revision: 2, Changed: pak.SpecIndex{"a": a}},
+			b:    pak.IndexDelta{From: 2, Revision: 3, Changed: pak.SpecIndex{"a": a2}},
+			want: pak.IndexDelta{From: 1, Revision: 3, Changed: pak.SpecIndex{"a": a2}, Removed: []string{}},
+		},
+		{
+			name: "changed then removed",
+			a:    pak.IndexDelta{From: 1, Revision: 2, Changed: pak.SpecIndex{"a": a, "b": b}},
+			b:    pak.IndexDelta{From: 2, Revision: 3, Removed: []string{"a"}},
+			want: pak.IndexDelta{From: 1, Revision: 3, Changed: pak.SpecIndex{"b": b}, Removed: []string{"a"}},
+		},
+		{
+			name: "removed then added",
+			a:    pak.IndexDelta{From: 1, Revision: 2, Removed: []string{"a", "b"}},
+			b:    pak.IndexDelta{From: 2, Revision: 3, Changed: pak.SpecIndex{"a": a2}},
+			want: pak.IndexDelta{From: 1, Revision: 3, Changed: pak.SpecIndex{"a": a2}, Removed: []string{"b"}},
+		},
+		{
+			name: "removed sorted",
+			a:    pak.IndexDelta{From: 1, Revision: 2, Removed: []string{"c"}},
+			b:    pak.IndexDelta{From: 2, Revision: 4, Removed: []string{"b", "a"}},
+			want: pak.IndexDelta{From: 1, Revision: 4, Changed: pak.SpecIndex{}, Removed: []string{"a", "b", "c"}},
+		},
+		{
+			name: "added, changed and removed",
+			a:    pak.IndexDelta{From: 3, Revision: 4, Changed: pak.SpecIndex{"a": a, "c": c}, Removed: []string{"b"}},
+			b:    pak.IndexDelta{From: 4, Revision: 5, Changed: pak.SpecIndex{"a": a2, "b": b}, Removed: []string{"c"}},
+			want: pak.IndexDelta{From: 3, Revision: 5, Changed: pak.SpecIndex{"a": a2, "b": b}, Removed: []string{"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compose(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compose() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestComposeApply checks that applying the composed delta to an index gives
+// the same result as applying the deltas in turn.
+func TestComposeApply(t *testing.T) {
+	index := pak.Index{
+		Revision: 1,
+		Paks: pak.SpecIndex{
+			"a": {ID: "a", CurrentVersion: "1.0.0"},
+			"b": {ID: "b", CurrentVersion: "1.0.0"},
+			"c": {ID: "c", CurrentVersion: "1.0.0"},
+		},
+	}
+
+	deltas := []pak.IndexDelta{
+		{From: 1, Revision: 2, Changed: pak.SpecIndex{"a": {ID: "a", CurrentVersion: "1.1.0"}, "d": {ID: "d"}}, Removed: []string{"b"}},
+		{From: 2, Revision: 3, Changed: pak.SpecIndex{"b": {ID: "b", CurrentVersion: "2.0.0"}}, Removed: []string{"d"}},
+		{From: 3, Revision: 4, Changed: pak.SpecIndex{"a": {ID: "a", CurrentVersion: "1.2.0"}}, Removed: []string{"c"}},
+	}
+
+	want := index
+	composed := pak.IndexDelta{From: 1, Revision: 1}
+	for _, d := range deltas {
+		var ok bool
+		want, ok = want.Apply(d)
+		if !ok {
+			t.Fatalf("Apply(%+v) failed", d)
+		}
+
+		composed = compose(composed, d)
+	}
+
+	got, ok := index.Apply(composed)
+	if !ok {
+		t.Fatalf("Apply(%+v) failed", composed)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applying composed delta = %+v, want %+v", got, want)
+	}
+}
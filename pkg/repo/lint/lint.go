@@ -0,0 +1,384 @@
+// Package lint checks source repositories for problems.
+package lint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repo"
+	"github.com/WithoutPants/pakman/pkg/repo/serve"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+	pakhttp "github.com/WithoutPants/pakman/pkg/repository/http"
+)
+
+// Lint checks the source repository for problems that would cause installing
+// from it to fail. It checks for:
+//
+//   - specs and manifests that cannot be read, including invalid timestamps
+//   - current and channel versions that are not listed in the versions of a spec
+//   - versions listed in the index without a manifest
+//   - manifests whose id or version does not match their directory
+//   - files listed in a manifest that do not exist
+//   - ids, versions and file paths that are unsafe to use as paths
+//
+// Invalid specs in the index are only reported if the repository implements
+// serve.Indexer, otherwise they are omitted by the repository.
+//
+// If the repository is an fs repository, its directory is also checked for:
+//
+//   - files listed in a manifest that have the wrong size
+//   - files, versions and paks that are not listed in their manifest or the index
+//
+// Hidden files and directories are ignored. The problems are returned sorted by path.
+func Lint(ctx context.Context, src pak.SourceRepository) ([]repo.Problem, error) {
+	l := &linter{
+		src: src,
+		now: time.Now(),
+	}
+
+	if r, ok := src.(*pakfs.Repository); ok {
+		l.dir = r.BaseDir
+	}
+
+	if err := l.lint(ctx); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].Path < l.problems[j].Path
+	})
+
+	return l.problems, nil
+}
+
+type linter struct {
+	src pak.SourceRepository
+	now time.Time
+
+	// dir is the directory of an fs repository, or empty if the repository is
+	// not in a directory. The checks of the directory are skipped if it is empty.
+	dir string
+
+	problems []repo.Problem
+}
+
+func (l *linter) problem(p string, format string, args ...interface{}) {
+	l.problems = append(l.problems, repo.Problem{Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// readIndex reads the index of the repository. If the repository does not
+// implement serve.Indexer, the index is treated as sharded, so that the full
+// spec of each pak is requested.
+func (l *linter) readIndex(ctx context.Context) (*pak.Index, error) {
+	if indexer, ok := l.src.(serve.Indexer); ok {
+		return indexer.Index(ctx)
+	}
+
+	paks, err := pak.ListSummaries(ctx, l.src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pak.Index{Paks: paks, Sharded: true}, nil
+}
+
+func (l *linter) lint(ctx context.Context) error {
+	index, err := l.readIndex(ctx)
+	invalid, err := pak.SplitIndexError(err)
+	if isNotExist(err) {
+		l.problem(pakfs.IndexPath, "index not found")
+		return nil
+	}
+	if err != nil {
+		l.problem(pakfs.IndexPath, "%v", err)
+		return nil
+	}
+
+	if invalid != nil {
+		for _, e := range invalid.Invalid {
+			l.problem(pakfs.IndexPath, "%v", e)
+		}
+	}
+
+	ids := make([]string, 0, len(index.Paks))
+	for id := range index.Paks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := repo.CheckName(id); err != nil {
+			l.problem(pakfs.IndexPath, "pak id: %v", err)
+			continue
+		}
+
+		spec := index.Paks[id]
+		if index.Sharded {
+			full, err := l.src.GetSpec(ctx, id)
+			if err != nil {
+				l.problem(id, "%v", err)
+				continue
+			}
+			if full == nil {
+				l.problem(id, "spec not found")
+				continue
+			}
+			spec = *full
+		}
+
+		if err := l.lintPak(ctx, id, spec); err != nil {
+			return err
+		}
+	}
+
+	if l.dir == "" {
+		return nil
+	}
+
+	// paks that are not in the index
+	dirs, err := repo.ListDirs(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range dirs {
+		if _, ok := index.Paks[id]; !ok && id != pak.ChangesDir && (invalid == nil || invalid.Spec(id) == nil) {
+			l.problem(id, "pak is not in the index")
+		}
+	}
+
+	return nil
+}
+
+func (l *linter) lintPak(ctx context.Context, id string, spec pak.Spec) error {
+	if spec.ID != id {
+		l.problem(id, "spec has id %q", spec.ID)
+	}
+
+	l.checkTime(id, "updated time", spec.Updated)
+
+	versions := spec.Versions
+	if len(versions) == 0 {
+		// versions are optional, but the current version must exist
+		versions = []pak.VersionInfo{{Version: spec.CurrentVersion}}
+	} else if _, ok := spec.FindVersion(spec.CurrentVersion); !ok {
+		l.problem(id, "current version %q is not listed in versions", spec.CurrentVersion)
+	}
+
+	channels := make([]string, 0, len(spec.Channels))
+	for channel := range spec.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	for _, channel := range channels {
+		version := spec.Channels[channel]
+		if _, ok := spec.FindVersion(version); !ok && version != spec.CurrentVersion {
+			l.problem(id, "channel %q refers to version %q, which is not listed in versions", channel, version)
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, v := range versions {
+		listed[v.Version] = true
+
+		if err := repo.CheckName(v.Version); err != nil {
+			l.problem(id, "version: %v", err)
+			continue
+		}
+
+		if v.Date != nil {
+			l.checkTime(path.Join(id, v.Version), "release date", *v.Date)
+		}
+
+		if err := l.lintVersion(ctx, id, v.Version); err != nil {
+			return err
+		}
+	}
+
+	if l.dir == "" {
+		return nil
+	}
+
+	// versions that are not in the index
+	dirs, err := repo.ListDirs(filepath.Join(l.dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, version := range dirs {
+		if !listed[version] {
+			l.problem(path.Join(id, version), "version is not listed in the index")
+		}
+	}
+
+	return nil
+}
+
+func (l *linter) lintVersion(ctx context.Context, id string, version string) error {
+	rel := path.Join(id, version)
+
+	manifest, err := l.src.GetManifest(ctx, id, version)
+	if isNotExist(err) || err == nil && manifest == nil {
+		l.problem(rel, "version is listed in the index but has no manifest")
+		return nil
+	}
+	if err != nil {
+		l.problem(path.Join(rel, pakfs.RemoteManifestPath), "%v", err)
+		return nil
+	}
+
+	if manifest.ID != id {
+		l.problem(rel, "manifest has id %q", manifest.ID)
+	}
+	if manifest.Version != version {
+		l.problem(rel, "manifest has version %q", manifest.Version)
+	}
+
+	l.checkTime(rel, "manifest date", manifest.Date)
+
+	if l.dir == "" {
+		for _, f := range manifest.Files {
+			if err := repo.CheckPath(f.Path); err != nil {
+				l.problem(rel, "file: %v", err)
+				continue
+			}
+
+			if err := l.checkFileExists(ctx, id, version, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	dir := filepath.Join(l.dir, id, version)
+	listed := make(map[string]bool)
+	for _, f := range manifest.Files {
+		if err := repo.CheckPath(f.Path); err != nil {
+			l.problem(rel, "file: %v", err)
+			continue
+		}
+
+		listed[f.Path] = true
+		l.checkFile(rel, dir, f)
+	}
+
+	return l.checkStrayFiles(rel, dir, listed)
+}
+
+// checkFileExists checks that the listed file can be got from the repository.
+// The contents of the file are not read.
+func (l *linter) checkFileExists(ctx context.Context, id string, version string, f pak.File) error {
+	rc, err := l.src.GetFile(ctx, id, version, f.Path)
+	if isNotExist(err) || err == nil && rc == nil {
+		l.problem(path.Join(id, version, f.Path), "listed in the manifest but does not exist")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return rc.Close()
+}
+
+// checkFile checks that the listed file exists and has the listed size.
+// Files may be stored compressed, in which case their size is not checked.
+func (l *linter) checkFile(rel string, dir string, f pak.File) {
+	p := filepath.Join(dir, filepath.FromSlash(f.Path))
+	info, err := os.Stat(p)
+	if err == nil {
+		if f.Size != 0 && info.Size() != f.Size {
+			l.problem(path.Join(rel, f.Path), "size is %d bytes, but the manifest lists %d bytes", info.Size(), f.Size)
+		}
+		return
+	}
+
+	for _, e := range compress.Encodings() {
+		if _, err := os.Stat(p + e.Extension); err == nil {
+			return
+		}
+	}
+
+	l.problem(path.Join(rel, f.Path), "listed in the manifest but does not exist")
+}
+
+// checkStrayFiles reports files in the version directory that are not listed in the manifest.
+func (l *linter) checkStrayFiles(rel string, dir string, listed map[string]bool) error {
+	manifestNames := repo.ManifestNames()
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		if manifestNames[name] || listed[name] {
+			return nil
+		}
+
+		// compressed variants of listed files
+		if e := compress.ForPath(name); e != nil && listed[strings.TrimSuffix(name, e.Extension)] {
+			return nil
+		}
+
+		l.problem(path.Join(rel, name), "file is not listed in the manifest")
+		return nil
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to walk %q: %w", dir, err)
+	}
+
+	return nil
+}
+
+// isNotExist returns true if err is returned for a file that does not exist,
+// including by the http repository.
+func isNotExist(err error) bool {
+	var statusErr pakhttp.StatusError
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// checkTime reports timestamps that are missing or in the future.
+func (l *linter) checkTime(p string, what string, t pak.Time) {
+	switch {
+	case t.IsZero():
+		l.problem(p, "%s is missing", what)
+	case t.After(l.now.Add(24 * time.Hour)):
+		l.problem(p, "%s %s is in the future", what, t)
+	}
+}
@@ -0,0 +1,132 @@
+package lint_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repo"
+	"github.com/WithoutPants/pakman/pkg/repo/build"
+	"github.com/WithoutPants/pakman/pkg/repo/lint"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+	"github.com/WithoutPants/pakman/pkg/repository/memory"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkProblems(t *testing.T, got []repo.Problem, want []repo.Problem) {
+	t.Helper()
+
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint() problems:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestLintDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "a", "1.0.0", "plugin.txt"), "a 1.0.0")
+	writeFile(t, filepath.Join(dir, "b", "1.0.0", "plugin.txt"), "b 1.0.0")
+
+	if _, err := build.Build(ctx, dir, build.Options{}); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	src := &pakfs.Repository{BaseDir: dir}
+
+	problems, err := lint.Lint(ctx, src)
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+	checkProblems(t, problems, nil)
+
+	// hidden files are ignored
+	writeFile(t, filepath.Join(dir, "a", "1.0.0", ".hidden"), "")
+	writeFile(t, filepath.Join(dir, ".hidden", "1.0.0", "plugin.txt"), "")
+
+	writeFile(t, filepath.Join(dir, "a", "1.0.0", "plugin.txt"), "a 1.0.0 modified")
+	writeFile(t, filepath.Join(dir, "a", "1.0.0", "stray.txt"), "")
+	writeFile(t, filepath.Join(dir, "a", "2.0.0", "plugin.txt"), "")
+	writeFile(t, filepath.Join(dir, "c", "1.0.0", "plugin.txt"), "")
+	if err := os.Remove(filepath.Join(dir, "b", "1.0.0", "plugin.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err = lint.Lint(ctx, src)
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+	checkProblems(t, problems, []repo.Problem{
+		{Path: "a/1.0.0/plugin.txt", Message: "size is 16 bytes, but the manifest lists 7 bytes"},
+		{Path: "a/1.0.0/stray.txt", Message: "file is not listed in the manifest"},
+		{Path: "a/2.0.0", Message: "version is not listed in the index"},
+		{Path: "b/1.0.0/plugin.txt", Message: "listed in the manifest but does not exist"},
+		{Path: "c", Message: "pak is not in the index"},
+	})
+}
+
+func TestLintSource(t *testing.T) {
+	ctx := context.Background()
+
+	updated, err := pak.ParseTime("2024-03-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := memory.New()
+	src.Index["a"] = pak.Spec{
+		ID:             "a",
+		Name:           "A",
+		CurrentVersion: "1.0.0",
+		Updated:        updated,
+		Versions:       []pak.VersionInfo{{Version: "1.0.0"}, {Version: "1.1.0"}},
+		Channels:       map[string]string{"beta": "2.0.0"},
+	}
+	src.Manifests[pak.InstallSpec{ID: "a", Version: "1.0.0"}] = pak.Manifest{
+		ID:      "a",
+		Name:    "A",
+		Version: "1.0.0",
+		Date:    updated,
+		Files:   []pak.File{{Path: "plugin.txt"}, {Path: "missing.txt"}, {Path: "../escape.txt"}},
+	}
+	src.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: "a", Version: "1.0.0"}, File: "plugin.txt"}] = []byte("a 1.0.0")
+
+	src.Index["b"] = pak.Spec{ID: "c", Name: "B", CurrentVersion: "1.0.0", Updated: updated}
+	src.Manifests[pak.InstallSpec{ID: "b", Version: "1.0.0"}] = pak.Manifest{
+		ID:      "b",
+		Name:    "B",
+		Version: "2.0.0",
+	}
+
+	problems, err := lint.Lint(ctx, src)
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+
+	// the checks of the directory are not made
+	checkProblems(t, problems, []repo.Problem{
+		{Path: "a", Message: `channel "beta" refers to version "2.0.0", which is not listed in versions`},
+		{Path: "a/1.0.0", Message: `file: "../escape.txt" is not a safe path`},
+		{Path: "a/1.0.0/missing.txt", Message: "listed in the manifest but does not exist"},
+		{Path: "a/1.1.0", Message: "version is listed in the index but has no manifest"},
+		{Path: "b", Message: `spec has id "c"`},
+		{Path: "b/1.0.0", Message: `manifest has version "2.0.0"`},
+		{Path: "b/1.0.0", Message: "manifest date is missing"},
+	})
+}
@@ -0,0 +1,471 @@
+// Package mirror copies paks from a source repository to a repository on the file system.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repo"
+	"github.com/WithoutPants/pakman/pkg/repo/build"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+// Options are the options used to mirror a repository.
+type Options struct {
+	// Paks selects the paks to mirror, keyed by ID, with the versions of each
+	// pak to mirror. If a pak has no versions, its versions are selected as
+	// below. If empty, all paks are mirrored.
+	Paks map[string][]string
+
+	// Latest only mirrors the current version and the versions of the
+	// channels of each pak, rather than all of its versions.
+	Latest bool
+
+	// Verify checks the digests of the files of versions that have already
+	// been mirrored, and downloads them again if they do not match.
+	Verify bool
+
+	// Build are the options used to write the index, spec and manifest files.
+	Build build.Options
+
+	// Logger logs the progress of the mirror. If nil, nothing is logged.
+	Logger pak.Logger
+}
+
+// Result describes what was copied by Mirror.
+type Result struct {
+	// Versions is the number of versions copied.
+	Versions int
+	// Skipped is the number of versions that were already mirrored.
+	Skipped int
+	// Files is the number of files downloaded.
+	Files int
+	// Bytes is the total size of the files downloaded.
+	Bytes int64
+}
+
+// Mirror copies the selected paks and versions from src to the repository in
+// dir, which is laid out as read by the fs repository, and updates its index.
+//
+// Mirroring is incremental: versions whose manifest has not changed since
+// they were mirrored are skipped, as are files that have already been
+// downloaded. Files are verified against the size and digest in their
+// manifest, and are downloaded to a temporary file that is renamed once
+// verified. The manifest of a version is written after all of its files, so an
+// interrupted mirror resumes from the first version that was not complete.
+//
+// If a version cannot be mirrored, the other versions are still mirrored and
+// the index is written, and the errors are returned together.
+//
+// Paks and versions that are already in the repository but are not selected,
+// or are no longer in src, are kept.
+func Mirror(ctx context.Context, src pak.SourceRepository, dir string, options Options) (*Result, error) {
+	m := &mirror{
+		src:     src,
+		dst:     &pakfs.Repository{BaseDir: dir},
+		dir:     dir,
+		options: options,
+		result:  &Result{},
+		writer: repo.Writer{
+			Dir:      dir,
+			Codec:    options.Build.Codec,
+			Compress: options.Build.Compress,
+		},
+	}
+
+	specs, err := m.run(ctx)
+	if specs == nil {
+		return nil, err
+	}
+
+	if _, indexErr := build.WriteIndex(ctx, dir, specs, options.Build); indexErr != nil {
+		return m.result, joinErrors(err, fmt.Errorf("failed to write index: %w", indexErr))
+	}
+
+	return m.result, err
+}
+
+type mirror struct {
+	src     pak.SourceRepository
+	dst     *pakfs.Repository
+	dir     string
+	options Options
+	writer  repo.Writer
+	result  *Result
+}
+
+func (m *mirror) infof(format string, args ...interface{}) {
+	if m.options.Logger != nil {
+		m.options.Logger.Infof(format, args...)
+	}
+}
+
+// run mirrors the selected paks, returning the specs of the index of the repository in dir.
+// It returns nil specs if nothing could be mirrored.
+func (m *mirror) run(ctx context.Context) (pak.SpecIndex, error) {
+	specs, err := m.existingSpecs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := m.selectedIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, id := range ids {
+		spec, err := m.src.GetSpec(ctx, id)
+		if err == nil && spec == nil {
+			err = fmt.Errorf("pak %s not found", id)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		existing, hasExisting := specs[id]
+		for _, version := range m.selectedVersions(*spec) {
+			if err := m.mirrorVersion(ctx, id, version); err != nil {
+				errs = append(errs, fmt.Errorf("%s@%s: %w", id, version, err))
+			}
+		}
+
+		merged := m.mergeSpec(ctx, *spec, existing, hasExisting)
+		if len(merged.Versions) > 0 {
+			specs[id] = merged
+		}
+	}
+
+	return specs, joinErrors(errs...)
+}
+
+// joinErrors returns an error combining the non-nil errors, or nil if there are none.
+func joinErrors(errs ...error) error {
+	var ret multiError
+	for _, err := range errs {
+		if err != nil {
+			ret = append(ret, err)
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil
+	}
+
+	return ret
+}
+
+// multiError is the errors of several paks, one per line.
+type multiError []error
+
+func (e multiError) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Is returns true if any of the errors matches target.
+func (e multiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of the errors that matches target, and if so, sets target
+// to that error value and returns true.
+func (e multiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// existingSpecs returns the full specs in the index of the repository in dir, if it exists.
+func (m *mirror) existingSpecs(ctx context.Context) (pak.SpecIndex, error) {
+	ret := pak.SpecIndex{}
+
+	index, err := m.dst.Index(ctx)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	}
+	// invalid specs are replaced
+	if _, err := pak.SplitIndexError(err); err != nil {
+		return nil, err
+	}
+
+	for id, spec := range index.Paks {
+		if index.Sharded {
+			full, err := m.dst.GetSpec(ctx, id)
+			if err != nil {
+				continue
+			}
+			spec = *full
+		}
+
+		ret[id] = spec
+	}
+
+	return ret, nil
+}
+
+func (m *mirror) selectedIDs(ctx context.Context) ([]string, error) {
+	var ret []string
+	if len(m.options.Paks) > 0 {
+		for id := range m.options.Paks {
+			ret = append(ret, id)
+		}
+	} else {
+		index, err := pak.ListSummaries(ctx, m.src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list paks: %w", err)
+		}
+
+		for id := range index {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// selectedVersions returns the versions of the spec to mirror.
+func (m *mirror) selectedVersions(spec pak.Spec) []string {
+	if versions := m.options.Paks[spec.ID]; len(versions) > 0 {
+		return versions
+	}
+
+	if m.options.Latest || len(spec.Versions) == 0 {
+		ret := []string{spec.CurrentVersion}
+		for _, v := range spec.Channels {
+			if v != spec.CurrentVersion {
+				ret = append(ret, v)
+			}
+		}
+
+		sort.Slice(ret, func(i, j int) bool {
+			return pak.CompareVersions(ret[i], ret[j]) < 0
+		})
+		return ret
+	}
+
+	var ret []string
+	for _, v := range spec.Versions {
+		ret = append(ret, v.Version)
+	}
+
+	return ret
+}
+
+// mergeSpec returns the spec from the source repository, listing only the
+// versions that are mirrored. Versions that were previously mirrored but are
+// no longer in the source repository are kept.
+func (m *mirror) mergeSpec(ctx context.Context, spec pak.Spec, existing pak.Spec, hasExisting bool) pak.Spec {
+	versions := spec.Versions
+	if len(versions) == 0 {
+		versions = []pak.VersionInfo{{Version: spec.CurrentVersion}}
+	}
+
+	if hasExisting {
+		for _, v := range existing.Versions {
+			if _, ok := spec.FindVersion(v.Version); !ok && v.Version != spec.CurrentVersion {
+				versions = append(versions, v)
+			}
+		}
+	}
+
+	var mirrored []pak.VersionInfo
+	for _, v := range versions {
+		if _, err := m.dst.GetManifest(ctx, spec.ID, v.Version); err == nil {
+			mirrored = append(mirrored, v)
+		}
+	}
+
+	sort.SliceStable(mirrored, func(i, j int) bool {
+		return pak.CompareVersions(mirrored[i].Version, mirrored[j].Version) < 0
+	})
+
+	spec.Versions = mirrored
+	if len(mirrored) == 0 {
+		return spec
+	}
+
+	// the current version and channels must refer to mirrored versions
+	if _, ok := spec.FindVersion(spec.CurrentVersion); !ok {
+		spec.CurrentVersion = newestVersion(mirrored)
+	}
+
+	channels := make(map[string]string)
+	for name, v := range spec.Channels {
+		if _, ok := spec.FindVersion(v); ok {
+			channels[name] = v
+		}
+	}
+
+	spec.Channels = nil
+	if len(channels) > 0 {
+		spec.Channels = channels
+	}
+
+	return spec
+}
+
+// newestVersion returns the newest version that is not yanked, or the newest version if all are yanked.
+// versions must be sorted.
+func newestVersion(versions []pak.VersionInfo) string {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].Yanked {
+			return versions[i].Version
+		}
+	}
+
+	return versions[len(versions)-1].Version
+}
+
+// mirrorVersion copies the version of the pak, unless it has already been mirrored.
+func (m *mirror) mirrorVersion(ctx context.Context, id string, version string) error {
+	manifest, err := m.src.GetManifest(ctx, id, version)
+	if err == nil && manifest == nil {
+		err = errors.New("manifest not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	if manifest.ID != id || manifest.Version != version {
+		return fmt.Errorf("manifest is for %s@%s", manifest.ID, manifest.Version)
+	}
+
+	existing, err := m.dst.GetManifest(ctx, id, version)
+	mirrored := err == nil && sameManifest(*existing, *manifest)
+	if mirrored && !m.options.Verify {
+		m.result.Skipped++
+		return nil
+	}
+
+	if !mirrored {
+		m.infof("Mirroring %s@%s", id, version)
+	}
+
+	dir := filepath.Join(m.dir, id, version)
+	downloaded := 0
+	for _, f := range manifest.Files {
+		if err := repo.CheckPath(f.Path); err != nil {
+			return err
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if hasFile(dest, f) {
+			continue
+		}
+
+		if mirrored {
+			m.infof("Downloading %s@%s %s again", id, version, f.Path)
+		}
+
+		if err := m.download(ctx, id, version, f, dest); err != nil {
+			return err
+		}
+		downloaded++
+	}
+
+	if mirrored && downloaded == 0 {
+		m.result.Skipped++
+		return nil
+	}
+
+	// the manifest is written last, so that the version is only considered mirrored once all files are
+	if err := m.writer.WriteManifest(*manifest); err != nil {
+		return err
+	}
+
+	m.result.Versions++
+	return nil
+}
+
+// download copies the file from the source repository to dest, verifying its size and digest.
+func (m *mirror) download(ctx context.Context, id string, version string, f pak.File, dest string) error {
+	r, err := m.src.GetFile(ctx, id, version, f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+	}
+
+	// the temporary file is hidden, so that it is ignored by the fs repository
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create file in %q: %w", filepath.Dir(dest), err)
+	}
+	defer os.Remove(tmp.Name())
+
+	digest, size, err := pak.ComputeDigest(io.TeeReader(r, tmp))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download %q: %w", f.Path, err)
+	}
+
+	if err := f.Check(digest, size); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to write file %q: %w", dest, err)
+	}
+
+	m.result.Files++
+	m.result.Bytes += size
+	return nil
+}
+
+// hasFile returns true if the file at path matches the size and digest of f.
+// Files without a digest in the manifest are not trusted, and are downloaded again.
+func hasFile(path string, f pak.File) bool {
+	if f.Digest == "" {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	digest, size, err := pak.ComputeDigest(file)
+	return err == nil && f.Check(digest, size) == nil
+}
+
+// sameManifest returns true if the manifests are encoded identically.
+func sameManifest(a, b pak.Manifest) bool {
+	var aBuf, bBuf bytes.Buffer
+	if codec.YAML.WriteManifest(&aBuf, a) != nil || codec.YAML.WriteManifest(&bBuf, b) != nil {
+		return false
+	}
+
+	return bytes.Equal(aBuf.Bytes(), bBuf.Bytes())
+}
@@ -0,0 +1,204 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+	"github.com/WithoutPants/pakman/pkg/repository/memory"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// testSource is a source repository that records the files that are got,
+// and fails to get the files in fail.
+type testSource struct {
+	*memory.Repository
+	fail map[string]bool
+	got  []string
+}
+
+func (s *testSource) GetFile(ctx context.Context, id string, version string, file string) (io.ReadCloser, error) {
+	p := id + "/" + version + "/" + file
+	s.got = append(s.got, p)
+
+	if s.fail[p] {
+		return nil, errInterrupted
+	}
+
+	return s.Repository.GetFile(ctx, id, version, file)
+}
+
+// add adds a version of the pak with the given files to the source repository.
+func (s *testSource) add(t *testing.T, id string, version string, files map[string]string) {
+	t.Helper()
+
+	manifest := pak.Manifest{ID: id, Name: id, Version: version}
+	for p, content := range files {
+		digest, size, err := pak.ComputeDigest(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifest.Files = append(manifest.Files, pak.File{Path: p, Digest: digest, Size: size})
+		s.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: id, Version: version}, File: p}] = []byte(content)
+	}
+
+	// files are downloaded in the order of the manifest
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	s.Manifests[pak.InstallSpec{ID: id, Version: version}] = manifest
+
+	spec := s.Index[id]
+	spec.ID = id
+	spec.Name = id
+	spec.CurrentVersion = version
+	spec.Versions = append(spec.Versions, pak.VersionInfo{Version: version})
+	s.Index[id] = spec
+}
+
+func newTestSource(t *testing.T) *testSource {
+	t.Helper()
+
+	s := &testSource{Repository: memory.New()}
+	s.add(t, "a", "1.0.0", map[string]string{"a.txt": "a 1.0.0", "b.txt": "b 1.0.0"})
+	s.add(t, "a", "1.1.0", map[string]string{"a.txt": "a 1.1.0", "b.txt": "b 1.1.0"})
+
+	return s
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func checkResult(t *testing.T, got *Result, want Result) {
+	t.Helper()
+
+	if got == nil || got.Versions != want.Versions || got.Skipped != want.Skipped || got.Files != want.Files {
+		t.Errorf("Mirror() result = %+v, want %+v", got, want)
+	}
+}
+
+func TestMirrorResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := newTestSource(t)
+	src.fail = map[string]bool{"a/1.1.0/b.txt": true}
+
+	result, err := Mirror(ctx, src, dir, Options{})
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("Mirror() error = %v, want the interrupted download", err)
+	}
+	checkResult(t, result, Result{Versions: 1, Files: 3})
+
+	// the interrupted version is not in the index until it is complete
+	dst := &pakfs.Repository{BaseDir: dir}
+	if _, err := dst.GetManifest(ctx, "a", "1.1.0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetManifest(a@1.1.0) error = %v, want not found", err)
+	}
+
+	spec, err := dst.GetSpec(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetSpec() error = %v", err)
+	}
+	if spec.CurrentVersion != "1.0.0" || len(spec.Versions) != 1 {
+		t.Errorf("mirrored spec = %+v, want only 1.0.0", spec)
+	}
+
+	// resuming only downloads the missing file
+	src.fail = nil
+	src.got = nil
+
+	result, err = Mirror(ctx, src, dir, Options{})
+	if err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	checkResult(t, result, Result{Versions: 1, Skipped: 1, Files: 1})
+
+	if want := []string{"a/1.1.0/b.txt"}; !reflect.DeepEqual(src.got, want) {
+		t.Errorf("got files %v, want %v", src.got, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "a", "1.1.0", "b.txt")); got != "b 1.1.0" {
+		t.Errorf("b.txt = %q, want the file of 1.1.0", got)
+	}
+
+	spec, err = dst.GetSpec(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetSpec() error = %v", err)
+	}
+	if spec.CurrentVersion != "1.1.0" || len(spec.Versions) != 2 {
+		t.Errorf("mirrored spec = %+v, want 1.0.0 and 1.1.0", spec)
+	}
+}
+
+func TestMirrorVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := newTestSource(t)
+	if _, err := Mirror(ctx, src, dir, Options{}); err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+
+	p := filepath.Join(dir, "a", "1.0.0", "a.txt")
+	if err := os.WriteFile(p, []byte("a 1.0.1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// mirrored versions are skipped without verifying their files
+	src.got = nil
+	result, err := Mirror(ctx, src, dir, Options{})
+	if err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	checkResult(t, result, Result{Skipped: 2})
+	if len(src.got) != 0 {
+		t.Errorf("got files %v, want none", src.got)
+	}
+
+	// files whose digest does not match are downloaded again
+	result, err = Mirror(ctx, src, dir, Options{Verify: true})
+	if err != nil {
+		t.Fatalf("Mirror() error = %v", err)
+	}
+	checkResult(t, result, Result{Versions: 1, Skipped: 1, Files: 1})
+
+	if want := []string{"a/1.0.0/a.txt"}; !reflect.DeepEqual(src.got, want) {
+		t.Errorf("got files %v, want %v", src.got, want)
+	}
+	if got := readFile(t, p); got != "a 1.0.0" {
+		t.Errorf("a.txt = %q, want the file of the source", got)
+	}
+
+	// files in the source whose digest does not match are not written
+	src.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: "a", Version: "1.0.0"}, File: "a.txt"}] = []byte("a 1.0.2")
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Mirror(ctx, src, dir, Options{Verify: true})
+	var mismatch pak.DigestMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Path != "a.txt" {
+		t.Errorf("Mirror() error = %v, want DigestMismatchError for a.txt", err)
+	}
+	if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a.txt was written: %v", err)
+	}
+}
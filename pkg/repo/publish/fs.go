@@ -0,0 +1,172 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repo/build"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+// LockPath is the path, relative to the repository directory, of the file
+// that prevents concurrent publishing to an FS repository.
+const LockPath = ".publish.lock"
+
+// FS publishes paks to a source repository on the file system, laid out as
+// read by the fs repository. The published version is added to the index with
+// build.AddVersion, using the options that the repository was built with, as
+// determined by build.Detect. Existing versions and their manifests are not changed.
+type FS struct {
+	Dir string
+}
+
+// Publish copies the files of the package to <Dir>/<id>/<version>, and updates the index.
+// The files are copied to a temporary directory that is renamed once complete,
+// and the index is replaced atomically. If the index cannot be updated, the
+// version directory is removed, and the spec and change files written are
+// restored by build.AddVersion.
+func (p *FS) Publish(ctx context.Context, pkg Package) error {
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	id, version := pkg.Manifest.ID, pkg.Manifest.Version
+
+	options, err := build.Detect(ctx, p.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read repository: %w", err)
+	}
+
+	if err := p.checkExists(ctx, id, version); err != nil {
+		return err
+	}
+
+	pakDir := filepath.Join(p.Dir, id)
+	if err := os.MkdirAll(pakDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", pakDir, err)
+	}
+
+	// the staging directory is hidden so that it is ignored when building
+	staging, err := os.MkdirTemp(pakDir, "."+version+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create directory in %q: %w", pakDir, err)
+	}
+	defer os.RemoveAll(staging)
+
+	for _, f := range pkg.Manifest.Files {
+		if err := copyFile(pkg, f, filepath.Join(staging, filepath.FromSlash(f.Path))); err != nil {
+			return err
+		}
+	}
+
+	if err := writeManifest(staging, options.Codec, pkg.Manifest); err != nil {
+		return err
+	}
+
+	versionDir := filepath.Join(pakDir, version)
+	if err := os.Rename(staging, versionDir); err != nil {
+		return fmt.Errorf("failed to move files to %q: %w", versionDir, err)
+	}
+
+	if _, err := build.AddVersion(ctx, p.Dir, pkg.Manifest, &pkg.Spec, options); err != nil {
+		os.RemoveAll(versionDir)
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+
+	return nil
+}
+
+// lock creates the lock file, returning a function that removes it.
+func (p *FS) lock() (func(), error) {
+	path := filepath.Join(p.Dir, LockPath)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("repository is locked by another publish; remove %q if no publish is running", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	f.Close()
+
+	return func() {
+		os.Remove(path)
+	}, nil
+}
+
+// checkExists returns an ExistsError if the version is in the index or its directory exists.
+func (p *FS) checkExists(ctx context.Context, id string, version string) error {
+	spec, err := (&pakfs.Repository{BaseDir: p.Dir}).GetSpec(ctx, id)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to get spec: %w", err)
+	}
+
+	if spec != nil {
+		if _, ok := spec.FindVersion(version); ok {
+			return ExistsError{ID: id, Version: version}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(p.Dir, id, version)); err == nil {
+		return ExistsError{ID: id, Version: version}
+	}
+
+	return nil
+}
+
+// copyFile copies the file of the package to dest, checking its size and digest.
+func copyFile(pkg Package, f pak.File, dest string) error {
+	in, err := pkg.Open(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", f.Path, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", dest, err)
+	}
+	defer out.Close()
+
+	digest, size, err := pak.ComputeDigest(io.TeeReader(in, out))
+	if err != nil {
+		return fmt.Errorf("failed to write file %q: %w", dest, err)
+	}
+
+	// the file may have changed since the package was loaded
+	if err := f.Check(digest, size); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+func writeManifest(dir string, c codec.Codec, manifest pak.Manifest) error {
+	if c == nil {
+		c = codec.YAML
+	}
+
+	path := filepath.Join(dir, pak.ManifestName+codec.Extension(c))
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := c.WriteManifest(f, manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,161 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/repo/build"
+	pakfs "github.com/WithoutPants/pakman/pkg/repository/fs"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// publish publishes the pak defined in a new working directory.
+func publish(t *testing.T, dir string, definition string, version string) error {
+	t.Helper()
+
+	workDir := t.TempDir()
+	writeFile(t, filepath.Join(workDir, DefinitionPath), definition)
+	writeFile(t, filepath.Join(workDir, "plugin.txt"), definition+version)
+
+	pkg, err := Load(workDir, version)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	return (&FS{Dir: dir}).Publish(context.Background(), *pkg)
+}
+
+// TestBuildPublishBuild checks that publishing to a built repository only adds
+// the published versions, and that building it again changes nothing.
+func TestBuildPublishBuild(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	r := &pakfs.Repository{BaseDir: dir}
+
+	writeFile(t, filepath.Join(dir, "a", "1.0.0", "plugin.txt"), "a 1.0.0")
+
+	built, err := build.Build(ctx, dir, build.Options{Sharded: true, KeepChanges: 2})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	manifestPath := filepath.Join(dir, "a", "1.0.0", pakfs.RemoteManifestPath)
+	builtManifest := readFile(t, manifestPath)
+
+	if err := publish(t, dir, "id: a\nname: A\ndescription: published\n", "1.1.0"); err != nil {
+		t.Fatalf("Publish(a@1.1.0) error = %v", err)
+	}
+	if err := publish(t, dir, "id: b\nname: B\n", "1.0.0"); err != nil {
+		t.Fatalf("Publish(b@1.0.0) error = %v", err)
+	}
+
+	err = publish(t, dir, "id: a\nname: A\n", "1.1.0")
+	var exists ExistsError
+	if !errors.As(err, &exists) {
+		t.Errorf("Publish(a@1.1.0) again error = %v, want ExistsError", err)
+	}
+
+	published, err := r.Index(ctx)
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+
+	if want := built.Revision + 2; published.Revision != want {
+		t.Errorf("published revision = %d, want %d", published.Revision, want)
+	}
+	if !published.Sharded || published.KeepChanges != 2 {
+		t.Errorf("published index sharded = %v, keepChanges = %d, want the build options", published.Sharded, published.KeepChanges)
+	}
+
+	spec, err := r.GetSpec(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetSpec(a) error = %v", err)
+	}
+
+	var versions []string
+	for _, v := range spec.Versions {
+		versions = append(versions, v.Version)
+	}
+
+	if want := []string{"1.0.0", "1.1.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions of a = %v, want %v", versions, want)
+	}
+	if spec.CurrentVersion != "1.1.0" || spec.Description != "published" {
+		t.Errorf("spec of a = %+v, want current version 1.1.0 and the published description", spec)
+	}
+
+	if got := readFile(t, manifestPath); !bytes.Equal(got, builtManifest) {
+		t.Errorf("publishing changed the manifest of a@1.0.0:\n%s\nwant:\n%s", got, builtManifest)
+	}
+
+	// the change files bring the built index up to date
+	delta, err := r.GetIndexDelta(ctx, built.Revision)
+	if err != nil {
+		t.Fatalf("GetIndexDelta(%d) error = %v", built.Revision, err)
+	}
+
+	applied, ok := built.Apply(*delta)
+	if !ok {
+		t.Fatalf("Apply(%+v) failed", delta)
+	}
+	if !reflect.DeepEqual(applied.Paks, published.Paks) {
+		t.Errorf("applied changes = %+v, want %+v", applied.Paks, published.Paks)
+	}
+
+	publishedManifest := readFile(t, filepath.Join(dir, "a", "1.1.0", pakfs.RemoteManifestPath))
+
+	options, err := build.Detect(ctx, dir)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	rebuilt, err := build.Build(ctx, dir, options)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if rebuilt.Revision != published.Revision {
+		t.Errorf("rebuilt revision = %d, want %d", rebuilt.Revision, published.Revision)
+	}
+
+	rebuilt, err = r.Index(ctx)
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	if !reflect.DeepEqual(rebuilt.Paks, published.Paks) {
+		t.Errorf("rebuilt paks = %+v, want %+v", rebuilt.Paks, published.Paks)
+	}
+
+	if got := readFile(t, manifestPath); !bytes.Equal(got, builtManifest) {
+		t.Errorf("building changed the manifest of a@1.0.0:\n%s\nwant:\n%s", got, builtManifest)
+	}
+	if got := readFile(t, filepath.Join(dir, "a", "1.1.0", pakfs.RemoteManifestPath)); !bytes.Equal(got, publishedManifest) {
+		t.Errorf("building changed the manifest of a@1.1.0:\n%s\nwant:\n%s", got, publishedManifest)
+	}
+}
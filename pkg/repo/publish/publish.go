@@ -0,0 +1,227 @@
+// Package publish adds new versions of paks to source repositories.
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repo"
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionPath is the name of the pak definition file in the working directory of a pak.
+const DefinitionPath = "pak.yml"
+
+// Definition is the contents of a pak definition file. It describes a pak
+// in its working directory, from which versions of the pak are published.
+type Definition struct {
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+
+	// Version is the version to publish, if not given when publishing.
+	Version string `yaml:"version"`
+
+	Authors     []string `yaml:"authors"`
+	License     string   `yaml:"license"`
+	Homepage    string   `yaml:"homepage"`
+	Source      string   `yaml:"source"`
+	Tags        []string `yaml:"tags"`
+	Icon        string   `yaml:"icon"`
+	Screenshots []string `yaml:"screenshots"`
+
+	// Changelog describes the changes in the published version.
+	Changelog string `yaml:"changelog"`
+
+	Host     *pak.HostRequirements `yaml:"host"`
+	Requires []pak.Dependency      `yaml:"requires"`
+	Scripts  *pak.Scripts          `yaml:"scripts"`
+
+	// Files lists the files of the pak, with their platforms, tags and config flag.
+	// If empty, all files in the working directory are included.
+	Files []pak.File `yaml:"files"`
+}
+
+// Package is a version of a pak that is ready to be published.
+type Package struct {
+	// Spec contains the metadata of the pak.
+	Spec pak.Spec
+	// Manifest is the manifest of the version, listing its files with their sizes and digests.
+	Manifest pak.Manifest
+
+	// Open opens the file of the package with the given path.
+	Open func(path string) (io.ReadCloser, error)
+}
+
+// Publisher publishes new versions of paks to a source repository.
+type Publisher interface {
+	// Publish adds the version of the pak to the repository and updates its index.
+	// It returns an ExistsError if the version is already in the repository.
+	Publish(ctx context.Context, p Package) error
+}
+
+// ExistsError is returned when publishing a version that is already in the repository.
+type ExistsError struct {
+	ID      string
+	Version string
+}
+
+func (e ExistsError) Error() string {
+	return fmt.Sprintf("%s@%s already exists in the repository", e.ID, e.Version)
+}
+
+// Load packages the pak in the working directory dir, which contains a pak
+// definition file. If version is empty, the version in the definition is used.
+// Files whose names start with "." are not included.
+func Load(dir string, version string) (*Package, error) {
+	def, err := readDefinition(filepath.Join(dir, DefinitionPath))
+	if err != nil {
+		return nil, err
+	}
+
+	if version == "" {
+		version = def.Version
+	}
+
+	if err := repo.CheckName(def.ID); err != nil {
+		return nil, fmt.Errorf("id: %w", err)
+	}
+	if err := repo.CheckName(version); err != nil {
+		return nil, fmt.Errorf("version: %w", err)
+	}
+
+	name := def.Name
+	if name == "" {
+		name = def.ID
+	}
+
+	files, err := listFiles(dir, def.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Package{
+		Spec: pak.Spec{
+			ID:          def.ID,
+			Name:        name,
+			Description: def.Description,
+			Authors:     def.Authors,
+			License:     def.License,
+			Homepage:    def.Homepage,
+			Source:      def.Source,
+			Tags:        def.Tags,
+			Icon:        def.Icon,
+			Screenshots: def.Screenshots,
+		},
+		Manifest: pak.Manifest{
+			FormatVersion: pak.FormatVersion,
+			ID:            def.ID,
+			Name:          name,
+			Version:       version,
+			Date:          pak.Time{Time: time.Now().UTC().Truncate(time.Second)},
+			Files:         files,
+			Changelog:     def.Changelog,
+			Host:          def.Host,
+			Requires:      def.Requires,
+			Scripts:       def.Scripts,
+		},
+		Open: func(path string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(dir, filepath.FromSlash(path)))
+		},
+	}, nil
+}
+
+func readDefinition(path string) (*Definition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pak definition: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	var def Definition
+	if err := decoder.Decode(&def); err != nil {
+		return nil, fmt.Errorf("failed to read pak definition %q: %w", path, err)
+	}
+
+	return &def, nil
+}
+
+// listFiles returns the files of the pak in dir with their sizes and digests.
+// If listed is empty, all files in dir except the definition file are returned.
+func listFiles(dir string, listed []pak.File) ([]pak.File, error) {
+	if len(listed) == 0 {
+		if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if d.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			rel = filepath.ToSlash(rel)
+			if rel != DefinitionPath {
+				listed = append(listed, pak.File{Path: rel})
+			}
+
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to walk %q: %w", dir, err)
+		}
+	}
+
+	ret := make([]pak.File, len(listed))
+	for i, f := range listed {
+		if err := repo.CheckPath(f.Path); err != nil {
+			return nil, err
+		}
+
+		digest, size, err := computeDigest(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %q: %w", f.Path, err)
+		}
+
+		f.Size = size
+		f.Digest = digest
+		ret[i] = f
+	}
+
+	if len(ret) == 0 {
+		return nil, errors.New("pak has no files")
+	}
+
+	return ret, nil
+}
+
+func computeDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	return pak.ComputeDigest(f)
+}
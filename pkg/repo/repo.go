@@ -0,0 +1,467 @@
+// Package repo provides support for maintaining source repositories.
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
+)
+
+// Problem is an inconsistency found in a source repository.
+type Problem struct {
+	// Path is the path of the file or directory with the problem, relative to
+	// the root of the repository. It uses forward slashes.
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ProblemsError is returned when problems are found in a source repository.
+type ProblemsError struct {
+	Problems []Problem
+}
+
+func (e ProblemsError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].String()
+	}
+
+	lines := []string{fmt.Sprintf("%d problems found:", len(e.Problems))}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// CheckPath returns an error if the path of a file in a manifest could refer
+// to a file outside of the version directory.
+func CheckPath(p string) error {
+	if p == "" {
+		return errors.New("empty path")
+	}
+
+	// paths must be relative, use forward slashes, and not contain a drive letter on any platform
+	if strings.HasPrefix(p, "/") || strings.ContainsAny(p, `\:`) {
+		return fmt.Errorf("%q is not a safe path", p)
+	}
+
+	for _, e := range strings.Split(p, "/") {
+		if e == "" || e == "." || e == ".." {
+			return fmt.Errorf("%q is not a safe path", p)
+		}
+	}
+
+	return nil
+}
+
+// CheckName returns an error if a pak ID or version cannot safely be used as a
+// directory name in a repository.
+func CheckName(name string) error {
+	if name == "" {
+		return errors.New("empty")
+	}
+
+	if strings.ContainsAny(name, `/\:`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%q is not a safe directory name", name)
+	}
+
+	return nil
+}
+
+// ListDirs returns the names of the directories in dir that do not start with ".".
+func ListDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var ret []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			ret = append(ret, e.Name())
+		}
+	}
+
+	return ret, nil
+}
+
+// ManifestNames returns the names of the manifest file of a version in each
+// encoding, and of its compressed variants.
+func ManifestNames() map[string]bool {
+	ret := make(map[string]bool)
+	for _, n := range codec.Names(pak.ManifestName) {
+		ret[n] = true
+		for _, e := range compress.Encodings() {
+			ret[n+e.Extension] = true
+		}
+	}
+
+	return ret
+}
+
+// Writer writes the files of a source repository in the layout read by the
+// fs and http repositories. Files are written atomically, and are only
+// replaced if their contents have changed.
+//
+// When a file is written, any file with the same name in another encoding,
+// and any compressed variant that was not written, is removed so that stale
+// copies are not read.
+type Writer struct {
+	// Dir is the root directory of the repository.
+	Dir string
+
+	// Codec is the encoding of the files written. If nil, codec.YAML is used.
+	Codec codec.Codec
+
+	// Compress are the encodings that compressed variants of each file are written with,
+	// in addition to the uncompressed file.
+	Compress []compress.Encoding
+
+	// Journal records the previous contents of the files written and removed,
+	// so that the changes can be rolled back. If nil, nothing is recorded.
+	Journal *Journal
+}
+
+func (w Writer) codec() codec.Codec {
+	if w.Codec == nil {
+		return codec.YAML
+	}
+
+	return w.Codec
+}
+
+// WriteIndex writes the index to <Dir>/index.yml.
+func (w Writer) WriteIndex(index pak.Index) error {
+	return w.write(w.Dir, pak.IndexName, func(out io.Writer, c codec.Codec) error {
+		return c.WriteIndex(out, index)
+	})
+}
+
+// WriteSpec writes the spec of a pak in a sharded index to <Dir>/<id>/spec.yml.
+func (w Writer) WriteSpec(spec pak.Spec) error {
+	return w.write(filepath.Join(w.Dir, spec.ID), pak.SpecName, func(out io.Writer, c codec.Codec) error {
+		return c.WriteSpec(out, spec)
+	})
+}
+
+// RemoveSpec removes the spec file of the pak with the given id in any encoding.
+func (w Writer) RemoveSpec(id string) error {
+	return w.removeEncoded(filepath.Join(w.Dir, id), pak.SpecName)
+}
+
+// WriteManifest writes the manifest to <Dir>/<id>/<version>/manifest.yml.
+// The installed files are omitted, as they are only recorded in installed manifests.
+func (w Writer) WriteManifest(manifest pak.Manifest) error {
+	manifest.InstalledFiles = nil
+	return w.write(filepath.Join(w.Dir, manifest.ID, manifest.Version), pak.ManifestName, func(out io.Writer, c codec.Codec) error {
+		return c.WriteManifest(out, manifest)
+	})
+}
+
+// WriteIndexDelta writes the changes to the index since a revision to <Dir>/changes/<from>.yml.
+func (w Writer) WriteIndexDelta(delta pak.IndexDelta) error {
+	return w.write(filepath.Join(w.Dir, pak.ChangesDir), strconv.FormatInt(delta.From, 10), func(out io.Writer, c codec.Codec) error {
+		return c.WriteIndexDelta(out, delta)
+	})
+}
+
+// RemoveIndexDelta removes the file containing the changes since the given revision in any encoding.
+func (w Writer) RemoveIndexDelta(from int64) error {
+	return w.removeEncoded(filepath.Join(w.Dir, pak.ChangesDir), strconv.FormatInt(from, 10))
+}
+
+// ReadIndexDeltas reads the files in <Dir>/changes, returning the changes keyed by the revision they are relative to.
+// Files that cannot be read are ignored.
+func (w Writer) ReadIndexDeltas() (map[int64]pak.IndexDelta, error) {
+	dir := filepath.Join(w.Dir, pak.ChangesDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changes directory: %w", err)
+	}
+
+	ret := make(map[int64]pak.IndexDelta)
+	for _, e := range entries {
+		// compressed variants have the same contents as the uncompressed file
+		c := codec.ForPath(e.Name())
+		if e.IsDir() || c == nil {
+			continue
+		}
+
+		from, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		delta, err := readIndexDelta(filepath.Join(dir, e.Name()), c)
+		if err != nil {
+			continue
+		}
+
+		ret[from] = *delta
+	}
+
+	return ret, nil
+}
+
+func readIndexDelta(path string, c codec.Codec) (*pak.IndexDelta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return c.ReadIndexDelta(f)
+}
+
+// write encodes a file in dir with the given name and the extension of the
+// codec, and writes its compressed variants.
+func (w Writer) write(dir string, name string, encode func(out io.Writer, c codec.Codec) error) error {
+	c := w.codec()
+
+	var buf bytes.Buffer
+	if err := encode(&buf, c); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+
+	path := filepath.Join(dir, name+codec.Extension(c))
+	if err := w.writeFile(path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := w.writeCompressed(path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// remove the file in other encodings
+	for _, other := range codec.Names(name) {
+		if other != filepath.Base(path) {
+			if err := w.removeVariants(filepath.Join(dir, other)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeCompressed writes the compressed variants of the file at path, and
+// removes variants in encodings that are not written.
+func (w Writer) writeCompressed(path string, data []byte) error {
+	for _, e := range compress.Encodings() {
+		variant := path + e.Extension
+		if !w.compresses(e) {
+			if err := w.removeFile(variant); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		cw, err := e.NewWriter(&buf)
+		if err != nil {
+			return fmt.Errorf("failed to compress %q: %w", path, err)
+		}
+
+		if _, err := cw.Write(data); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", path, err)
+		}
+
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", path, err)
+		}
+
+		if err := w.writeFile(variant, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w Writer) compresses(e compress.Encoding) bool {
+	for _, c := range w.Compress {
+		if c.Name == e.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w Writer) writeFile(path string, data []byte) error {
+	if err := w.Journal.save(path); err != nil {
+		return err
+	}
+
+	return WriteFile(path, data)
+}
+
+func (w Writer) removeFile(path string) error {
+	if err := w.Journal.save(path); err != nil {
+		return err
+	}
+
+	return removeFile(path)
+}
+
+// Journal records the previous contents of the files changed by a Writer.
+type Journal struct {
+	// saved are the previous contents of the changed files, in the order they were changed.
+	saved []savedFile
+	paths map[string]bool
+}
+
+type savedFile struct {
+	path string
+	// data is nil if the file did not exist
+	data []byte
+}
+
+// save records the contents of the file at path, if it has not already been recorded.
+func (j *Journal) save(path string) error {
+	if j == nil || j.paths[path] {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		data = nil
+	case err != nil:
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	case data == nil:
+		data = []byte{}
+	}
+
+	if j.paths == nil {
+		j.paths = make(map[string]bool)
+	}
+	j.paths[path] = true
+	j.saved = append(j.saved, savedFile{path: path, data: data})
+
+	return nil
+}
+
+// Rollback restores the files changed since the journal was created, or
+// since the last rollback, in the reverse order that they were changed.
+// It continues after errors, returning the first.
+func (j *Journal) Rollback() error {
+	if j == nil {
+		return nil
+	}
+
+	var ret error
+	for i := len(j.saved) - 1; i >= 0; i-- {
+		f := j.saved[i]
+
+		var err error
+		if f.data == nil {
+			err = removeFile(f.path)
+		} else {
+			err = WriteFile(f.path, f.data)
+		}
+
+		if err != nil && ret == nil {
+			ret = err
+		}
+	}
+
+	j.saved = nil
+	j.paths = nil
+	return ret
+}
+
+// WriteFile atomically writes data to the file at path, creating its directory
+// if necessary. The file is written to a temporary file in the same directory,
+// which is then renamed. If the file already has the given contents, it is not written.
+func WriteFile(path string, data []byte) error {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	return WriteFileFrom(path, bytes.NewReader(data))
+}
+
+// WriteFileFrom atomically writes the contents of r to the file at path,
+// creating its directory if necessary.
+func WriteFileFrom(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", dir, err)
+	}
+
+	// temporary files are hidden, so that they are ignored when building the repository
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file in %q: %w", dir, err)
+	}
+
+	tmp := f.Name()
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// removeEncoded removes the file in dir with the given name in any encoding, and its compressed variants.
+func (w Writer) removeEncoded(dir string, name string) error {
+	for _, n := range codec.Names(name) {
+		if err := w.removeVariants(filepath.Join(dir, n)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeVariants removes the file at path and its compressed variants.
+func (w Writer) removeVariants(path string) error {
+	if err := w.removeFile(path); err != nil {
+		return err
+	}
+
+	for _, e := range compress.Encodings() {
+		if err := w.removeFile(path + e.Extension); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %q: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package serve provides an HTTP handler that serves a source repository in
+// the layout read by the http repository.
+package serve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	pakhttp "github.com/WithoutPants/pakman/pkg/repository/http"
+)
+
+// Indexer is implemented by source repositories that can return their index
+// file, including its revision and whether it is sharded.
+type Indexer interface {
+	Index(ctx context.Context) (*pak.Index, error)
+}
+
+// IndexDeltaGetter is implemented by source repositories that can return the
+// changes to their index since a previous revision, such as the fs repository.
+type IndexDeltaGetter interface {
+	GetIndexDelta(ctx context.Context, from int64) (*pak.IndexDelta, error)
+}
+
+// Handler serves a source repository using the URL layout read by the http repository:
+//
+//	/index.yml
+//	/<id>/spec.yml
+//	/<id>/<version>/manifest.yml
+//	/<id>/<version>/<file>
+//	/changes/<revision>.yml
+//
+// The index, spec, manifest and change files may also be requested with the
+// extension of any registered codec, such as index.json. If the repository
+// implements Indexer, the index is served as returned by it, otherwise it
+// contains the specs returned by List. Change files are served if the
+// repository implements IndexDeltaGetter. Otherwise, the revision of the
+// index is not served, so that clients do not request them.
+//
+// Responses have an ETag, and conditional and range requests are supported.
+// Responses are compressed with gzip if the client accepts it, except for
+// range requests and files that are already compressed.
+type Handler struct {
+	Repository pak.SourceRepository
+
+	// ErrorLog logs errors reading from the repository. If nil, the standard logger of the log package is used.
+	ErrorLog *log.Logger
+}
+
+// New returns a Handler serving the given repository.
+func New(repository pak.SourceRepository) *Handler {
+	return &Handler{Repository: repository}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var elem []string
+	for _, e := range strings.Split(path.Clean("/"+r.URL.Path), "/") {
+		if e == "" {
+			continue
+		}
+
+		// hidden files, such as pakman state, are not served
+		if strings.HasPrefix(e, ".") {
+			http.NotFound(w, r)
+			return
+		}
+
+		elem = append(elem, e)
+	}
+
+	if len(elem) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := h.serve(w, r, elem); err != nil {
+		h.writeError(w, r, err)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, elem []string) error {
+	ctx := r.Context()
+	last := elem[len(elem)-1]
+
+	switch {
+	case len(elem) == 1 && isEncoded(elem[0], pak.IndexName):
+		return h.serveIndex(w, r, codec.ForPath(elem[0]))
+	case len(elem) >= 1 && elem[0] == pak.ChangesDir:
+		if len(elem) != 2 {
+			return fs.ErrNotExist
+		}
+
+		return h.serveIndexDelta(w, r, last)
+	case len(elem) == 2 && isEncoded(last, pak.SpecName):
+		spec, err := h.Repository.GetSpec(ctx, elem[0])
+		if err != nil {
+			return err
+		}
+		if spec == nil {
+			return fs.ErrNotExist
+		}
+
+		return serveEncoded(w, r, codec.ForPath(last), func(out io.Writer, c codec.Codec) error {
+			return c.WriteSpec(out, *spec)
+		})
+	case len(elem) == 3 && isEncoded(last, pak.ManifestName):
+		manifest, err := h.getManifest(ctx, elem[0], elem[1])
+		if err != nil {
+			return err
+		}
+
+		return serveEncoded(w, r, codec.ForPath(last), func(out io.Writer, c codec.Codec) error {
+			return c.WriteManifest(out, *manifest)
+		})
+	case len(elem) >= 3:
+		return h.serveFile(w, r, elem[0], elem[1], strings.Join(elem[2:], "/"))
+	}
+
+	return fs.ErrNotExist
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request, c codec.Codec) error {
+	var index *pak.Index
+	if indexer, ok := h.Repository.(Indexer); ok {
+		var err error
+		index, err = indexer.Index(r.Context())
+		// invalid specs are omitted
+		if _, err := pak.SplitIndexError(err); err != nil {
+			return err
+		}
+	} else {
+		paks, err := h.Repository.List(r.Context())
+		if err != nil {
+			return err
+		}
+
+		index = &pak.Index{Paks: paks}
+	}
+
+	// the changes since the revision cannot be served
+	if _, ok := h.Repository.(IndexDeltaGetter); !ok {
+		index.Revision = 0
+	}
+
+	return serveEncoded(w, r, c, func(out io.Writer, c codec.Codec) error {
+		return c.WriteIndex(out, *index)
+	})
+}
+
+// serveIndexDelta serves the change file with the given name, which is the
+// revision that the changes are relative to and the extension of a codec.
+func (h *Handler) serveIndexDelta(w http.ResponseWriter, r *http.Request, name string) error {
+	getter, ok := h.Repository.(IndexDeltaGetter)
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	base := strings.TrimSuffix(name, path.Ext(name))
+	from, err := strconv.ParseInt(base, 10, 64)
+	if err != nil || strconv.FormatInt(from, 10) != base || !isEncoded(name, base) {
+		return fs.ErrNotExist
+	}
+
+	delta, err := getter.GetIndexDelta(r.Context(), from)
+	if err != nil {
+		return err
+	}
+
+	return serveEncoded(w, r, codec.ForPath(name), func(out io.Writer, c codec.Codec) error {
+		return c.WriteIndexDelta(out, *delta)
+	})
+}
+
+func (h *Handler) getManifest(ctx context.Context, id string, version string) (*pak.Manifest, error) {
+	manifest, err := h.Repository.GetManifest(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fs.ErrNotExist
+	}
+
+	return manifest, nil
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, id string, version string, file string) error {
+	manifest, err := h.getManifest(r.Context(), id, version)
+	if err != nil {
+		return err
+	}
+
+	// only serve the files of the version
+	var digest string
+	found := false
+	for _, f := range manifest.Files {
+		if f.Path == file {
+			digest = f.Digest
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fs.ErrNotExist
+	}
+
+	rc, err := h.Repository.GetFile(r.Context(), id, version, file)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	content, ok := rc.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if digest == "" {
+		if digest, _, err = pak.ComputeDigest(content); err != nil {
+			return err
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(file))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	serveContent(w, r, file, contentType, `"`+digest+`"`, content, !isCompressed(file))
+	return nil
+}
+
+// serveEncoded encodes a file with the codec, or the yaml codec if c is nil, and serves it.
+func serveEncoded(w http.ResponseWriter, r *http.Request, c codec.Codec, encode func(out io.Writer, c codec.Codec) error) error {
+	if c == nil {
+		c = codec.YAML
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, c); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	serveContent(w, r, r.URL.Path, codec.ContentType(c), etag, bytes.NewReader(buf.Bytes()), true)
+	return nil
+}
+
+// serveContent serves the content, compressing it with gzip if compressible
+// is true and the client accepts it.
+func serveContent(w http.ResponseWriter, r *http.Request, name string, contentType string, etag string, content io.ReadSeeker, compressible bool) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if compressible {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	// ranges apply to the uncompressed content
+	if !compressible || !acceptsGzip(r) || r.Header.Get("Range") != "" {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, name, time.Time{}, content)
+		return
+	}
+
+	// the compressed representation has a different ETag
+	etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, content); err == nil {
+		gw.Close()
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(e), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressedExtensions are the extensions of file formats that are already compressed.
+var compressedExtensions = []string{".gz", ".zst", ".zip", ".7z", ".xz", ".bz2", ".png", ".jpg", ".jpeg", ".gif", ".webp", ".mp3", ".mp4", ".ogg", ".woff2"}
+
+func isCompressed(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	for _, e := range compressedExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isEncoded returns true if name is base with the extension of a registered codec.
+func isEncoded(name string, base string) bool {
+	for _, n := range codec.Names(base) {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var statusErr pakhttp.StatusError
+	if errors.Is(err, fs.ErrNotExist) || errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		http.NotFound(w, r)
+		return
+	}
+
+	logf := log.Printf
+	if h.ErrorLog != nil {
+		logf = h.ErrorLog.Printf
+	}
+	logf("error serving %s: %v", r.URL.Path, err)
+
+	// the error may contain details of the server, such as file paths
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,237 @@
+package serve_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/repo/serve"
+	pakhttp "github.com/WithoutPants/pakman/pkg/repository/http"
+	"github.com/WithoutPants/pakman/pkg/repository/memory"
+)
+
+const pluginContent = "the plugin file of a"
+
+// newServer serves a repository containing version 1.0.0 of pak a.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	source := memory.New()
+	source.Index["a"] = pak.Spec{ID: "a", Name: "A", CurrentVersion: "1.0.0"}
+	source.Manifests[pak.InstallSpec{ID: "a", Version: "1.0.0"}] = pak.Manifest{
+		ID:      "a",
+		Name:    "A",
+		Version: "1.0.0",
+		Files:   []pak.File{{Path: "plugin.txt"}},
+	}
+	source.Files[memory.FileSpec{InstallSpec: pak.InstallSpec{ID: "a", Version: "1.0.0"}, File: "plugin.txt"}] = []byte(pluginContent)
+
+	server := httptest.NewServer(serve.New(source))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// recordingTransport records the path and Content-Encoding of each response.
+type recordingTransport struct {
+	mu        sync.Mutex
+	paths     []string
+	encodings []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paths = append(t.paths, req.URL.Path)
+	t.encodings = append(t.encodings, resp.Header.Get("Content-Encoding"))
+
+	return resp, nil
+}
+
+func get(t *testing.T, server *httptest.Server, path string, header map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func readBody(t *testing.T, r io.Reader) string {
+	t.Helper()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestRepository(t *testing.T) {
+	server := newServer(t)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &recordingTransport{}
+	repo := pakhttp.New(*u, &http.Client{Transport: transport})
+	ctx := context.Background()
+
+	specs, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if spec, ok := specs["a"]; !ok || spec.Name != "A" || spec.CurrentVersion != "1.0.0" {
+		t.Errorf("List() = %+v, want a@1.0.0", specs)
+	}
+
+	manifest, err := repo.GetManifest(ctx, "a", "1.0.0")
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	if manifest == nil || len(manifest.Files) != 1 || manifest.Files[0].Path != "plugin.txt" {
+		t.Errorf("GetManifest() = %+v, want the manifest of a@1.0.0", manifest)
+	}
+
+	f, err := repo.GetFile(ctx, "a", "1.0.0", "plugin.txt")
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	defer f.Close()
+
+	if got := readBody(t, f); got != pluginContent {
+		t.Errorf("GetFile() = %q, want %q", got, pluginContent)
+	}
+
+	// each file takes a single request, and the responses are compressed
+	wantPaths := []string{"/index.yml", "/a/1.0.0/manifest.yml", "/a/1.0.0/plugin.txt"}
+	if !reflect.DeepEqual(transport.paths, wantPaths) {
+		t.Errorf("requested %v, want %v", transport.paths, wantPaths)
+	}
+	for i, e := range transport.encodings {
+		if e != "gzip" {
+			t.Errorf("Content-Encoding of %s = %q, want gzip", transport.paths[i], e)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	server := newServer(t)
+
+	resp := get(t, server, "/a/1.0.0/plugin.txt", map[string]string{"Accept-Encoding": "zstd, gzip;q=0.5"})
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readBody(t, gr); got != pluginContent {
+		t.Errorf("decompressed body = %q, want %q", got, pluginContent)
+	}
+
+	for _, accept := range []string{"identity", "gzip;q=0"} {
+		resp := get(t, server, "/a/1.0.0/plugin.txt", map[string]string{"Accept-Encoding": accept})
+		if got := resp.Header.Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want none", accept, got)
+		}
+		if got := readBody(t, resp.Body); got != pluginContent {
+			t.Errorf("Accept-Encoding %q: body = %q, want %q", accept, got, pluginContent)
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	server := newServer(t)
+
+	for _, accept := range []string{"gzip", "identity"} {
+		header := map[string]string{"Accept-Encoding": accept}
+
+		resp := get(t, server, "/index.yml", header)
+		etag := resp.Header.Get("ETag")
+		if resp.StatusCode != http.StatusOK || etag == "" {
+			t.Fatalf("Accept-Encoding %q: status = %d, ETag = %q, want 200 with an ETag", accept, resp.StatusCode, etag)
+		}
+
+		header["If-None-Match"] = etag
+		if resp := get(t, server, "/index.yml", header); resp.StatusCode != http.StatusNotModified {
+			t.Errorf("Accept-Encoding %q: conditional status = %d, want 304", accept, resp.StatusCode)
+		}
+
+		header["If-None-Match"] = `"other"`
+		if resp := get(t, server, "/index.yml", header); resp.StatusCode != http.StatusOK {
+			t.Errorf("Accept-Encoding %q: status with another ETag = %d, want 200", accept, resp.StatusCode)
+		}
+	}
+
+	// the compressed and uncompressed representations have different ETags
+	gzipped := get(t, server, "/index.yml", map[string]string{"Accept-Encoding": "gzip"}).Header.Get("ETag")
+	plain := get(t, server, "/index.yml", map[string]string{"Accept-Encoding": "identity"}).Header.Get("ETag")
+	if gzipped == plain {
+		t.Errorf("gzip ETag = uncompressed ETag = %s, want different", plain)
+	}
+}
+
+func TestRange(t *testing.T) {
+	server := newServer(t)
+
+	// ranges apply to the uncompressed content
+	resp := get(t, server, "/a/1.0.0/plugin.txt", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=4-9"})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if got, want := readBody(t, resp.Body), pluginContent[4:10]; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+
+	resp = get(t, server, "/a/1.0.0/plugin.txt", map[string]string{"Range": "bytes=100-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("status of unsatisfiable range = %d, want 416", resp.StatusCode)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	server := newServer(t)
+
+	for _, path := range []string{"/", "/b/spec.yml", "/a/2.0.0/manifest.yml", "/a/1.0.0/other.txt", "/.pakman/state.yml", "/changes/1.yml"} {
+		if resp := get(t, server, path, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want 404", path, resp.StatusCode)
+		}
+	}
+
+	if resp := get(t, server, "/a/spec.json", nil); resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("GET /a/spec.json status = %d, Content-Type = %q, want json", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,157 @@
+// Package compress provides the compression encodings supported by repositories.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxSize is the default limit on the decompressed size of a file, in bytes.
+const DefaultMaxSize int64 = 1 << 30
+
+// Encoding is a compression encoding.
+type Encoding struct {
+	// Name is the name of the encoding in the Content-Encoding and Accept-Encoding headers.
+	Name string
+	// Extension is the extension of files compressed with the encoding, including the leading dot.
+	Extension string
+
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	Zstd = Encoding{
+		Name:      "zstd",
+		Extension: ".zst",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			// the memory used by the decoder is limited by its maximum window size
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+	}
+
+	Gzip = Encoding{
+		Name:      "gzip",
+		Extension: ".gz",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	}
+)
+
+// Encodings returns the supported encodings, in order of preference.
+func Encodings() []Encoding {
+	return []Encoding{Zstd, Gzip}
+}
+
+// AcceptEncoding returns the value of the Accept-Encoding header for the supported encodings.
+func AcceptEncoding() string {
+	var names []string
+	for _, e := range Encodings() {
+		names = append(names, e.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// ForName returns the encoding with the given Content-Encoding name, or nil if it is not supported.
+func ForName(name string) *Encoding {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, e := range Encodings() {
+		if e.Name == name {
+			return &e
+		}
+	}
+
+	return nil
+}
+
+// ForPath returns the encoding of the extension of the given file name or path,
+// or nil if it is not the extension of a supported encoding.
+func ForPath(name string) *Encoding {
+	for _, e := range Encodings() {
+		if strings.HasSuffix(name, e.Extension) {
+			return &e
+		}
+	}
+
+	return nil
+}
+
+// LimitError is returned when the decompressed size of a file exceeds the limit.
+type LimitError struct {
+	Limit int64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("decompressed size exceeds the limit of %d bytes", e.Limit)
+}
+
+// NewReader returns a reader that decompresses r. Reading returns a LimitError
+// once more than maxSize bytes have been decompressed. If maxSize is zero,
+// DefaultMaxSize is used. Closing the returned reader closes r.
+func (e Encoding) NewReader(r io.ReadCloser, maxSize int64) (io.ReadCloser, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	d, err := e.newReader(r)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("reading %s data: %w", e.Name, err)
+	}
+
+	return &limitedReader{
+		decoder:   d,
+		src:       r,
+		limit:     maxSize,
+		remaining: maxSize,
+	}, nil
+}
+
+// NewWriter returns a writer that compresses the data written to it, writing to w.
+// The returned writer must be closed to flush the compressed data. It does not close w.
+func (e Encoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return e.newWriter(w)
+}
+
+type limitedReader struct {
+	decoder   io.ReadCloser
+	src       io.Closer
+	limit     int64
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// read one byte more than remaining to detect that the limit is exceeded
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.decoder.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n + int(r.remaining), LimitError{Limit: r.limit}
+	}
+
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	r.decoder.Close()
+	return r.src.Close()
+}
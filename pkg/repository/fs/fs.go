@@ -9,15 +9,31 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/WithoutPants/pakman/pkg/pak"
 	"github.com/WithoutPants/pakman/pkg/pak/yaml"
+	"github.com/WithoutPants/pakman/pkg/repository/iofs"
 )
 
 const (
 	IndexPath          = "index.yml"
 	ManifestPath       = "manifest"
 	RemoteManifestPath = "manifest.yml"
+
+	// StateDir is the directory, relative to the BaseDir, that stores pakman state.
+	StateDir = ".pakman"
+	// DisabledDir is the directory, relative to the StateDir, that disabled paks are moved to.
+	DisabledDir = "disabled"
+	// ArchiveDir is the directory, relative to the StateDir, that previous versions of paks are kept in.
+	ArchiveDir = "archive"
+	// HistoryPath is the path, relative to the StateDir, of the history log.
+	HistoryPath = "history.yml"
+	// ManifestsDir is the directory, relative to the StateDir, that manifests are stored in
+	// when using a shared root.
+	ManifestsDir = "manifests"
 )
 
 // Repository is a writable file system based repository.
@@ -26,8 +42,40 @@ const (
 //	<BaseDir>/<id>
 //
 // The manifest is stored in manifest in the same directory.
+//
+// Disabled paks are moved to the following directory, so that they are ignored
+// by applications scanning the BaseDir:
+//
+//	<BaseDir>/.pakman/disabled/<id>
+//
+// Previous versions of paks are kept in the following directory:
+//
+//	<BaseDir>/.pakman/archive/<id>/<version>
+//
+// The history of operations is appended to <BaseDir>/.pakman/history.yml.
+//
+// When used as a SourceRepository, the index is read from <BaseDir>/index.yml
+// and manifests are read from <BaseDir>/<id>/<version>/manifest.yml. Files
+// with the extension of another registered codec, such as index.json, are
+// also read. If the index is sharded, the spec of each pak is read from
+// <BaseDir>/<id>/spec.yml.
+//
+// If a file does not exist, a compressed variant of it, such as index.yml.zst
+// or index.yml.gz, is read and decompressed.
+//
+// If SharedRoot is true, the files of all paks are stored directly in the
+// BaseDir, and manifests are stored in <BaseDir>/.pakman/manifests/<id>.
+// Disabled paks are stored as above.
 type Repository struct {
 	BaseDir string
+
+	// SharedRoot is true if the files of all paks are stored in the BaseDir,
+	// rather than in a directory per pak.
+	SharedRoot bool
+
+	// MaxDecompressedSize is the limit on the decompressed size of compressed
+	// files read when used as a SourceRepository. If zero, compress.DefaultMaxSize is used.
+	MaxDecompressedSize int64
 }
 
 // GetInstalledManifest gets the manifest for the given id.
@@ -44,16 +92,80 @@ func (r *Repository) GetInstalledManifest(ctx context.Context, id string) (*pak.
 	return manifest, nil
 }
 
+// String returns the BaseDir of the repository.
+func (r *Repository) String() string {
+	return r.BaseDir
+}
+
+// SharesInstallRoot returns true if the files of all paks are stored in the same directory.
+func (r *Repository) SharesInstallRoot() bool {
+	return r.SharedRoot
+}
+
+// InstallDir returns the directory that the pak with the given id is installed in.
+func (r *Repository) InstallDir(ctx context.Context, id string) (string, error) {
+	return filepath.Abs(r.pakDir(id))
+}
+
+// activeDir returns the directory that the files of the enabled pak with the given id are stored in.
+func (r *Repository) activeDir(id string) string {
+	if r.SharedRoot {
+		return r.BaseDir
+	}
+
+	return filepath.Join(r.BaseDir, id)
+}
+
+// activeManifestPath returns the path of the manifest of the enabled pak with the given id.
+func (r *Repository) activeManifestPath(id string) string {
+	if r.SharedRoot {
+		return filepath.Join(r.manifestsDir(), id)
+	}
+
+	return filepath.Join(r.activeDir(id), ManifestPath)
+}
+
+func (r *Repository) manifestsDir() string {
+	return filepath.Join(r.BaseDir, StateDir, ManifestsDir)
+}
+
+func (r *Repository) disabledDir(id string) string {
+	return filepath.Join(r.BaseDir, StateDir, DisabledDir, id)
+}
+
+func (r *Repository) isDisabled(id string) bool {
+	_, err := os.Stat(filepath.Join(r.disabledDir(id), ManifestPath))
+	return err == nil
+}
+
+// pakDir returns the directory that the files of the pak with the given id are stored in.
+// This is the disabled directory if the pak is disabled, otherwise the active directory.
+func (r *Repository) pakDir(id string) string {
+	if r.isDisabled(id) {
+		return r.disabledDir(id)
+	}
+
+	return r.activeDir(id)
+}
+
 func (r *Repository) manifestPath(id string) string {
-	return filepath.Join(r.BaseDir, id, ManifestPath)
+	if r.isDisabled(id) {
+		return filepath.Join(r.disabledDir(id), ManifestPath)
+	}
+
+	return r.activeManifestPath(id)
 }
 
 func (r *Repository) filePath(id string, name string) string {
-	return filepath.Join(r.BaseDir, id, name)
+	return filepath.Join(r.pakDir(id), name)
 }
 
 func (r *Repository) getManifest(id string) (*pak.Manifest, error) {
-	f, err := os.Open(r.manifestPath(id))
+	return readManifestFile(r.manifestPath(id))
+}
+
+func readManifestFile(path string) (*pak.Manifest, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -72,10 +184,14 @@ func (r *Repository) ListInstalled(ctx context.Context) ([]pak.Manifest, error)
 		}
 
 		if info.IsDir() {
+			// don't look for installed paks in the archive
+			if path == filepath.Join(r.BaseDir, StateDir, ArchiveDir) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		if info.Name() != ManifestPath {
+		if info.Name() != ManifestPath && filepath.Dir(path) != r.manifestsDir() {
 			return nil
 		}
 
@@ -108,6 +224,7 @@ func (r *Repository) ListInstalled(ctx context.Context) ([]pak.Manifest, error)
 }
 
 // Write writes the given file to the repository, in the following location: <BaseDir>/<id>/<file>
+// If using a shared root, the file is written to <BaseDir>/<file>.
 func (r *Repository) Write(ctx context.Context, id string, version string, file string, data io.Reader) error {
 	path := r.filePath(id, file)
 
@@ -131,10 +248,13 @@ func (r *Repository) Write(ctx context.Context, id string, version string, file
 	return nil
 }
 
-// WriteManifest writes the given manifest to the repository. The manifest file is stored in <BaseDir>/<id>/manifest.
+// WriteManifest writes the given manifest to the repository. The manifest file is stored in <BaseDir>/<id>/manifest,
+// or <BaseDir>/.pakman/manifests/<id> if using a shared root.
 func (r *Repository) WriteManifest(ctx context.Context, manifest pak.Manifest) error {
-	path := r.manifestPath(manifest.ID)
+	return writeManifestFile(r.manifestPath(manifest.ID), manifest)
+}
 
+func writeManifestFile(path string, manifest pak.Manifest) error {
 	dir := filepath.Dir(path)
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -156,7 +276,7 @@ func (r *Repository) WriteManifest(ctx context.Context, manifest pak.Manifest) e
 }
 
 // Delete deletes the pak with the given id from the repository.
-// It will remove the manifest file and all files listed in the manifest.
+// It will remove the manifest file and all files installed from the manifest.
 // If the directory is empty after the files are removed, it will also be removed.
 func (r *Repository) Delete(ctx context.Context, id string) error {
 	manifest, err := r.GetInstalledManifest(ctx, id)
@@ -169,12 +289,16 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 		return nil
 	}
 
-	// only remove the files listed by the manifest
-	for _, f := range manifest.Files {
-		path := r.filePath(id, f)
+	dir := r.pakDir(id)
+
+	// only remove the files installed by the manifest
+	for _, f := range manifest.LocalFiles() {
+		path := filepath.Join(dir, f)
 		if err := os.Remove(path); err != nil {
 			return fmt.Errorf("failed to remove file %q: %w", path, err)
 		}
+
+		r.removeEmptyDirs(filepath.Dir(path), dir)
 	}
 
 	// remove the manifest
@@ -183,86 +307,460 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 	}
 
 	// remove the directory if it is empty - ignore errors
-	_ = os.Remove(filepath.Dir(r.manifestPath(id)))
+	if dir != r.BaseDir {
+		_ = os.Remove(dir)
+	}
 
 	return nil
 }
 
-// GetManifest gets the manifest for the given id and version.
-// This method is used when the Repository is being used as a SourceRepository.
-// If version is empty then the latest version is returned.
-func (r *Repository) GetManifest(ctx context.Context, id string, version string) (*pak.Manifest, error) {
-	f, err := os.Open(r.remoteManifestPath(id, version))
-	if err != nil {
-		return nil, err
+// SetEnabled moves the pak with the given id to the active directory if enabled
+// is true, or to the disabled directory otherwise.
+func (r *Repository) SetEnabled(ctx context.Context, id string, enabled bool) error {
+	if enabled == !r.isDisabled(id) {
+		return nil
 	}
-	defer f.Close()
 
-	manifest, err := yaml.ReadManifest(f)
+	if r.SharedRoot {
+		return r.moveSharedPak(ctx, id, enabled)
+	}
+
+	src := r.pakDir(id)
+	dest := r.disabledDir(id)
+	if enabled {
+		dest = r.activeDir(id)
+	}
+
+	if src == dest {
+		return nil
+	}
+
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("cannot move pak to %q: destination already exists", dest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+	}
+
+	if err := os.Rename(src, dest); err != nil {
+		return fmt.Errorf("failed to move pak to %q: %w", dest, err)
+	}
+
+	return nil
+}
+
+// moveSharedPak moves the files and manifest of the pak with the given id
+// between the shared root and the disabled directory.
+func (r *Repository) moveSharedPak(ctx context.Context, id string, enabled bool) error {
+	manifest, err := r.GetInstalledManifest(ctx, id)
 	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	if manifest == nil {
+		return nil
+	}
+
+	srcDir, srcManifest := r.disabledDir(id), filepath.Join(r.disabledDir(id), ManifestPath)
+	destDir, destManifest := r.activeDir(id), r.activeManifestPath(id)
+	if !enabled {
+		srcDir, destDir = destDir, srcDir
+		srcManifest, destManifest = destManifest, srcManifest
+	}
+
+	for _, f := range manifest.LocalFiles() {
+		if err := moveFile(filepath.Join(srcDir, f), filepath.Join(destDir, f)); err != nil {
+			return err
+		}
+
+		r.removeEmptyDirs(filepath.Dir(filepath.Join(srcDir, f)), srcDir)
+	}
+
+	// move the manifest last, so that the pak is only moved once all files are moved
+	if err := moveFile(srcManifest, destManifest); err != nil {
+		return err
+	}
+
+	if srcDir != r.BaseDir {
+		_ = os.Remove(srcDir)
+	}
+
+	return nil
+}
+
+func moveFile(src string, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+	}
+
+	if err := os.Rename(src, dest); err != nil {
+		return fmt.Errorf("failed to move file %q: %w", src, err)
+	}
+
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to but not including stop,
+// while they are empty.
+func (r *Repository) removeEmptyDirs(dir string, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop) {
+		// os.Remove fails if the directory is not empty
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
+
+// GetInstalledFile opens the installed file of the pak with the given id.
+func (r *Repository) GetInstalledFile(ctx context.Context, id string, file string) (io.ReadCloser, error) {
+	return os.Open(r.filePath(id, file))
+}
+
+// ListInstalledFiles returns the paths of all files in the directory of the pak
+// with the given id, excluding the manifest.
+//
+// When using a shared root, the files of enabled paks cannot be distinguished
+// from those of other paks, so only the existing files installed from the
+// manifest are returned.
+func (r *Repository) ListInstalledFiles(ctx context.Context, id string) ([]string, error) {
+	dir := r.pakDir(id)
+
+	if dir == r.BaseDir {
+		return r.listExistingFiles(ctx, id)
+	}
+
+	var ret []string
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if rel != ManifestPath {
+			ret = append(ret, rel)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk pak directory: %w", err)
+	}
+
+	return ret, nil
+}
+
+func (r *Repository) listExistingFiles(ctx context.Context, id string) ([]string, error) {
+	manifest, err := r.GetInstalledManifest(ctx, id)
+	if err != nil || manifest == nil {
 		return nil, err
 	}
 
-	return manifest, nil
+	var ret []string
+	for _, f := range manifest.LocalFiles() {
+		if _, err := os.Stat(r.filePath(id, f)); err == nil {
+			ret = append(ret, f)
+		}
+	}
+
+	return ret, nil
 }
 
-func (r *Repository) remoteManifestPath(id string, version string) string {
-	return filepath.Join(r.BaseDir, id, version, RemoteManifestPath)
+func (r *Repository) archiveDir(id string) string {
+	return filepath.Join(r.BaseDir, StateDir, ArchiveDir, id)
 }
 
-// GetSpec gets the spec for the given id.
-// This method is used when the Repository is being used as a SourceRepository.
-func (r *Repository) GetSpec(ctx context.Context, id string) (*pak.Spec, error) {
-	index, err := r.getIndex(ctx)
+func (r *Repository) archiveVersionDir(id string, version string) string {
+	return filepath.Join(r.archiveDir(id), version)
+}
+
+// Archive copies the installed version of the pak with the given id to <BaseDir>/.pakman/archive/<id>/<version>.
+// The time that it was archived is recorded in the archived manifest.
+func (r *Repository) Archive(ctx context.Context, id string) error {
+	manifest, err := r.GetInstalledManifest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	if manifest == nil {
+		return nil
+	}
+
+	dest := r.archiveVersionDir(id, manifest.Version)
+
+	// replace any existing archive of the same version
+	if err := os.RemoveAll(dest); err != nil {
+		return fmt.Errorf("failed to remove %q: %w", dest, err)
+	}
+
+	src := r.pakDir(id)
+	for _, f := range manifest.LocalFiles() {
+		if err := copyFile(filepath.Join(src, f), filepath.Join(dest, f)); err != nil {
+			return err
+		}
+	}
+
+	// record the time with nanoseconds, so that archives made in the same second are ordered
+	archived, err := pak.ParseTime(time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+
+	manifest.Archived = &archived
+	return writeManifestFile(filepath.Join(dest, ManifestPath), *manifest)
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", src, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(dest), err)
+	}
+
+	out, err := os.Create(dest)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get index: %w", err)
+		return fmt.Errorf("failed to create file %q: %w", dest, err)
 	}
+	defer out.Close()
 
-	spec, ok := index[id]
-	if !ok {
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to write file %q: %w", dest, err)
+	}
+
+	return nil
+}
+
+// ListArchived returns the manifests of the archived versions of the pak with the given id,
+// most recently archived first. Archives are ordered by the time recorded in
+// their manifest, or the modification time of the manifest if it was not
+// recorded. Archives with the same time are ordered by version, newest first.
+func (r *Repository) ListArchived(ctx context.Context, id string) ([]pak.Manifest, error) {
+	entries, err := os.ReadDir(r.archiveDir(id))
+	if errors.Is(err, os.ErrNotExist) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	type archived struct {
+		manifest pak.Manifest
+		time     time.Time
+	}
+
+	var found []archived
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(r.archiveDir(id), e.Name(), ManifestPath)
+		info, err := os.Stat(path)
+		if err != nil {
+			// ignore incomplete archives
+			continue
+		}
+
+		manifest, err := readManifestFile(path)
+		if err != nil {
+			continue
+		}
+
+		t := info.ModTime()
+		if manifest.Archived != nil {
+			t = manifest.Archived.Time
+		}
+
+		found = append(found, archived{manifest: *manifest, time: t})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if !found[i].time.Equal(found[j].time) {
+			return found[i].time.After(found[j].time)
+		}
+
+		return pak.CompareVersions(found[i].manifest.Version, found[j].manifest.Version) > 0
+	})
+
+	ret := make([]pak.Manifest, len(found))
+	for i, f := range found {
+		ret[i] = f.manifest
+	}
 
-	return &spec, nil
+	return ret, nil
 }
 
-func (r *Repository) getIndex(ctx context.Context) (pak.SpecIndex, error) {
-	path := filepath.Join(r.BaseDir, IndexPath)
+// Restore moves the archived version of the pak with the given id to the active directory.
+func (r *Repository) Restore(ctx context.Context, id string, version string) error {
+	src := r.archiveVersionDir(id, version)
 
-	f, err := os.Open(path)
+	manifest, err := readManifestFile(filepath.Join(src, ManifestPath))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get index file: %w", err)
+		return fmt.Errorf("failed to read archived manifest: %w", err)
+	}
+
+	if existing, err := r.GetInstalledManifest(ctx, id); err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	} else if existing != nil {
+		return fmt.Errorf("pak %s is installed", id)
 	}
 
+	dest := r.activeDir(id)
+	for _, f := range manifest.LocalFiles() {
+		if err := moveFile(filepath.Join(src, f), filepath.Join(dest, f)); err != nil {
+			return err
+		}
+	}
+
+	// write the manifest last, so that the pak is not considered installed until all files are moved
+	manifest.Archived = nil
+	if err := writeManifestFile(r.activeManifestPath(id), *manifest); err != nil {
+		return err
+	}
+
+	return r.DeleteArchived(ctx, id, version)
+}
+
+// DeleteArchived removes the archived version of the pak with the given id.
+func (r *Repository) DeleteArchived(ctx context.Context, id string, version string) error {
+	if err := os.RemoveAll(r.archiveVersionDir(id, version)); err != nil {
+		return fmt.Errorf("failed to remove archived version: %w", err)
+	}
+
+	// remove the directory if it is empty - ignore errors
+	_ = os.Remove(r.archiveDir(id))
+
+	return nil
+}
+
+func (r *Repository) historyPath() string {
+	return filepath.Join(r.BaseDir, StateDir, HistoryPath)
+}
+
+// AppendHistory appends the entry to the history log.
+func (r *Repository) AppendHistory(ctx context.Context, entry pak.HistoryEntry) error {
+	path := r.historyPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(path), err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
 	defer f.Close()
 
-	index, err := yaml.ReadSpecIndex(f)
+	if err := yaml.WriteHistoryEntry(f, entry); err != nil {
+		return fmt.Errorf("failed to write history entry: %w", err)
+	}
+
+	return nil
+}
+
+// History returns all entries in the history log, oldest first.
+func (r *Repository) History(ctx context.Context) ([]pak.HistoryEntry, error) {
+	f, err := os.Open(r.historyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read index file: %w", err)
+		return nil, fmt.Errorf("failed to open history file: %w", err)
 	}
+	defer f.Close()
 
-	return *index, nil
+	return yaml.ReadHistory(f)
 }
 
-// List returns all specs in the repository.
+// source returns the repository that reads the files of the source repository
+// in the BaseDir. The source repository methods are implemented by it.
+func (r *Repository) source() *iofs.Repository {
+	dir := r.BaseDir
+	if dir == "" {
+		dir = "."
+	}
+
+	return &iofs.Repository{
+		FS:                  dirFS{FS: os.DirFS(dir), dir: dir},
+		Name:                r.BaseDir,
+		MaxDecompressedSize: r.MaxDecompressedSize,
+	}
+}
+
+// dirFS is the FS returned by os.DirFS, whose errors refer to files by their
+// path in the directory rather than their path in the FS.
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	f, err := d.FS.Open(name)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return nil, &fs.PathError{Op: pathErr.Op, Path: filepath.Join(d.dir, filepath.FromSlash(name)), Err: pathErr.Err}
+	}
+
+	return f, err
+}
+
+// GetManifest gets the manifest for the given id and version.
+// This method is used when the Repository is being used as a SourceRepository.
+func (r *Repository) GetManifest(ctx context.Context, id string, version string) (*pak.Manifest, error) {
+	return r.source().GetManifest(ctx, id, version)
+}
+
+// GetSpec gets the spec for the given id.
+// If the index is sharded, the spec is read from <BaseDir>/<id>/spec.yml.
+// This method is used when the Repository is being used as a SourceRepository.
+func (r *Repository) GetSpec(ctx context.Context, id string) (*pak.Spec, error) {
+	return r.source().GetSpec(ctx, id)
+}
+
+// Index reads the index of the repository.
+// Invalid specs in the index are omitted, and a pak.IndexError is returned along with the index.
+// This method is used when the Repository is being used as a SourceRepository.
+func (r *Repository) Index(ctx context.Context) (*pak.Index, error) {
+	return r.source().Index(ctx)
+}
+
+// GetIndexDelta reads the changes to the index since the given revision from <BaseDir>/changes/<from>.yml.
+// This method is used when the Repository is being used as a SourceRepository.
+func (r *Repository) GetIndexDelta(ctx context.Context, from int64) (*pak.IndexDelta, error) {
+	return r.source().GetIndexDelta(ctx, from)
+}
+
+// List returns the full spec of every pak in the repository. Specs that are
+// invalid in the index are omitted. If the index is sharded, the spec of each
+// pak is read from <BaseDir>/<id>/spec.yml.
 // This method is used when the Repository is being used as a SourceRepository.
 // This method will return an error for Repositories used as local storage.
 func (r *Repository) List(ctx context.Context) (pak.SpecIndex, error) {
-	return r.getIndex(ctx)
+	return r.source().List(ctx)
+}
+
+// ListSummaries returns the summary of every pak in the index. Specs that are invalid are omitted.
+// This method is used when the Repository is being used as a SourceRepository.
+func (r *Repository) ListSummaries(ctx context.Context) (pak.SpecIndex, error) {
+	return r.source().ListSummaries(ctx)
 }
 
 // GetFile gets the file with the given name for the given id and version.
 // This method is used when the Repository is being used as a SourceRepository.
 // This method will return an error for Repositories used as local storage.
 func (r *Repository) GetFile(ctx context.Context, id string, version string, file string) (io.ReadCloser, error) {
-	f, err := os.Open(r.remoteFilePath(id, version, file))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get file: %w", err)
-	}
-
-	return f, nil
-}
-
-func (r *Repository) remoteFilePath(id string, version string, file string) string {
-	return filepath.Join(r.BaseDir, id, version, file)
+	return r.source().GetFile(ctx, id, version, file)
 }
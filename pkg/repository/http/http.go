@@ -3,14 +3,18 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/WithoutPants/pakman/pkg/pak"
-	"github.com/WithoutPants/pakman/pkg/pak/yaml"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
 )
 
 const (
@@ -18,6 +22,17 @@ const (
 	ManifestPath = "manifest.yml"
 )
 
+// StatusError is returned when the server responds with an error status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("failed to get remote file %s: %s", e.URL, e.Status)
+}
+
 // Repository is a HTTP based repository.
 // The index is stored at index.yml in the root of the repository. For example, if the BaseURL is https://example.com/paks, the index file located at https://example.com/paks/index.yml.
 //
@@ -25,7 +40,24 @@ const (
 //
 // Pak files are stored in the same location as the manifest file.
 //
+// If Codec is nil, the index and manifest files may be in any registered encoding, such as json.
+// The index is requested using the preferred extension of each codec in turn (index.yml, then index.json)
+// until one is found. The encoding of a response is determined by its Content-Type header if recognised,
+// otherwise by the extension of the requested file. Manifests are first requested using the encoding of the index.
+//
+// If the index is sharded, it only contains a summary of each pak, and the full spec of each pak is stored at
+// <BaseURL>/<id>/spec.yml. Specs are requested as they are needed.
+//
+// Compressed responses are requested using the Accept-Encoding header. Compressed variants of the index and
+// manifest files, such as index.yml.zst and index.yml.gz, are only requested if the uncompressed file is not found.
+// The variant of the index that was found is used for the spec, manifest and change files from then on, so that
+// each file normally takes a single request. Both are decompressed transparently, up to MaxDecompressedSize.
+//
 // The index is cached for the duration of CacheTTL. The first request after the cache expires will cause the index to be reloaded.
+// If the index has a revision, the changes since that revision are requested from <BaseURL>/changes/<revision>.yml
+// instead. The full index is reloaded if the changes are not found.
+//
+// A Repository may be used concurrently.
 type Repository struct {
 	BaseURL url.URL
 	Client  *http.Client
@@ -35,8 +67,31 @@ type Repository struct {
 	// expires will cause the index to be reloaded.
 	CacheTTL time.Duration
 
-	cachedIndex *pak.SpecIndex
-	cacheTime   time.Time
+	// Codec is the encoding of the index and manifest files.
+	// If nil, the encoding is detected.
+	Codec codec.Codec
+
+	// DisableCompression disables requesting compressed variants of the index
+	// and manifest files, and compressed responses.
+	DisableCompression bool
+
+	// MaxDecompressedSize is the limit on the decompressed size of a response.
+	// If zero, compress.DefaultMaxSize is used.
+	MaxDecompressedSize int64
+
+	// loading serialises reading and updating the cached index.
+	loading sync.Mutex
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// detected is the variant of the last index file read.
+	detected *variant
+
+	cachedIndex    *pak.Index
+	cachedIndexErr *pak.IndexError
+	cachedSpecs    map[string]*pak.Spec
+	cacheTime      time.Time
 }
 
 // New creates a new Repository. If client is nil then http.DefaultClient is used.
@@ -50,16 +105,21 @@ func New(baseURL url.URL, client *http.Client) *Repository {
 	}
 }
 
+// String returns the BaseURL of the repository.
+func (r *Repository) String() string {
+	return r.BaseURL.String()
+}
+
 // GetManifest gets the manifest for the given id and version.
 func (r *Repository) GetManifest(ctx context.Context, id string, version string) (*pak.Manifest, error) {
-	f, err := r.getFile(ctx, r.manifestPath(id, version))
+	v, f, err := r.getEncoded(ctx, pak.ManifestName, id, version)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get manifest file: %w", err)
 	}
 
 	defer f.Close()
 
-	manifest, err := yaml.ReadManifest(f)
+	manifest, err := v.codec.ReadManifest(f)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read manifest file: %w", err)
 	}
@@ -71,96 +131,405 @@ func (r *Repository) GetManifest(ctx context.Context, id string, version string)
 	return manifest, nil
 }
 
-func (r *Repository) manifestPath(id string, version string) url.URL {
-	u := r.BaseURL
-	u.Path, _ = url.JoinPath(u.Path, id, version, ManifestPath)
-	return u
-}
-
 // GetSpec gets the spec for the given id and version.
 // If version is empty then the latest version is returned.
+// If the index is sharded, the spec is read from the spec file of the pak.
 func (r *Repository) GetSpec(ctx context.Context, id string) (*pak.Spec, error) {
-	index, err := r.getIndex(ctx)
+	index, invalid, err := r.getIndex(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get index: %w", err)
 	}
 
-	spec, ok := index[id]
+	spec, ok := index.Paks[id]
 	if !ok {
+		if invalid != nil {
+			return nil, invalid.Spec(id)
+		}
 		return nil, nil
 	}
 
+	if index.Sharded {
+		return r.getSpecFile(ctx, index, id)
+	}
+
 	return &spec, nil
 }
 
-// List returns all specs in the repository.
-func (r *Repository) List(ctx context.Context) (pak.SpecIndex, error) {
-	return r.getIndex(ctx)
-}
+// getSpecFile returns the cached spec file of the given pak, reading it if necessary.
+// The spec is only cached if index is still the cached index once it has been read.
+func (r *Repository) getSpecFile(ctx context.Context, index *pak.Index, id string) (*pak.Spec, error) {
+	r.mu.Lock()
+	spec, ok := r.cachedSpecs[id]
+	r.mu.Unlock()
+
+	if ok {
+		return spec, nil
+	}
+
+	v, f, err := r.getEncoded(ctx, pak.SpecName, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spec file: %w", err)
+	}
+
+	defer f.Close()
 
-func (r *Repository) checkCacheExpired() {
-	if r.cachedIndex == nil {
-		return
+	spec, err = v.codec.ReadSpec(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec file: %w", err)
 	}
 
-	if time.Since(r.cacheTime) > r.CacheTTL {
-		r.cachedIndex = nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the index may have been reloaded while the spec was read
+	if r.cachedIndex == index {
+		if r.cachedSpecs == nil {
+			r.cachedSpecs = make(map[string]*pak.Spec)
+		}
+		r.cachedSpecs[id] = spec
 	}
+
+	return spec, nil
 }
 
-func (r *Repository) getIndex(ctx context.Context) (pak.SpecIndex, error) {
-	r.checkCacheExpired()
+// List returns the full spec of every pak in the repository. Specs that are
+// invalid in the index are omitted. If the index is sharded, the spec file of
+// each pak that is not cached is requested.
+func (r *Repository) List(ctx context.Context) (pak.SpecIndex, error) {
+	index, _, err := r.getIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if r.cachedIndex != nil {
-		return *r.cachedIndex, nil
+	if !index.Sharded {
+		return index.Paks, nil
 	}
 
-	u := r.BaseURL
-	var err error
-	u.Path, err = url.JoinPath(u.Path, IndexPath)
+	ret := make(pak.SpecIndex, len(index.Paks))
+	for id := range index.Paks {
+		spec, err := r.getSpecFile(ctx, index, id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+
+		ret[id] = *spec
+	}
+
+	return ret, nil
+}
+
+// ListSummaries returns the summary of every pak in the index. Specs that are invalid are omitted.
+func (r *Repository) ListSummaries(ctx context.Context) (pak.SpecIndex, error) {
+	index, _, err := r.getIndex(ctx)
 	if err != nil {
-		// shouldn't happen
 		return nil, err
 	}
 
-	f, err := r.getFile(ctx, u)
+	ret := make(pak.SpecIndex, len(index.Paks))
+	for id, spec := range index.Paks {
+		ret[id] = spec.Summary()
+	}
+
+	return ret, nil
+}
+
+// cached returns the cached index and its invalid specs, and whether the cache has not expired.
+func (r *Repository) cached() (*pak.Index, *pak.IndexError, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cachedIndex, r.cachedIndexErr, time.Since(r.cacheTime) <= r.CacheTTL
+}
+
+// getIndex returns the cached index, reading it if necessary.
+// If the cached index has expired and has a revision, the changes since that
+// revision are requested, falling back to reading the full index.
+// Invalid specs in the index are not treated as an error, and are returned as an IndexError.
+func (r *Repository) getIndex(ctx context.Context) (*pak.Index, *pak.IndexError, error) {
+	r.loading.Lock()
+	defer r.loading.Unlock()
+
+	if index, invalid, fresh := r.cached(); index != nil {
+		if fresh {
+			return index, invalid, nil
+		}
+
+		if index.Revision != 0 {
+			if index, invalid, ok := r.updateIndex(ctx, index, invalid); ok {
+				return index, invalid, nil
+			}
+		}
+	}
+
+	v, f, err := r.getEncoded(ctx, pak.IndexName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get index file: %w", err)
+		return nil, nil, fmt.Errorf("failed to get index file: %w", err)
 	}
 
 	defer f.Close()
 
-	index, err := yaml.ReadSpecIndex(f)
+	index, err := v.codec.ReadIndex(f)
+	invalid, err := pak.SplitIndexError(err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read index file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read index file: %w", err)
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.detected = &v
 	r.cachedIndex = index
+	r.cachedIndexErr = invalid
+	r.cachedSpecs = nil
 	r.cacheTime = time.Now()
 
-	return *index, nil
+	return index, invalid, nil
 }
 
-func (r *Repository) getFile(ctx context.Context, u url.URL) (io.ReadCloser, error) {
+// updateIndex applies the changes made since the revision of the cached index,
+// returning the updated index and its invalid specs.
+// It returns false if the changes could not be read or applied.
+func (r *Repository) updateIndex(ctx context.Context, cached *pak.Index, cachedErr *pak.IndexError) (*pak.Index, *pak.IndexError, bool) {
+	// change files are written in the encoding of the index
+	r.mu.Lock()
+	detected := r.detected
+	r.mu.Unlock()
+
+	if detected == nil {
+		return nil, nil, false
+	}
+
+	v, f, err := r.getVariant(ctx, []variant{*detected}, strconv.FormatInt(cached.Revision, 10), pak.ChangesDir)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	defer f.Close()
+
+	delta, err := v.codec.ReadIndexDelta(f)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	index, ok := cached.Apply(*delta)
+	if !ok {
+		return nil, nil, false
+	}
+
+	changed := make(map[string]struct{})
+	for id := range delta.Changed {
+		changed[id] = struct{}{}
+	}
+	for _, id := range delta.Removed {
+		changed[id] = struct{}{}
+	}
+
+	// changed specs are no longer invalid
+	invalid := cachedErr
+	if cachedErr != nil {
+		invalid = &pak.IndexError{}
+		for _, e := range cachedErr.Invalid {
+			if _, ok := changed[e.ID]; !ok {
+				invalid.Invalid = append(invalid.Invalid, e)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range changed {
+		delete(r.cachedSpecs, id)
+	}
+
+	r.cachedIndex = &index
+	r.cachedIndexErr = invalid
+	r.cacheTime = time.Now()
+
+	return &index, invalid, true
+}
+
+// variant is a codec and compression encoding of a file.
+type variant struct {
+	codec codec.Codec
+	// encoding is the compression of the file, or nil if it is not compressed.
+	encoding *compress.Encoding
+}
+
+func (v variant) name(base string) string {
+	ret := base + codec.Extension(v.codec)
+	if v.encoding != nil {
+		ret += v.encoding.Extension
+	}
+	return ret
+}
+
+// variants returns the variants to try when requesting an encoded file, in
+// order. The variant of the last index file read is tried first. Uncompressed
+// variants are tried before compressed variants, since compressed responses are
+// requested using the Accept-Encoding header.
+func (r *Repository) variants() []variant {
+	codecs := []codec.Codec{r.Codec}
+	if r.Codec == nil {
+		codecs = codec.All()
+	}
+
+	encodings := []*compress.Encoding{nil}
+	if !r.DisableCompression {
+		for _, e := range compress.Encodings() {
+			e := e
+			encodings = append(encodings, &e)
+		}
+	}
+
+	r.mu.Lock()
+	detected := r.detected
+	r.mu.Unlock()
+
+	var ret []variant
+	if detected != nil {
+		ret = append(ret, *detected)
+	}
+
+	for _, e := range encodings {
+		for _, c := range codecs {
+			v := variant{codec: c, encoding: e}
+			if detected == nil || v.name("") != detected.name("") {
+				ret = append(ret, v)
+			}
+		}
+	}
+
+	return ret
+}
+
+// getEncoded requests the file with the given base name in the directory
+// made up of elem, trying each variant until one is found. It returns the
+// variant to decode the file with and the decompressed response body.
+func (r *Repository) getEncoded(ctx context.Context, base string, elem ...string) (variant, io.ReadCloser, error) {
+	return r.getVariant(ctx, r.variants(), base, elem...)
+}
+
+// getVariant is getEncoded, trying only the given variants.
+func (r *Repository) getVariant(ctx context.Context, variants []variant, base string, elem ...string) (variant, io.ReadCloser, error) {
+	var err error
+	for _, v := range variants {
+		u := r.BaseURL
+		u.Path, _ = url.JoinPath(u.Path, append(elem, v.name(base))...)
+
+		var resp *http.Response
+		resp, err = r.get(ctx, u)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return variant{}, nil, err
+		}
+
+		body, err := r.body(resp, v.encoding)
+		if err != nil {
+			return variant{}, nil, err
+		}
+
+		if sniffed := codec.ForContentType(resp.Header.Get("Content-Type")); sniffed != nil {
+			v.codec = sniffed
+		}
+
+		return v, body, nil
+	}
+
+	return variant{}, nil, err
+}
+
+func (r *Repository) get(ctx context.Context, u url.URL) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		// shouldn't happen
 		return nil, err
 	}
 
+	// the response is decompressed by body
+	if !r.DisableCompression {
+		req.Header.Set("Accept-Encoding", compress.AcceptEncoding())
+	}
+
 	resp, err := r.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get remote file: %w", err)
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("failed to get remote file: %s", resp.Status)
+		resp.Body.Close()
+		return nil, StatusError{URL: u.String(), StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
-	return resp.Body, nil
+	return resp, nil
+}
+
+// body returns the decompressed body of the response. The body is
+// decompressed according to its Content-Encoding header, and then according to
+// the compression of the requested file, if any.
+func (r *Repository) body(resp *http.Response, file *compress.Encoding) (io.ReadCloser, error) {
+	body := resp.Body
+
+	var contentEncoding *compress.Encoding
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		contentEncoding = compress.ForName(ce)
+		if contentEncoding == nil {
+			body.Close()
+			return nil, fmt.Errorf("unsupported content encoding %q", ce)
+		}
+
+		var err error
+		body, err = contentEncoding.NewReader(body, r.MaxDecompressedSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// some servers set the Content-Encoding of compressed files to their compression
+	if file != nil && (contentEncoding == nil || contentEncoding.Name != file.Name) {
+		return file.NewReader(body, r.MaxDecompressedSize)
+	}
+
+	return body, nil
+}
+
+// getFile requests the file at u. If it is not found, the file with the
+// extension of each supported compression encoding is requested in turn, and
+// is decompressed.
+func (r *Repository) getFile(ctx context.Context, u url.URL) (io.ReadCloser, error) {
+	resp, err := r.get(ctx, u)
+	if isNotFound(err) {
+		for _, e := range compress.Encodings() {
+			e := e
+			cu := u
+			cu.Path += e.Extension
+
+			cResp, cErr := r.get(ctx, cu)
+			if cErr == nil {
+				return r.body(cResp, &e)
+			}
+			if !isNotFound(cErr) {
+				return nil, cErr
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.body(resp, nil)
+}
+
+func isNotFound(err error) bool {
+	var statusErr StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
 }
 
 // GetFile gets the file for the given id, version and file.
+// If the file does not exist, a compressed variant of it, such as <file>.zst, is read and decompressed.
 func (r *Repository) GetFile(ctx context.Context, id string, version string, file string) (io.ReadCloser, error) {
 	f, err := r.getFile(ctx, r.filePath(id, version, file))
 	if err != nil {
@@ -0,0 +1,264 @@
+// Package iofs implements a read-only source repository over an fs.FS, such
+// as an embed.FS compiled into the host application or the zip.Reader of a
+// zip file.
+package iofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/WithoutPants/pakman/pkg/pak"
+	"github.com/WithoutPants/pakman/pkg/pak/codec"
+	"github.com/WithoutPants/pakman/pkg/repository/compress"
+)
+
+// Repository is a source repository that reads from an fs.FS, using the same
+// layout as the fs repository:
+//
+//	index.yml
+//	<id>/spec.yml
+//	<id>/<version>/manifest.yml
+//	<id>/<version>/<file>
+//
+// Files with the extension of another registered codec, such as index.json,
+// are also read. If a file does not exist, a compressed variant of it, such
+// as index.yml.zst or index.yml.gz, is read and decompressed.
+//
+// The repository must be at the root of the FS. Use fs.Sub to read a
+// repository from a subdirectory, for example of an embed.FS.
+type Repository struct {
+	FS fs.FS
+
+	// Name describes the repository, such as the path of a zip file. It is
+	// returned by String, and recorded as the source of installed paks in the history.
+	Name string
+
+	// MaxDecompressedSize is the limit on the decompressed size of compressed
+	// files. If zero, compress.DefaultMaxSize is used.
+	MaxDecompressedSize int64
+}
+
+// New returns a Repository reading from fsys.
+func New(fsys fs.FS) *Repository {
+	return &Repository{FS: fsys}
+}
+
+// String returns the Name of the repository.
+func (r *Repository) String() string {
+	return r.Name
+}
+
+// GetManifest gets the manifest for the given id and version.
+func (r *Repository) GetManifest(ctx context.Context, id string, version string) (*pak.Manifest, error) {
+	f, c, err := r.openEncoded(join(id, version), pak.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	manifest, err := c.ReadManifest(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// GetSpec gets the spec for the given id.
+// If the index is sharded, the spec is read from <id>/spec.yml.
+func (r *Repository) GetSpec(ctx context.Context, id string) (*pak.Spec, error) {
+	index, invalid, err := r.getIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index: %w", err)
+	}
+
+	spec, ok := index.Paks[id]
+	if !ok {
+		if invalid != nil {
+			return nil, invalid.Spec(id)
+		}
+		return nil, nil
+	}
+
+	if index.Sharded {
+		return r.getSpecFile(id)
+	}
+
+	return &spec, nil
+}
+
+func (r *Repository) getSpecFile(id string) (*pak.Spec, error) {
+	f, c, err := r.openEncoded(id, pak.SpecName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spec file: %w", err)
+	}
+
+	defer f.Close()
+
+	spec, err := c.ReadSpec(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec file: %w", err)
+	}
+
+	return spec, nil
+}
+
+// Index reads the index of the repository.
+// Invalid specs in the index are omitted, and a pak.IndexError is returned along with the index.
+func (r *Repository) Index(ctx context.Context) (*pak.Index, error) {
+	index, invalid, err := r.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if invalid != nil {
+		return index, *invalid
+	}
+
+	return index, nil
+}
+
+// getIndex reads the index. Invalid specs in the index are not treated as an
+// error, and are returned as an IndexError.
+func (r *Repository) getIndex() (*pak.Index, *pak.IndexError, error) {
+	f, c, err := r.openEncoded("", pak.IndexName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get index file: %w", err)
+	}
+
+	defer f.Close()
+
+	index, err := c.ReadIndex(f)
+	invalid, err := pak.SplitIndexError(err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index file: %w", err)
+	}
+
+	return index, invalid, nil
+}
+
+// GetIndexDelta reads the changes to the index since the given revision from changes/<from>.yml.
+func (r *Repository) GetIndexDelta(ctx context.Context, from int64) (*pak.IndexDelta, error) {
+	f, c, err := r.openEncoded(pak.ChangesDir, strconv.FormatInt(from, 10))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return c.ReadIndexDelta(f)
+}
+
+// List returns the full spec of every pak in the repository. Specs that are
+// invalid in the index are omitted. If the index is sharded, the spec of each
+// pak is read from <id>/spec.yml.
+func (r *Repository) List(ctx context.Context) (pak.SpecIndex, error) {
+	index, _, err := r.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if !index.Sharded {
+		return index.Paks, nil
+	}
+
+	ret := make(pak.SpecIndex, len(index.Paks))
+	for id := range index.Paks {
+		spec, err := r.getSpecFile(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+
+		ret[id] = *spec
+	}
+
+	return ret, nil
+}
+
+// ListSummaries returns the summary of every pak in the index. Specs that are invalid are omitted.
+func (r *Repository) ListSummaries(ctx context.Context) (pak.SpecIndex, error) {
+	index, _, err := r.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(pak.SpecIndex, len(index.Paks))
+	for id, spec := range index.Paks {
+		ret[id] = spec.Summary()
+	}
+
+	return ret, nil
+}
+
+// GetFile gets the file with the given name for the given id and version.
+func (r *Repository) GetFile(ctx context.Context, id string, version string, file string) (io.ReadCloser, error) {
+	f, err := r.openCompressed(join(id, version, file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	return f, nil
+}
+
+// openEncoded opens the file in dir with the given name and the extension of
+// a registered codec, returning the codec to read it with. Codecs are tried in
+// order of registration. If the file does not exist, a compressed variant of
+// it is opened and decompressed.
+func (r *Repository) openEncoded(dir string, name string) (io.ReadCloser, codec.Codec, error) {
+	var firstErr error
+	for _, c := range codec.All() {
+		for _, ext := range c.Extensions() {
+			f, err := r.openCompressed(join(dir, name+ext))
+			if err == nil {
+				return f, c, nil
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return nil, nil, firstErr
+}
+
+// openCompressed opens the file at the given path. If it does not exist, the
+// file with the extension of each supported compression encoding is tried
+// in turn, and is decompressed.
+func (r *Repository) openCompressed(name string) (io.ReadCloser, error) {
+	f, err := r.FS.Open(name)
+	if err == nil {
+		return f, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, e := range compress.Encodings() {
+		cf, cErr := r.FS.Open(name + e.Extension)
+		if cErr == nil {
+			return e.NewReader(cf, r.MaxDecompressedSize)
+		}
+	}
+
+	return nil, err
+}
+
+// join joins the non-empty elements with slashes. Unlike path.Join, the result
+// is not cleaned, so ids, versions and file names containing ".." are rejected
+// by the FS as invalid paths rather than resolving to another file.
+func join(elem ...string) string {
+	var ret []string
+	for _, e := range elem {
+		if e != "" {
+			ret = append(ret, e)
+		}
+	}
+
+	return strings.Join(ret, "/")
+}